@@ -0,0 +1,102 @@
+package b2
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+)
+
+// ClientAPI is the set of methods of *Client, so that code using a Client can
+// depend on it instead, and be tested with a fake implementation.
+type ClientAPI interface {
+	LoginInfo(ctx context.Context, refresh bool) (*LoginInfo, error)
+	ServerTime(ctx context.Context) (time.Time, error)
+	Reauthorize(ctx context.Context, accountID, applicationKey string) error
+	WithSession(id string) *Client
+	PermissionsFor(bucketName, key string) PathPermissions
+
+	BucketByID(id string) *Bucket
+	BucketByName(ctx context.Context, name string, createIfNotExists bool) (*BucketInfo, error)
+	Buckets(ctx context.Context, name string) ([]*BucketInfo, error)
+	CreateBucket(ctx context.Context, name string, allPublic bool) (*BucketInfo, error)
+	UpdateBucket(ctx context.Context, bucketID string, o UpdateBucketOptions) (*BucketInfo, error)
+	AccountSummary(ctx context.Context, n int) ([]BucketSummary, error)
+
+	DownloadFile(ctx context.Context, o DownloadOptions) (io.ReadCloser, *FileInfo, error)
+	DownloadFileResponse(ctx context.Context, o DownloadOptions) (*http.Response, *FileInfo, error)
+	DownloadTo(ctx context.Context, o DownloadOptions, w io.Writer) (*FileInfo, error)
+	DownloadInto(ctx context.Context, o DownloadOptions, buf []byte) (n int, fi *FileInfo, err error)
+	DownloadVersionTo(ctx context.Context, fileID string, w io.Writer) (*FileInfo, error)
+	DownloadToFile(ctx context.Context, o DownloadOptions, path string) (*FileInfo, error)
+	DownloadToFileOpen(ctx context.Context, o DownloadOptions, path string) (io.ReadSeekCloser, *FileInfo, error)
+	ResumableDownload(ctx context.Context, o DownloadOptions, destPath, statePath string) (*FileInfo, error)
+	DownloadVerified(ctx context.Context, o DownloadOptions, w io.Writer) (*FileInfo, error)
+	DownloadVerifiedParts(ctx context.Context, o DownloadOptions, parts []PartInfo, w io.Writer) (*FileInfo, error)
+	DownloadRanges(ctx context.Context, o DownloadOptions, ranges []Range) ([]io.ReadCloser, error)
+	DownloadS3(ctx context.Context, bucket, key string) (io.ReadCloser, *FileInfo, error)
+	DownloadFileByID(ctx context.Context, id string) (io.ReadCloser, *FileInfo, error)
+	DownloadFileByName(ctx context.Context, bucket, file string) (io.ReadCloser, *FileInfo, error)
+	OpenLines(ctx context.Context, o DownloadOptions) (*Lines, *FileInfo, error)
+
+	GetFileInfoByID(ctx context.Context, id string) (*FileInfo, error)
+	DeleteFile(ctx context.Context, id, name string) error
+	CopyFile(ctx context.Context, sourceID, name string, o CopyOptions) (*FileInfo, error)
+	CopyFileMergeMetadata(ctx context.Context, sourceID, destName string, add map[string]string) (*FileInfo, error)
+	MigrateBucket(ctx context.Context, srcBucketID, dstBucketID string, o MigrateOptions) error
+	FinishLargeFile(ctx context.Context, fileID string, partSHA1s []string) (*FileInfo, error)
+	ListParts(ctx context.Context, fileID string) ([]PartInfo, error)
+}
+
+// BucketAPI is the set of methods of *Bucket, so that code using a Bucket can
+// depend on it instead, and be tested with a fake implementation.
+type BucketAPI interface {
+	Delete(ctx context.Context) error
+	DeleteWhenEmpty(ctx context.Context, timeout time.Duration) error
+	IsEmpty(ctx context.Context) (bool, error)
+	TotalSize(ctx context.Context, includeAllVersions bool) (files, bytes int64, err error)
+	Verify(ctx context.Context, n int, fn func(fi *FileInfo, ok bool, err error)) error
+
+	Upload(ctx context.Context, r io.Reader, name, mimeType string, metadata map[string]string) (*FileInfo, error)
+	UploadFile(ctx context.Context, r io.Reader, o UploadOptions) (*FileInfo, error)
+	UploadWithSHA1(ctx context.Context, r io.Reader, name, mimeType, sha1Sum string, length int64, metadata map[string]string) (*FileInfo, error)
+	UploadWithSHA1Detailed(ctx context.Context, r io.Reader, name, mimeType, sha1Sum string, length int64, metadata map[string]string) (*FileInfo, *UploadDetail, error)
+	UploadWithSHA1Retry(ctx context.Context, r io.Reader, name, mimeType, sha1Sum string, length int64, metadata map[string]string) (*FileInfo, error)
+	UploadWithSidecar(ctx context.Context, r io.Reader, name, mimeType string, metadata map[string]string) (*FileInfo, error)
+	UploadLarge(ctx context.Context, r io.ReadSeeker, o UploadOptions, lo LargeUploadOptions) (*FileInfo, error)
+	UploadStream(ctx context.Context, r io.Reader, o UploadOptions, lo LargeUploadOptions) (*FileInfo, error)
+	StartLargeFile(ctx context.Context, name, mimeType string, metadata map[string]string) (*LargeFile, error)
+	ResumeLargeFile(ctx context.Context, fileID string) (*LargeFile, error)
+	CancelLargeFile(ctx context.Context, fileID string) error
+
+	GetFileInfoByName(ctx context.Context, name string) (*FileInfo, error)
+	ExistingNames(ctx context.Context, names []string, n int) (map[string]*FileInfo, error)
+	InfoForNames(ctx context.Context, names []string) (map[string]*FileInfo, error)
+	MovePrefix(ctx context.Context, oldPrefix, newPrefix string, n int) (moved int, err error)
+	DownloadLatest(ctx context.Context, prefix string, w io.Writer) (*FileInfo, error)
+	WaitForFile(ctx context.Context, name string, timeout time.Duration) (*FileInfo, error)
+	ListFiles(ctx context.Context, o ListOptions) *Listing
+	ListFileVersions(ctx context.Context, o ListOptions) *Listing
+	ListGroupedVersions(ctx context.Context, o ListOptions, fn func(name string, versions []*FileInfo) error) error
+	ListDir(ctx context.Context, dir, delimiter string) (folders []string, files []*FileInfo, err error)
+	IsPrefix(ctx context.Context, name string) (bool, error)
+	Manifest(ctx context.Context, prefix string, w io.Writer) error
+	GetDownloadAuthorization(ctx context.Context, prefix string, ttl time.Duration) (*DownloadAuthorization, error)
+	SignedURL(ctx context.Context, fileName string, ttl time.Duration) (string, error)
+	HideFile(ctx context.Context, name string) (*FileInfo, error)
+	HideFileIfVisible(ctx context.Context, name string) (marker *FileInfo, hidden bool, err error)
+	ListUnfinishedLargeFiles(ctx context.Context, o ListOptions) *Listing
+	CancelStaleLargeFiles(ctx context.Context, olderThan time.Duration) (canceled int, err error)
+	VersionStats(ctx context.Context, name string) ([]VersionStat, error)
+	GetFileVersionAsOf(ctx context.Context, name string, t time.Time) (*FileInfo, error)
+	FileStats(ctx context.Context, name string) (*FileStats, error)
+
+	UploadTree(ctx context.Context, localDir, remotePrefix string, o UploadTreeOptions) (UploadTreeSummary, error)
+	Sync(ctx context.Context, localDir, remotePrefix string, o SyncOptions) ([]SyncAction, error)
+	DownloadTree(ctx context.Context, remotePrefix, localDir string, o DownloadTreeOptions) (DownloadTreeSummary, error)
+}
+
+var (
+	_ ClientAPI = (*Client)(nil)
+	_ BucketAPI = (*Bucket)(nil)
+)
@@ -23,11 +23,18 @@
 // behaviors are transparently exposed.  Upload can be used multiple times
 // with the same name, ListFiles will only return the latest version of
 // non-hidden files, and ListFilesVersions will return all files and versions.
+// (*Bucket).HideFile hides a file without deleting its versions.
+//
+// # Large files
+//
+// Files larger than 5GB must be uploaded in parts. Start one with
+// (*Bucket).StartLargeFile, upload each part with (*LargeFile).UploadPart,
+// and call (*LargeFile).Finish once all parts are uploaded, or
+// (*LargeFile).Cancel to abandon the upload and delete its parts.
 //
 // # Unsupported APIs
 //
-// Large files (b2_*_large_file, b2_*_part), b2_get_download_authorization,
-// b2_hide_file, b2_update_bucket.
+// b2_copy_part.
 //
 // # Debug mode
 //
@@ -46,10 +53,11 @@ import (
 	"log"
 	"net/http"
 	"net/http/httptrace"
-	"net/url"
 	"os"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 func addTracing(req *http.Request) *http.Request {
@@ -68,21 +76,26 @@ type Error struct {
 	Code    string
 	Message string
 	Status  int
+
+	// RetryAfter is the delay requested by the Retry-After header of the
+	// response, usually along with status 429 or 503, or zero.
+	RetryAfter time.Duration `json:"-"`
 }
 
 func (e Error) Error() string {
 	return fmt.Sprintf("b2 remote error [%s]: %s", e.Code, e.Message)
 }
 
-// UnwrapError attempts to extract the Error that caused err. If there is no
-// Error object to unwrap, ok is false and err is nil. That does not mean that
-// the original error should be ignored.
+// UnwrapError attempts to extract the Error that caused err, following the
+// chain of wrapped errors, like errors.As. If there is no Error object to
+// unwrap, ok is false and err is nil. That does not mean that the original
+// error should be ignored.
+//
+// The Error that made finishing a large file fail is extracted from a
+// *FinishError.
 func UnwrapError(err error) (b2Err *Error, ok bool) {
-	if e, ok := err.(*url.Error); ok {
-		err = e.Err
-	}
-	if e, ok := err.(*Error); ok {
-		return e, true
+	if errors.As(err, &b2Err) {
+		return b2Err, true
 	}
 	return nil, false
 }
@@ -104,6 +117,69 @@ type LoginInfo struct {
 	// AuthorizationToken is the value to pass in the Authorization
 	// header of all private calls. This is valid for at most 24 hours.
 	AuthorizationToken string
+
+	// S3ApiURL is the base URL of the S3-compatible API, see DownloadS3.
+	S3ApiURL string
+
+	// RecommendedPartSize is the part size, in bytes, that B2 recommends
+	// for large files for the best upload performance.
+	RecommendedPartSize int64
+	// AbsoluteMinimumPartSize is the smallest size, in bytes, of all
+	// the parts of a large file but the last one.
+	AbsoluteMinimumPartSize int64
+
+	// Allowed describes what the application key can access.
+	Allowed Allowed
+}
+
+// Allowed holds the capabilities and restrictions of an application key.
+type Allowed struct {
+	Capabilities []string // like "listFiles" or "writeFiles"
+
+	// If BucketID is set, the key can only access that bucket.
+	BucketID   string
+	BucketName string
+
+	// If NamePrefix is set, the key can only access files with that prefix.
+	NamePrefix string
+}
+
+// PathPermissions are the file operations allowed on a path.
+type PathPermissions struct {
+	Read, Write, Delete, List bool
+}
+
+// PermissionsFor returns the file operations the application key allows on
+// the file (or listing prefix) key in the named bucket. It does not perform
+// any network operation.
+func (li *LoginInfo) PermissionsFor(bucketName, key string) PathPermissions {
+	a := li.Allowed
+	if a.BucketName != "" && a.BucketName != bucketName {
+		return PathPermissions{}
+	}
+	if !strings.HasPrefix(key, a.NamePrefix) {
+		return PathPermissions{}
+	}
+	var p PathPermissions
+	for _, c := range a.Capabilities {
+		switch c {
+		case "readFiles":
+			p.Read = true
+		case "writeFiles":
+			p.Write = true
+		case "deleteFiles":
+			p.Delete = true
+		case "listFiles":
+			p.List = true
+		}
+	}
+	return p
+}
+
+// PermissionsFor is like (*LoginInfo).PermissionsFor, using the LoginInfo
+// currently in use.
+func (c *Client) PermissionsFor(bucketName, key string) PathPermissions {
+	return c.auth().loginInfo.Load().(*LoginInfo).PermissionsFor(bucketName, key)
 }
 
 // LoginInfo returns the LoginInfo object currently in use. If refresh is
@@ -117,7 +193,26 @@ func (c *Client) LoginInfo(ctx context.Context, refresh bool) (*LoginInfo, error
 			return nil, err
 		}
 	}
-	return c.loginInfo.Load().(*LoginInfo), nil
+	return c.auth().loginInfo.Load().(*LoginInfo), nil
+}
+
+// ServerTime returns the time of the B2 servers, read from the Date header of
+// the response to a b2_list_buckets call, so that clock skew can be detected
+// before time-sensitive operations. The Date header has a precision of one
+// second, and the request latency adds to the error.
+func (c *Client) ServerTime(ctx context.Context) (time.Time, error) {
+	li := c.auth().loginInfo.Load().(*LoginInfo)
+	params := map[string]interface{}{"accountId": li.AccountID}
+	if len(li.Allowed.BucketID) > 0 {
+		// Keys restricted to a bucket can't list the others.
+		params["bucketId"] = li.Allowed.BucketID
+	}
+	res, err := c.doRequest(ctx, "b2_list_buckets", params)
+	if err != nil {
+		return time.Time{}, err
+	}
+	drainAndClose(res.Body)
+	return http.ParseTime(res.Header.Get("Date"))
 }
 
 // A Client is an authenticated API client. It is safe for concurrent use and should
@@ -125,38 +220,190 @@ func (c *Client) LoginInfo(ctx context.Context, refresh bool) (*LoginInfo, error
 //
 // The Client handles refreshing authorization tokens transparently.
 type Client struct {
+	// MaxResponseBytes limits the size of the JSON responses to API calls, as
+	// a safety measure against misbehaving endpoints. Reading a larger response
+	// fails with ErrResponseTooLarge. It does not apply to file downloads.
+	// NewClient sets it to 32MB, and 0 means no limit.
+	MaxResponseBytes int64
+
+	// DownloadURLOverride, if set, replaces LoginInfo.DownloadURL as the
+	// base URL for file downloads, for example to route them through a
+	// caching proxy. API calls and uploads are not affected.
+	DownloadURLOverride string
+
+	// TestMode, if set, is sent as the X-Bz-Test-Mode header with every
+	// request, to make B2 simulate failures. See the TestMode* constants.
+	TestMode string
+
+	// RetryPolicy controls the delays between retries. If nil, the default
+	// RetryPolicy is used.
+	RetryPolicy *RetryPolicy
+
+	// SessionMetadataKey, if set on a Client returned by WithSession, like
+	// "job-id", adds the session ID under that key to the metadata of every
+	// file uploaded through it.
+	SessionMetadataKey string
+
+	// LargeFileThreshold is the size above which UploadFile, and so Upload,
+	// uploads files as large files, in parts. NewClient sets it to 100MB.
+	// 0, like any value over 5GB, means the 5GB limit of a single upload.
+	LargeFileThreshold int64
+
+	// DetectContentType, if set, is called by UploadFile, and so Upload,
+	// UploadLarge and UploadStream, when no content type is given, with the
+	// name of the file and its first 512 bytes, or all of it if shorter, to
+	// pick one, for example by recognizing the magic bytes of custom formats.
+	// If it returns "", B2 detects the type, as with "b2/x-auto".
+	//
+	// The first bytes are always available to these methods, since their
+	// bodies are seekable, buffered, or read in parts. UploadWithSHA1 and the
+	// lower-level methods, which can't look ahead in their reader, don't call
+	// it.
+	DetectContentType func(name string, head []byte) string
+
+	// root is the Client this one was made from by WithSession, which holds
+	// the authorization state, or nil. See auth.
+	root    *Client
+	session string
+
 	accountID, applicationKey string
 
 	loginInfo atomic.Value // *LoginInfo
-	// loginMu is held to avoid multiple logins in flight at the same time
+	// loginMu is held to avoid multiple logins in flight at the same time,
+	// and guards accountID and applicationKey
 	loginMu sync.Mutex
 
 	hc *http.Client
+
+	bucketNames sync.Map // bucket ID -> name, see bucketName
+	uploadURLs  sync.Map // bucket ID -> *uploadURLPool, see Bucket.uploadURLs
 }
 
+// Values of Client.TestMode, to check that clients handle failures.
+const (
+	// TestModeFailSomeUploads makes B2 fail a fraction of the uploads.
+	TestModeFailSomeUploads = "fail_some_uploads"
+
+	// TestModeExpireSomeTokens makes B2 expire a fraction of the account
+	// authorization tokens, so that they need to be renewed.
+	TestModeExpireSomeTokens = "expire_some_account_authorization_tokens"
+
+	// TestModeForceCapExceeded makes B2 act as if the account reached its
+	// usage caps.
+	TestModeForceCapExceeded = "force_cap_exceeded"
+)
+
 // NewClient calls b2_authorize_account and returns an authenticated Client.
 // httpClient can be nil, in which case http.DefaultClient will be used.
+//
+// The Transport of httpClient is used for every request, to the API host and
+// to the download and upload hosts returned by B2 alike, so that a custom
+// DialContext or Proxy, for example to control egress, applies to all of them.
 // ctx is used for initial login and is not stored.
+//
+// Like other API calls, b2_authorize_account is retried when B2 is busy,
+// with the default RetryPolicy, or Client.RetryPolicy for later logins. Use
+// NewClientWithPolicy to choose the RetryPolicy of the initial login too.
 func NewClient(ctx context.Context, accountID, applicationKey string, httpClient *http.Client) (*Client, error) {
+	return NewClientWithPolicy(ctx, accountID, applicationKey, httpClient, nil)
+}
+
+// NewClientWithPolicy is like NewClient, but sets Client.RetryPolicy to p
+// before the initial login, which is then retried according to p.
+func NewClientWithPolicy(ctx context.Context, accountID, applicationKey string, httpClient *http.Client, p *RetryPolicy) (*Client, error) {
 	if httpClient == nil {
 		httpClient = http.DefaultClient
 	}
 
 	c := &Client{
-		accountID:      accountID,
-		applicationKey: applicationKey,
-		hc:             httpClient,
+		MaxResponseBytes:   defaultMaxResponseBytes,
+		LargeFileThreshold: defaultLargeFileThreshold,
+		RetryPolicy:        p,
+		accountID:          accountID,
+		applicationKey:     applicationKey,
+		hc:                 httpClient,
 	}
 
 	if err := c.login(ctx, nil); err != nil {
 		return nil, err
 	}
 
-	c.hc.Transport = &transport{t: c.hc.Transport, c: c}
+	// Wrap a copy, so that Clients sharing an http.Client don't send each
+	// other's authorization tokens.
+	hc := *httpClient
+	hc.Transport = &transport{t: httpClient.Transport, c: c}
+	c.hc = &hc
 	return c, nil
 }
 
+// WithSession returns a shallow copy of c tagged with the session ID id, like
+// the ID of a backup run, to correlate the operations made through it: every
+// request it makes is logged with id in debug mode, and uploads carry it in
+// their metadata if SessionMetadataKey is set on the copy.
+//
+// The copy shares the HTTP client, the upload URLs and the authorization of
+// c, including its renewals and Reauthorize, and is safe to use concurrently
+// with it. It starts with the exported fields of c, which can then be changed
+// on the copy alone, TestMode included.
+func (c *Client) WithSession(id string) *Client {
+	s := &Client{
+		MaxResponseBytes:    c.MaxResponseBytes,
+		DownloadURLOverride: c.DownloadURLOverride,
+		TestMode:            c.TestMode,
+		RetryPolicy:         c.RetryPolicy,
+		SessionMetadataKey:  c.SessionMetadataKey,
+		LargeFileThreshold:  c.LargeFileThreshold,
+		DetectContentType:   c.DetectContentType,
+		root:                c.auth(),
+		session:             id,
+		hc:                  c.hc,
+	}
+	if t, ok := c.hc.Transport.(*transport); ok {
+		// Wrap the same transport for the copy, so that its TestMode is used.
+		hc := *c.hc
+		hc.Transport = &transport{t: t.t, c: s}
+		s.hc = &hc
+	}
+	return s
+}
+
+// auth returns the Client holding the authorization state of c: c itself,
+// or the Client its session was made from.
+func (c *Client) auth() *Client {
+	if c.root != nil {
+		return c.root
+	}
+	return c
+}
+
+// sessionInfo adds the session ID to the file info info, which it may modify,
+// under SessionMetadataKey, unless the key is already set.
+func (c *Client) sessionInfo(info map[string]string) map[string]string {
+	if c.session == "" || c.SessionMetadataKey == "" {
+		return info
+	}
+	if _, ok := info[c.SessionMetadataKey]; ok {
+		return info
+	}
+	if info == nil {
+		info = make(map[string]string, 1)
+	}
+	info[c.SessionMetadataKey] = c.session
+	return info
+}
+
+// debugf is like the debugf function, adding the session of c, if any.
+func (c *Client) debugf(format string, a ...interface{}) {
+	if c.session != "" {
+		format = "session " + c.session + ": " + format
+	}
+	debugf(format, a...)
+}
+
 func (c *Client) login(ctx context.Context, failedRes *http.Response) error {
+	if c.root != nil {
+		return c.root.login(ctx, failedRes)
+	}
 	c.loginMu.Lock()
 	defer c.loginMu.Unlock()
 
@@ -170,6 +417,48 @@ func (c *Client) login(ctx context.Context, failedRes *http.Response) error {
 		}
 	}
 
+	return c.authorize(ctx)
+}
+
+// Reauthorize replaces the credentials of the Client, for example after a key
+// rotation, and calls b2_authorize_account with them. The new key must belong
+// to the same account. Buckets obtained from the Client stay valid.
+//
+// Calls in flight complete with the old authorization token, while later calls
+// use the new one. If the authorization fails, the old credentials are kept.
+func (c *Client) Reauthorize(ctx context.Context, accountID, applicationKey string) error {
+	if c.root != nil {
+		return c.root.Reauthorize(ctx, accountID, applicationKey)
+	}
+	c.loginMu.Lock()
+	defer c.loginMu.Unlock()
+
+	oldAccountID, oldApplicationKey := c.accountID, c.applicationKey
+	c.accountID, c.applicationKey = accountID, applicationKey
+	if err := c.authorize(ctx); err != nil {
+		c.accountID, c.applicationKey = oldAccountID, oldApplicationKey
+		return err
+	}
+	return nil
+}
+
+// authorize calls b2_authorize_account and stores the new LoginInfo,
+// retrying like doRequest when B2 is busy. loginMu must be held.
+func (c *Client) authorize(ctx context.Context) error {
+	for attempt := 0; ; attempt++ {
+		err := c.authorizeOnce(ctx)
+		if attempt == maxAPIAttempts-1 || !retryableAPIError(ctx, err) {
+			return err
+		}
+		debugf("login: %v, retrying", err)
+		if err := sleep(ctx, c.retryDelay(err, attempt)); err != nil {
+			return err
+		}
+	}
+}
+
+// authorizeOnce calls b2_authorize_account once and stores the new LoginInfo.
+func (c *Client) authorizeOnce(ctx context.Context) error {
 	r, err := http.NewRequestWithContext(ctx, "GET", defaultAPIURL+apiPath+"b2_authorize_account", nil)
 	if err != nil {
 		return err
@@ -181,6 +470,7 @@ func (c *Client) login(ctx context.Context, failedRes *http.Response) error {
 	if err != nil {
 		return err
 	}
+	res.Body = c.limitResponse(res.Body)
 	defer drainAndClose(res.Body)
 	debugf("login: %d", res.StatusCode)
 
@@ -189,12 +479,14 @@ func (c *Client) login(ctx context.Context, failedRes *http.Response) error {
 		if err := json.NewDecoder(res.Body).Decode(b2Err); err != nil {
 			return fmt.Errorf("unknown error during b2_authorize_account: %d", res.StatusCode)
 		}
+		b2Err.Status = res.StatusCode
+		b2Err.RetryAfter = parseRetryAfter(res.Header)
 		return b2Err
 	}
 
 	li := &LoginInfo{}
 	if err := json.NewDecoder(res.Body).Decode(li); err != nil {
-		return fmt.Errorf("failed to decode b2_authorize_account answer: %s", err)
+		return fmt.Errorf("failed to decode b2_authorize_account answer: %w", err)
 	}
 	c.loginInfo.Store(li)
 
@@ -209,7 +501,10 @@ type transport struct {
 
 func (t *transport) RoundTrip(req *http.Request) (res *http.Response, err error) {
 	if req.Header.Get("Authorization") == "" {
-		req.Header.Set("Authorization", t.c.loginInfo.Load().(*LoginInfo).AuthorizationToken)
+		req.Header.Set("Authorization", t.c.auth().loginInfo.Load().(*LoginInfo).AuthorizationToken)
+	}
+	if t.c.TestMode != "" && req.Header.Get("X-Bz-Test-Mode") == "" {
+		req.Header.Set("X-Bz-Test-Mode", t.c.TestMode)
 	}
 
 	req = addTracing(req)
@@ -226,11 +521,25 @@ func (t *transport) RoundTrip(req *http.Request) (res *http.Response, err error)
 	switch res.StatusCode {
 	default:
 		return nil, parseB2Error(res)
+	case http.StatusRequestedRangeNotSatisfiable:
+		return nil, parseRangeError(res)
 	case http.StatusOK, http.StatusPartialContent:
 		return res, err
 	}
 }
 
+// sleep waits for d, or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 var debug = os.Getenv("B2_DEBUG") == "1"
 
 func debugf(format string, a ...interface{}) {
@@ -245,32 +554,92 @@ func (c *Client) doRequest(ctx context.Context, endpoint string, params any) (*h
 		return nil, err
 	}
 
-	apiURL := c.loginInfo.Load().(*LoginInfo).ApiURL
-	U := apiURL + apiPath + endpoint
-	req, err := http.NewRequestWithContext(ctx, "POST", U, bytes.NewBuffer(body))
-	if err != nil {
-		return nil, err
+	post := func() (*http.Response, error) {
+		actx, cancel := c.retryPolicy().attemptContext(ctx)
+		U := c.auth().loginInfo.Load().(*LoginInfo).ApiURL + apiPath + endpoint
+		req, err := http.NewRequestWithContext(actx, "POST", U, bytes.NewBuffer(body))
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		res, err := c.hc.Do(req)
+		if err != nil {
+			cancel()
+			return res, err
+		}
+		res.Body = &cancelBody{ReadCloser: res.Body, cancel: cancel}
+		return res, nil
 	}
-	req.Header.Set("Content-Type", "application/json")
-
-	res, err := c.hc.Do(req)
-	if e, ok := UnwrapError(err); ok && e.Status == http.StatusUnauthorized {
-		if err = c.login(ctx, res); err == nil {
-			U = apiURL + apiPath + endpoint
-			req, err = http.NewRequestWithContext(ctx, "POST", U, bytes.NewBuffer(body))
-			if err != nil {
-				return nil, err
+	var res *http.Response
+	for attempt := 0; ; attempt++ {
+		res, err = post()
+		if e, ok := UnwrapError(err); ok && e.Status == http.StatusUnauthorized {
+			if err = c.login(ctx, res); err == nil {
+				res, err = post()
 			}
-			req.Header.Set("Content-Type", "application/json")
-			res, err = c.hc.Do(req)
+		}
+		if attempt == maxAPIAttempts-1 || !retryableAPIError(ctx, err) {
+			break
+		}
+		c.debugf("%s (%v): %v, retrying", endpoint, params, err)
+		if err := sleep(ctx, c.retryDelay(err, attempt)); err != nil {
+			return nil, err
 		}
 	}
 	if err != nil {
-		debugf("%s (%v): %v", endpoint, params, err)
-	} else {
-		debugf("%s (%v)", endpoint, params)
+		c.debugf("%s (%v): %v", endpoint, params, err)
+		return res, err
+	}
+	c.debugf("%s (%v)", endpoint, params)
+	res.Body = c.limitResponse(res.Body)
+	return res, nil
+}
+
+// retryableAPIError reports whether an API call that failed with err should
+// be attempted again: B2 is busy, or the attempt timed out.
+func retryableAPIError(ctx context.Context, err error) bool {
+	if e, ok := UnwrapError(err); ok {
+		return e.Status == http.StatusTooManyRequests || e.Status == http.StatusServiceUnavailable
+	}
+	return attemptTimedOut(ctx, err)
+}
+
+// maxAPIAttempts is the number of times an API call is attempted when B2 is
+// busy, answering with status 429 or 503.
+const maxAPIAttempts = 5
+
+const defaultMaxResponseBytes = 32 * 1024 * 1024
+
+// ErrResponseTooLarge is returned when reading an API response larger than
+// Client.MaxResponseBytes.
+var ErrResponseTooLarge = errors.New("b2: API response too large")
+
+func (c *Client) limitResponse(body io.ReadCloser) io.ReadCloser {
+	if c.MaxResponseBytes <= 0 {
+		return body
+	}
+	return &limitedBody{ReadCloser: body, n: c.MaxResponseBytes}
+}
+
+// limitedBody is like io.LimitReader, but fails with ErrResponseTooLarge
+// instead of returning io.EOF if there is more to read past the limit.
+type limitedBody struct {
+	io.ReadCloser
+	n int64 // bytes left
+}
+
+func (l *limitedBody) Read(p []byte) (int, error) {
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.ReadCloser.Read(p)
+	if int64(n) > l.n {
+		n, l.n = int(l.n), 0
+		return n, ErrResponseTooLarge
 	}
-	return res, err
+	l.n -= int64(n)
+	return n, err
 }
 
 func parseB2Error(res *http.Response) error {
@@ -283,6 +652,7 @@ func parseB2Error(res *http.Response) error {
 	if err := json.NewDecoder(bytes.NewReader(bb)).Decode(b2Err); err != nil {
 		return fmt.Errorf("unknown error during b2_authorize_account: %d -- %s", res.StatusCode, bb)
 	}
+	b2Err.RetryAfter = parseRetryAfter(res.Header)
 	return b2Err
 }
 
@@ -298,9 +668,6 @@ func drainAndClose(body io.ReadCloser) {
 type Bucket struct {
 	ID string
 	c  *Client
-
-	uploadURLs   []*uploadURL
-	uploadURLsMu sync.Mutex
 }
 
 // BucketInfo is an extended Bucket object with metadata.
@@ -309,6 +676,133 @@ type BucketInfo struct {
 
 	Name string
 	Type string
+
+	Info           map[string]string // bucket info, set by the user
+	LifecycleRules []LifecycleRule
+
+	// Revision is incremented by B2 every time the bucket is updated.
+	// See UpdateBucketOptions.IfRevisionIs.
+	Revision int64
+
+	// FileLockEnabled reports whether the bucket has file lock (object lock)
+	// enabled. DefaultRetention is the retention applied to new files in such
+	// a bucket, zero if none. Both are false or zero if the application key
+	// can't read the file lock configuration.
+	FileLockEnabled  bool
+	DefaultRetention DefaultRetention
+}
+
+// DefaultRetention is the retention B2 applies to new files in a bucket with
+// file lock enabled.
+type DefaultRetention struct {
+	// Mode is "governance" or "compliance", or "" for no default retention.
+	Mode   string
+	Period RetentionPeriod
+}
+
+// RetentionPeriod is the duration of a DefaultRetention.
+type RetentionPeriod struct {
+	Duration int    `json:"duration"`
+	Unit     string `json:"unit"` // "days" or "years"
+}
+
+// params returns the defaultRetention parameter of b2_update_bucket.
+func (r DefaultRetention) params() (map[string]interface{}, error) {
+	switch r.Mode {
+	case "":
+		return map[string]interface{}{"mode": nil}, nil
+	case "governance", "compliance":
+	default:
+		return nil, fmt.Errorf("invalid retention mode %q", r.Mode)
+	}
+	if r.Period.Duration <= 0 || r.Period.Unit != "days" && r.Period.Unit != "years" {
+		return nil, fmt.Errorf("invalid retention period %d %q", r.Period.Duration, r.Period.Unit)
+	}
+	return map[string]interface{}{"mode": r.Mode, "period": r.Period}, nil
+}
+
+// A LifecycleRule makes B2 hide or delete old file versions automatically.
+type LifecycleRule struct {
+	// FileNamePrefix selects the files the rule applies to. "" matches all.
+	FileNamePrefix string `json:"fileNamePrefix"`
+
+	// DaysFromUploadingToHiding, if set, hides files that many days after
+	// upload. DaysFromHidingToDeleting, if set, deletes hidden file versions
+	// that many days after they are hidden.
+	DaysFromUploadingToHiding *int `json:"daysFromUploadingToHiding"`
+	DaysFromHidingToDeleting  *int `json:"daysFromHidingToDeleting"`
+}
+
+type bucketObj struct {
+	BucketID       string            `json:"bucketId"`
+	BucketName     string            `json:"bucketName"`
+	BucketType     string            `json:"bucketType"`
+	BucketInfo     map[string]string `json:"bucketInfo"`
+	LifecycleRules []LifecycleRule   `json:"lifecycleRules"`
+	Revision       int64             `json:"revision"`
+
+	FileLockConfiguration struct {
+		Value *struct {
+			IsFileLockEnabled bool `json:"isFileLockEnabled"`
+			DefaultRetention  *struct {
+				Mode   *string          `json:"mode"`
+				Period *RetentionPeriod `json:"period"`
+			} `json:"defaultRetention"`
+		} `json:"value"` // null if the key can't read it
+	} `json:"fileLockConfiguration"`
+}
+
+func (o *bucketObj) makeBucketInfo(c *Client) *BucketInfo {
+	b := &BucketInfo{Bucket: Bucket{ID: o.BucketID, c: c}}
+	b.update(o)
+	return b
+}
+
+// update sets the metadata of b from o, leaving the embedded Bucket alone.
+func (b *BucketInfo) update(o *bucketObj) {
+	b.c.auth().bucketNames.Store(o.BucketID, o.BucketName)
+	b.Name = o.BucketName
+	b.Type = o.BucketType
+	b.Info = o.BucketInfo
+	b.LifecycleRules = o.LifecycleRules
+	b.Revision = o.Revision
+	b.FileLockEnabled = false
+	b.DefaultRetention = DefaultRetention{}
+	if v := o.FileLockConfiguration.Value; v != nil {
+		b.FileLockEnabled = v.IsFileLockEnabled
+		if r := v.DefaultRetention; r != nil && r.Mode != nil {
+			b.DefaultRetention.Mode = *r.Mode
+			if r.Period != nil {
+				b.DefaultRetention.Period = *r.Period
+			}
+		}
+	}
+}
+
+// Refresh fetches the metadata of the bucket again, and updates the fields
+// of b, to see changes made by other clients. The embedded Bucket is not
+// affected, so it's safe to call while uploading to or listing the bucket,
+// but not concurrently with reading the fields of b.
+func (b *BucketInfo) Refresh(ctx context.Context) error {
+	bs, err := b.c.listBuckets(ctx, map[string]interface{}{"bucketId": b.ID})
+	if err != nil {
+		return err
+	}
+	if len(bs) != 1 || bs[0].BucketID != b.ID {
+		return errors.New("bucket not found: " + b.ID)
+	}
+	b.update(&bs[0])
+	return nil
+}
+
+// PublicURL returns the URL to download the file fileName from the bucket
+// without authorization, for example to embed it in a web page. It returns an
+// error if the bucket is not "allPublic", as the URL would not work.
+func (b *BucketInfo) PublicURL(fileName string) (string, error) {
+	if b.Type != "allPublic" {
+		return "", fmt.Errorf("bucket %s is %s, not allPublic", b.Name, b.Type)
+	}
+	return b.c.downloadURL() + "/file/" + s3Escape(b.Name) + "/" + s3Escape(fileName), nil
 }
 
 // BucketByID returns a Bucket bound to the Client. It does NOT check that the
@@ -338,37 +832,36 @@ func (c *Client) BucketByName(ctx context.Context, name string, createIfNotExist
 
 // Buckets returns a list of buckets sorted by name.
 func (c *Client) Buckets(ctx context.Context, name string) ([]*BucketInfo, error) {
-	params := map[string]interface{}{
-		"accountId": c.loginInfo.Load().(*LoginInfo).AccountID,
-	}
+	params := map[string]interface{}{}
 	if len(name) > 0 {
 		params["bucketName"] = name
 	}
+	buckets, err := c.listBuckets(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	var r []*BucketInfo
+	for i := range buckets {
+		r = append(r, buckets[i].makeBucketInfo(c))
+	}
+	return r, nil
+}
+
+// listBuckets calls b2_list_buckets with params, adding the account ID.
+func (c *Client) listBuckets(ctx context.Context, params map[string]interface{}) ([]bucketObj, error) {
+	params["accountId"] = c.auth().loginInfo.Load().(*LoginInfo).AccountID
 	res, err := c.doRequest(ctx, "b2_list_buckets", params)
 	if err != nil {
 		return nil, err
 	}
 	defer drainAndClose(res.Body)
 	var buckets struct {
-		Buckets []struct {
-			BucketID, BucketName, BucketType string
-		}
+		Buckets []bucketObj
 	}
 	if err := json.NewDecoder(res.Body).Decode(&buckets); err != nil {
 		return nil, err
 	}
-	var r []*BucketInfo
-	for _, b := range buckets.Buckets {
-		r = append(r, &BucketInfo{
-			Bucket: Bucket{
-				ID: b.BucketID,
-				c:  c,
-			},
-			Name: b.BucketName,
-			Type: b.BucketType,
-		})
-	}
-	return r, nil
+	return buckets.Buckets, nil
 }
 
 // CreateBucket creates a bucket with b2_create_bucket. If allPublic is true,
@@ -379,7 +872,7 @@ func (c *Client) CreateBucket(ctx context.Context, name string, allPublic bool)
 		bucketType = "allPublic"
 	}
 	res, err := c.doRequest(ctx, "b2_create_bucket", map[string]interface{}{
-		"accountId":  c.loginInfo.Load().(*LoginInfo).AccountID,
+		"accountId":  c.auth().loginInfo.Load().(*LoginInfo).AccountID,
 		"bucketName": name,
 		"bucketType": bucketType,
 	})
@@ -387,31 +880,164 @@ func (c *Client) CreateBucket(ctx context.Context, name string, allPublic bool)
 		return nil, err
 	}
 	defer drainAndClose(res.Body)
-	var bucket struct {
-		BucketID string
+	var bucket bucketObj
+	if err := json.NewDecoder(res.Body).Decode(&bucket); err != nil {
+		return nil, err
+	}
+	return bucket.makeBucketInfo(c), nil
+}
+
+// bucketName returns the name of the bucket with the given ID. Bucket names
+// can't change, so they are cached forever.
+func (c *Client) bucketName(ctx context.Context, id string) (string, error) {
+	if name, ok := c.auth().bucketNames.Load(id); ok {
+		return name.(string), nil
+	}
+	bs, err := c.listBuckets(ctx, map[string]interface{}{"bucketId": id})
+	if err != nil {
+		return "", err
+	}
+	if len(bs) != 1 || bs[0].BucketID != id {
+		return "", errors.New("bucket not found: " + id)
+	}
+	c.auth().bucketNames.Store(id, bs[0].BucketName)
+	return bs[0].BucketName, nil
+}
+
+// ErrFileLockDisabled is returned when setting a retention in a bucket
+// without file lock enabled.
+var ErrFileLockDisabled = errors.New("b2: bucket does not have file lock enabled")
+
+// checkFileLock returns ErrFileLockDisabled if the bucket bucketID does not
+// have file lock enabled.
+func (c *Client) checkFileLock(ctx context.Context, bucketID string) error {
+	bs, err := c.listBuckets(ctx, map[string]interface{}{"bucketId": bucketID})
+	if err != nil {
+		return err
 	}
+	if len(bs) == 0 {
+		return fmt.Errorf("bucket %s not found", bucketID)
+	}
+	if v := bs[0].FileLockConfiguration.Value; v == nil || !v.IsFileLockEnabled {
+		return fmt.Errorf("bucket %s: %w", bs[0].BucketName, ErrFileLockDisabled)
+	}
+	return nil
+}
+
+// ErrRevisionConflict is returned by UpdateBucket when the bucket was updated
+// by someone else since the revision in UpdateBucketOptions.IfRevisionIs.
+var ErrRevisionConflict = errors.New("b2: bucket revision conflict")
+
+// UpdateBucketOptions are the options of (*Client).UpdateBucket. Only the
+// fields that are set are changed.
+type UpdateBucketOptions struct {
+	Type string // "allPublic" or "allPrivate"
+
+	// Info replaces all the bucket info, if not nil.
+	Info map[string]string
+
+	// LifecycleRules replaces all the lifecycle rules, if not nil. Use an
+	// empty, non-nil slice to remove them.
+	LifecycleRules []LifecycleRule
+
+	// DefaultRetention, if not nil, replaces the default retention of the
+	// bucket, which must have file lock enabled. Use a zero DefaultRetention
+	// to remove it.
+	DefaultRetention *DefaultRetention
+
+	// IfRevisionIs, if not zero, makes the update fail with
+	// ErrRevisionConflict unless the bucket is still at that revision, to
+	// avoid overwriting concurrent updates. Use BucketInfo.Revision.
+	IfRevisionIs int64
+}
+
+// UpdateBucket calls b2_update_bucket and returns the updated bucket.
+func (c *Client) UpdateBucket(ctx context.Context, bucketID string, o UpdateBucketOptions) (*BucketInfo, error) {
+	params := map[string]interface{}{
+		"accountId": c.auth().loginInfo.Load().(*LoginInfo).AccountID,
+		"bucketId":  bucketID,
+	}
+	if len(o.Type) > 0 {
+		params["bucketType"] = o.Type
+	}
+	if o.Info != nil {
+		params["bucketInfo"] = o.Info
+	}
+	if o.LifecycleRules != nil {
+		params["lifecycleRules"] = o.LifecycleRules
+	}
+	if o.DefaultRetention != nil {
+		r, err := o.DefaultRetention.params()
+		if err != nil {
+			return nil, err
+		}
+		if err := c.checkFileLock(ctx, bucketID); err != nil {
+			return nil, err
+		}
+		params["defaultRetention"] = r
+	}
+	if o.IfRevisionIs != 0 {
+		params["ifRevisionIs"] = o.IfRevisionIs
+	}
+	res, err := c.doRequest(ctx, "b2_update_bucket", params)
+	if e, ok := UnwrapError(err); ok && e.Status == http.StatusConflict && o.IfRevisionIs != 0 {
+		return nil, ErrRevisionConflict
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer drainAndClose(res.Body)
+	var bucket bucketObj
 	if err := json.NewDecoder(res.Body).Decode(&bucket); err != nil {
 		return nil, err
 	}
-	return &BucketInfo{
-		Bucket: Bucket{
-			c: c, ID: bucket.BucketID,
-		},
-		Name: name,
-		Type: bucketType,
-	}, nil
+	return bucket.makeBucketInfo(c), nil
 }
 
 // Delete calls b2_delete_bucket. After this call succeeds the Bucket object
 // becomes invalid and any other calls will fail.
 func (b *Bucket) Delete(ctx context.Context) error {
 	res, err := b.c.doRequest(ctx, "b2_delete_bucket", map[string]interface{}{
-		"accountId": b.c.loginInfo.Load().(*LoginInfo).AccountID,
+		"accountId": b.c.auth().loginInfo.Load().(*LoginInfo).AccountID,
 		"bucketId":  b.ID,
 	})
 	if err != nil {
 		return err
 	}
 	drainAndClose(res.Body)
+	b.c.auth().bucketNames.Delete(b.ID)
+	b.c.auth().uploadURLs.Delete(b.ID)
 	return nil
 }
+
+// DeleteWhenEmpty calls Delete until it succeeds, for a bucket that was just
+// emptied. As B2 listings are eventually consistent, a bucket might still be
+// reported as non-empty for a short while. Between attempts, any file version
+// still listed is deleted, and DeleteWhenEmpty waits with an exponential
+// backoff from 100ms up to 5s. If the bucket can't be deleted within
+// timeout, the last error of Delete is returned.
+func (b *Bucket) DeleteWhenEmpty(ctx context.Context, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	delay := 100 * time.Millisecond
+	for {
+		err := b.Delete(ctx)
+		if err == nil {
+			return nil
+		}
+		if e, ok := UnwrapError(err); !ok || e.Code != "cannot_delete_non_empty_bucket" {
+			return err
+		}
+		if err := b.deleteAllFileVersions(ctx); err != nil {
+			return err
+		}
+		if time.Until(deadline) < delay {
+			return err
+		}
+		if err := sleep(ctx, delay); err != nil {
+			return err
+		}
+		if delay *= 2; delay > 5*time.Second {
+			delay = 5 * time.Second
+		}
+	}
+}
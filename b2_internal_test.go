@@ -0,0 +1,180 @@
+package b2
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDefaultRetention(t *testing.T) {
+	for _, tt := range []struct {
+		json    string
+		enabled bool
+		want    DefaultRetention
+	}{
+		{`{}`, false, DefaultRetention{}},
+		{`{"fileLockConfiguration": {"isClientAuthorizedToRead": false, "value": null}}`, false, DefaultRetention{}},
+		{`{"fileLockConfiguration": {"isClientAuthorizedToRead": true, "value": {"isFileLockEnabled": true,
+			"defaultRetention": {"mode": null, "period": null}}}}`, true, DefaultRetention{}},
+		{`{"fileLockConfiguration": {"isClientAuthorizedToRead": true, "value": {"isFileLockEnabled": true,
+			"defaultRetention": {"mode": "governance", "period": {"duration": 7, "unit": "days"}}}}}`,
+			true, DefaultRetention{Mode: "governance", Period: RetentionPeriod{Duration: 7, Unit: "days"}}},
+	} {
+		var o bucketObj
+		if err := json.Unmarshal([]byte(tt.json), &o); err != nil {
+			t.Fatal(err)
+		}
+		b := o.makeBucketInfo(&Client{})
+		if b.FileLockEnabled != tt.enabled || b.DefaultRetention != tt.want {
+			t.Errorf("%s: got %v %+v, want %v %+v", tt.json, b.FileLockEnabled, b.DefaultRetention, tt.enabled, tt.want)
+		}
+	}
+
+	for _, r := range []DefaultRetention{
+		{Mode: "legal"},
+		{Mode: "compliance"},
+		{Mode: "compliance", Period: RetentionPeriod{Duration: 1, Unit: "months"}},
+		{Mode: "governance", Period: RetentionPeriod{Duration: -1, Unit: "days"}},
+	} {
+		if _, err := r.params(); err == nil {
+			t.Errorf("%+v: invalid retention accepted", r)
+		}
+	}
+
+	tr := &throttledTransport{body: `{"buckets": [{"bucketId": "id", "bucketName": "name",
+		"fileLockConfiguration": {"isClientAuthorizedToRead": true, "value": {"isFileLockEnabled": false}}}]}`}
+	c := &Client{hc: &http.Client{Transport: tr}, RetryPolicy: &RetryPolicy{MinBackoff: time.Millisecond}}
+	c.loginInfo.Store(&LoginInfo{ApiURL: "https://api.example.com"})
+	_, err := c.UpdateBucket(context.Background(), "id", UpdateBucketOptions{
+		DefaultRetention: &DefaultRetention{Mode: "compliance", Period: RetentionPeriod{Duration: 1, Unit: "years"}},
+	})
+	if !errors.Is(err, ErrFileLockDisabled) {
+		t.Errorf("got %v, want ErrFileLockDisabled", err)
+	}
+	if tr.requests != 1 {
+		t.Errorf("%d requests, expected only b2_list_buckets", tr.requests)
+	}
+}
+
+// nonEmptyBucketTransport rejects b2_delete_bucket as for a non-empty bucket
+// the first failures times, and lists a straggler file version until it's
+// deleted.
+type nonEmptyBucketTransport struct {
+	mu       sync.Mutex
+	failures int
+	attempts int      // of b2_delete_bucket
+	deleted  []string // file IDs
+}
+
+func (t *nonEmptyBucketTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	res := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Request: req}
+	var body string
+	switch {
+	case strings.HasSuffix(req.URL.Path, "/b2_delete_bucket"):
+		t.attempts++
+		body = `{"bucketId": "bucket", "bucketName": "name"}`
+		if t.attempts <= t.failures {
+			res.StatusCode = http.StatusBadRequest
+			body = `{"code": "cannot_delete_non_empty_bucket", "message": "not empty", "status": 400}`
+		}
+	case strings.HasSuffix(req.URL.Path, "/b2_list_file_versions"):
+		files := ""
+		if len(t.deleted) == 0 {
+			files = `{"fileId": "straggler", "fileName": "name", "action": "upload"}`
+		}
+		body = `{"files": [` + files + `], "nextFileName": null}`
+	case strings.HasSuffix(req.URL.Path, "/b2_delete_file_version"):
+		var params struct {
+			FileID string `json:"fileId"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&params); err != nil {
+			return nil, err
+		}
+		t.deleted = append(t.deleted, params.FileID)
+		body = `{}`
+	default:
+		res.StatusCode = http.StatusNotFound
+		body = `{"code": "not_found", "message": "unexpected request", "status": 404}`
+	}
+	res.Body = io.NopCloser(strings.NewReader(body))
+	return res, nil
+}
+
+func TestDeleteWhenEmpty(t *testing.T) {
+	ctx := context.Background()
+	newBucket := func(tr *nonEmptyBucketTransport) *Bucket {
+		c := &Client{}
+		c.hc = &http.Client{Transport: &transport{t: tr, c: c}}
+		c.loginInfo.Store(&LoginInfo{ApiURL: "https://api.example.com", AccountID: "account"})
+		return c.BucketByID("bucket")
+	}
+
+	tr := &nonEmptyBucketTransport{failures: 2}
+	if err := newBucket(tr).DeleteWhenEmpty(ctx, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if tr.attempts != 3 || len(tr.deleted) != 1 || tr.deleted[0] != "straggler" {
+		t.Errorf("%d attempts, deleted %q", tr.attempts, tr.deleted)
+	}
+
+	// The timeout leaves no room for the first backoff of 100ms.
+	tr = &nonEmptyBucketTransport{failures: 1000}
+	err := newBucket(tr).DeleteWhenEmpty(ctx, 50*time.Millisecond)
+	if e, ok := UnwrapError(err); !ok || e.Code != "cannot_delete_non_empty_bucket" {
+		t.Errorf("got %v, want the last error of Delete", err)
+	}
+	if tr.attempts != 1 || len(tr.deleted) != 1 {
+		t.Errorf("%d attempts, deleted %q", tr.attempts, tr.deleted)
+	}
+}
+
+func TestMaxResponseBytes(t *testing.T) {
+	ctx := context.Background()
+	padding := strings.Repeat("x", 1000)
+
+	tr := &throttledTransport{body: `{"accountId": "account", "apiUrl": "https://api.example.com",
+		"authorizationToken": "token", "padding": "` + padding + `"}`}
+	c := &Client{hc: &http.Client{Transport: tr}, MaxResponseBytes: 100}
+	if err := c.login(ctx, nil); !errors.Is(err, ErrResponseTooLarge) {
+		t.Errorf("login: got %v, want ErrResponseTooLarge", err)
+	}
+
+	tr.body = `{"buckets": [{"bucketId": "id", "bucketName": "` + padding + `"}]}`
+	c.loginInfo.Store(&LoginInfo{ApiURL: "https://api.example.com"})
+	if _, err := c.Buckets(ctx, ""); !errors.Is(err, ErrResponseTooLarge) {
+		t.Errorf("b2_list_buckets: got %v, want ErrResponseTooLarge", err)
+	}
+
+	c.MaxResponseBytes = 0
+	if b, err := c.Buckets(ctx, ""); err != nil || len(b) != 1 {
+		t.Errorf("without limit: got %v, %v", b, err)
+	}
+}
+
+func TestPublicURLEscaping(t *testing.T) {
+	c := &Client{}
+	c.loginInfo.Store(&LoginInfo{DownloadURL: "https://f000.example.com"})
+	b := &BucketInfo{Bucket: Bucket{ID: "id", c: c}, Name: "public", Type: "allPublic"}
+	for name, want := range map[string]string{
+		"dir/a+b.txt":    "https://f000.example.com/file/public/dir/a%2Bb.txt",
+		"dir/a b.txt":    "https://f000.example.com/file/public/dir/a%20b.txt",
+		"dir/café/ü.txt": "https://f000.example.com/file/public/dir/caf%C3%A9/%C3%BC.txt",
+	} {
+		if got, err := b.PublicURL(name); err != nil || got != want {
+			t.Errorf("PublicURL(%q) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+
+	b.Type = "allPrivate"
+	if _, err := b.PublicURL("file"); err == nil {
+		t.Error("PublicURL succeeded for a private bucket")
+	}
+}
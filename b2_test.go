@@ -8,11 +8,13 @@ import (
 	"errors"
 	"flag"
 	"log"
+	mathrand "math/rand"
 	"net/http"
 	"os"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/kardianos/b2"
 )
@@ -20,12 +22,17 @@ import (
 var client *b2.Client
 var clientMu sync.Mutex
 
-func getClient(t *testing.T, ctx context.Context) *b2.Client {
-	accountID := os.Getenv("ACCOUNT_ID")
-	applicationKey := os.Getenv("APPLICATION_KEY")
+func getCredentials(t *testing.T) (accountID, applicationKey string) {
+	accountID = os.Getenv("ACCOUNT_ID")
+	applicationKey = os.Getenv("APPLICATION_KEY")
 	if accountID == "" || applicationKey == "" {
 		t.Fatal("Missing ACCOUNT_ID or APPLICATION_KEY")
 	}
+	return accountID, applicationKey
+}
+
+func getClient(t *testing.T, ctx context.Context) *b2.Client {
+	accountID, applicationKey := getCredentials(t)
 	clientMu.Lock()
 	defer clientMu.Unlock()
 	if client != nil {
@@ -117,6 +124,39 @@ func TestBucketLifecycle(t *testing.T) {
 		t.Fatal("Bucket did not appear in Buckets()")
 	}
 
+	b.Name, b.Type = "stale", "stale"
+	if err := b.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if b.Name != name || b.Type != "allPrivate" {
+		t.Errorf("Refresh did not update the bucket: %s %s", b.Name, b.Type)
+	}
+
+	ub, err := c.UpdateBucket(ctx, b.ID, b2.UpdateBucketOptions{
+		Info:         map[string]string{"owner": "test"},
+		IfRevisionIs: b.Revision,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ub.Revision <= b.Revision || ub.Info["owner"] != "test" {
+		t.Errorf("bad update: revision %d, info %v", ub.Revision, ub.Info)
+	}
+	// b is now stale, as if another process had updated the bucket.
+	_, err = c.UpdateBucket(ctx, b.ID, b2.UpdateBucketOptions{
+		Info:         map[string]string{"owner": "other"},
+		IfRevisionIs: b.Revision,
+	})
+	if err != b2.ErrRevisionConflict {
+		t.Errorf("expected ErrRevisionConflict, got %v", err)
+	}
+	if err := b.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if b.Revision != ub.Revision || b.Info["owner"] != "test" {
+		t.Errorf("Refresh: revision %d, info %v", b.Revision, b.Info)
+	}
+
 	if err := b.Delete(ctx); err != nil {
 		t.Fatal(err)
 	}
@@ -143,3 +183,149 @@ func TestUnwrapError(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestReauthorize(t *testing.T) {
+	ctx := context.Background()
+	c := getClient(t, ctx)
+
+	before, err := c.LoginInfo(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Reauthorize(ctx, "invalid", "invalid"); err == nil {
+		t.Fatal("Reauthorize succeeded with invalid credentials")
+	}
+	if _, err := c.Buckets(ctx, ""); err != nil {
+		t.Fatal("old credentials were not kept:", err)
+	}
+	err = c.Reauthorize(ctx, os.Getenv("ACCOUNT_ID"), os.Getenv("APPLICATION_KEY"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := c.LoginInfo(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before.AuthorizationToken == after.AuthorizationToken {
+		t.Error("authorization token was not refreshed")
+	}
+}
+
+func TestServerTime(t *testing.T) {
+	ctx := context.Background()
+	c := getClient(t, ctx)
+
+	st, err := c.ServerTime(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if skew := time.Since(st); skew > time.Hour || skew < -time.Hour {
+		t.Errorf("server time %v is %v off", st, skew)
+	}
+}
+
+func TestPermissionsFor(t *testing.T) {
+	li := &b2.LoginInfo{Allowed: b2.Allowed{
+		Capabilities: []string{"listFiles", "readFiles"},
+		BucketName:   "photos",
+		NamePrefix:   "public/",
+	}}
+	for _, tt := range []struct {
+		bucket, key string
+		want        b2.PathPermissions
+	}{
+		{"photos", "public/cat.jpg", b2.PathPermissions{Read: true, List: true}},
+		{"photos", "public/", b2.PathPermissions{Read: true, List: true}},
+		{"photos", "private/cat.jpg", b2.PathPermissions{}},
+		{"videos", "public/cat.jpg", b2.PathPermissions{}},
+	} {
+		if got := li.PermissionsFor(tt.bucket, tt.key); got != tt.want {
+			t.Errorf("PermissionsFor(%q, %q) = %+v, want %+v", tt.bucket, tt.key, got, tt.want)
+		}
+	}
+
+	li = &b2.LoginInfo{Allowed: b2.Allowed{
+		Capabilities: []string{"listFiles", "readFiles", "writeFiles", "deleteFiles"},
+	}}
+	want := b2.PathPermissions{Read: true, Write: true, Delete: true, List: true}
+	if got := li.PermissionsFor("any", "thing"); got != want {
+		t.Errorf("unrestricted key: got %+v, want %+v", got, want)
+	}
+}
+
+func TestClientSet(t *testing.T) {
+	ctx := context.Background()
+	accountID, applicationKey := getCredentials(t)
+	var cs b2.ClientSet
+	if err := cs.Register("prod", accountID, applicationKey, nil); err != nil {
+		t.Fatal(err)
+	}
+	c, err := cs.Client(ctx, "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c2, _ := cs.Client(ctx, "prod"); c2 != c {
+		t.Error("Client was not reused")
+	}
+	if _, err := c.Buckets(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	newPolicy := func() *b2.RetryPolicy {
+		return &b2.RetryPolicy{
+			MinBackoff: 100 * time.Millisecond,
+			MaxBackoff: time.Second,
+			Rand:       mathrand.New(mathrand.NewSource(42)),
+		}
+	}
+	p1, p2 := newPolicy(), newPolicy()
+	var distinct bool
+	for attempt := 0; attempt < 10; attempt++ {
+		limit := 100 * time.Millisecond << attempt
+		if limit > time.Second {
+			limit = time.Second
+		}
+		d1, d2 := p1.Backoff(attempt), p2.Backoff(attempt)
+		if d1 != d2 {
+			t.Errorf("attempt %d: same seed gave %v and %v", attempt, d1, d2)
+		}
+		if d1 < 0 || d1 > limit {
+			t.Errorf("attempt %d: backoff %v out of [0, %v]", attempt, d1, limit)
+		}
+		if d1 != limit {
+			distinct = true
+		}
+	}
+	if !distinct {
+		t.Error("backoff has no jitter")
+	}
+}
+
+func TestAccountSummary(t *testing.T) {
+	ctx := context.Background()
+	c := getClient(t, ctx)
+	b := getBucket(t, ctx, c)
+	defer deleteBucket(t, b)
+
+	fi, err := b.Upload(ctx, strings.NewReader("data"), "test-summary", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteFile(ctx, fi.ID, fi.Name)
+
+	summaries, err := c.AccountSummary(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range summaries {
+		if s.Name == b.Name {
+			if s.Files != 1 || s.Bytes != 4 || s.Type != "allPrivate" {
+				t.Errorf("wrong summary: %+v", s)
+			}
+			return
+		}
+	}
+	t.Error("bucket missing from AccountSummary")
+}
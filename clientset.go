@@ -0,0 +1,68 @@
+package b2
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+)
+
+// A ClientSet holds the Clients of multiple accounts, by name. Each Client
+// is created and authorized the first time it's requested. The zero value is
+// an empty set, and it is safe for concurrent use.
+type ClientSet struct {
+	mu       sync.Mutex
+	accounts map[string]*account
+}
+
+type account struct {
+	accountID, applicationKey string
+	httpClient                *http.Client
+
+	// mu is held while authorizing, so that each account only
+	// authorizes once, without blocking the other accounts.
+	mu sync.Mutex
+	c  *Client
+}
+
+// Register adds the account with the given credentials to the set, as name.
+// httpClient is passed to NewClient. No network operation is performed.
+func (cs *ClientSet) Register(name, accountID, applicationKey string, httpClient *http.Client) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if _, ok := cs.accounts[name]; ok {
+		return errors.New("account already registered: " + name)
+	}
+	if cs.accounts == nil {
+		cs.accounts = make(map[string]*account)
+	}
+	cs.accounts[name] = &account{
+		accountID:      accountID,
+		applicationKey: applicationKey,
+		httpClient:     httpClient,
+	}
+	return nil
+}
+
+// Client returns the Client of the account registered as name, calling
+// NewClient with ctx the first time. If that fails, the next call tries again.
+func (cs *ClientSet) Client(ctx context.Context, name string) (*Client, error) {
+	cs.mu.Lock()
+	a, ok := cs.accounts[name]
+	cs.mu.Unlock()
+	if !ok {
+		return nil, errors.New("account not registered: " + name)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.c != nil {
+		return a.c, nil
+	}
+	c, err := NewClient(ctx, a.accountID, a.applicationKey, a.httpClient)
+	if err != nil {
+		return nil, err
+	}
+	a.c = c
+	return c, nil
+}
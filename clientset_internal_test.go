@@ -0,0 +1,69 @@
+package b2
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestClientSetLogin(t *testing.T) {
+	ctx := context.Background()
+	var mu sync.Mutex
+	logins := make(map[string]int) // by account ID
+	hc := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		creds, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(req.Header.Get("Authorization"), "Basic "))
+		accountID, key, _ := strings.Cut(string(creds), ":")
+		mu.Lock()
+		logins[accountID]++
+		mu.Unlock()
+		res := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Request: req}
+		body := `{"accountId": "` + accountID + `", "apiUrl": "https://api.example.com", "authorizationToken": "token"}`
+		if key != "key" {
+			res.StatusCode = http.StatusUnauthorized
+			body = `{"code": "bad_auth_token", "message": "invalid key", "status": 401}`
+		}
+		res.Body = io.NopCloser(strings.NewReader(body))
+		return res, nil
+	})}
+
+	var cs ClientSet
+	if err := cs.Register("prod", "prod", "key", hc); err != nil {
+		t.Fatal(err)
+	}
+	if err := cs.Register("prod", "other", "key", hc); err == nil {
+		t.Error("duplicate Register succeeded")
+	}
+	if err := cs.Register("invalid", "invalid", "wrong", hc); err != nil {
+		t.Fatal(err)
+	}
+	if len(logins) != 0 {
+		t.Errorf("Register logged in: %v", logins)
+	}
+
+	if _, err := cs.Client(ctx, "missing"); err == nil {
+		t.Error("unregistered account returned a Client")
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := cs.Client(ctx, "invalid"); err == nil {
+			t.Error("invalid account returned a Client")
+		}
+	}
+	if logins["invalid"] != 2 {
+		t.Errorf("failed login tried %d times, want again on each call", logins["invalid"])
+	}
+
+	c, err := cs.Client(ctx, "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c2, _ := cs.Client(ctx, "prod"); c2 != c {
+		t.Error("Client was not reused")
+	}
+	if logins["prod"] != 1 {
+		t.Errorf("%d logins for a reused Client", logins["prod"])
+	}
+}
@@ -1,35 +1,102 @@
 package b2
 
 import (
+	"bufio"
+	"compress/gzip"
 	"context"
+	"crypto/sha1"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
-func (c *Client) getWithAuth(ctx context.Context, U string, Range string) (*http.Response, error) {
-	req, err := http.NewRequestWithContext(ctx, "GET", U, nil)
-	if err != nil {
-		return nil, err
-	}
-	if len(Range) > 0 {
-		req.Header.Set("Range", Range)
+// getWithAuth makes a GET request to U. The body of the response is bound
+// to a context that is canceled when the body is closed, so that closing it
+// early aborts the transfer instead of waiting for or draining the rest.
+func (c *Client) getWithAuth(ctx context.Context, U string, Range, acceptEncoding string) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	get := func() (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", U, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(Range) > 0 {
+			req.Header.Set("Range", Range)
+		}
+		// Setting it explicitly stops net/http from transparently
+		// decompressing the body, which would hide the stored bytes and
+		// the Content-Length and Content-Encoding headers.
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		return c.hc.Do(req)
 	}
-	res, err := c.hc.Do(req)
+	res, err := get()
 	if e, ok := UnwrapError(err); ok && e.Status == http.StatusUnauthorized {
 		if err = c.login(ctx, res); err == nil {
-			req, err = http.NewRequestWithContext(ctx, "GET", U, nil)
-			if err != nil {
-				return nil, err
-			}
-			return c.hc.Do(req)
+			res, err = get()
 		}
 	}
-	return res, err
+	if err != nil {
+		cancel()
+		return res, err
+	}
+	res.Body = &cancelBody{ReadCloser: res.Body, cancel: cancel}
+	return res, nil
+}
+
+// cancelBody cancels the context of its request when closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	b.cancel()
+	return b.ReadCloser.Close()
+}
+
+// ErrRangeNotSatisfiable is matched by the error returned when a download
+// Range starts past the end of the file. Use errors.As with a
+// *RangeNotSatisfiableError to obtain the size of the file.
+var ErrRangeNotSatisfiable = errors.New("b2: requested range not satisfiable")
+
+// RangeNotSatisfiableError is returned, wrapped in a url.Error, when B2
+// answers a download with status 416.
+type RangeNotSatisfiableError struct {
+	// Size of the file, from the Content-Range header, or -1 if unknown.
+	Size int64
+}
+
+func (e *RangeNotSatisfiableError) Error() string {
+	return fmt.Sprintf("b2: requested range not satisfiable (file size %d)", e.Size)
+}
+
+// Is makes RangeNotSatisfiableError match ErrRangeNotSatisfiable.
+func (e *RangeNotSatisfiableError) Is(target error) bool {
+	return target == ErrRangeNotSatisfiable
+}
+
+func parseRangeError(res *http.Response) error {
+	drainAndClose(res.Body)
+	e := &RangeNotSatisfiableError{Size: -1}
+	// The header is in the form "bytes */1234".
+	cr := res.Header.Get("Content-Range")
+	if i := strings.LastIndexByte(cr, '/'); i >= 0 {
+		if size, err := strconv.ParseInt(cr[i+1:], 10, 64); err == nil {
+			e.Size = size
+		}
+	}
+	return e
 }
 
 type Range struct {
@@ -37,25 +104,289 @@ type Range struct {
 	End   int64
 }
 
+// header returns the Range header value for r, or "" if r is the zero Range.
+func (r Range) header() (string, error) {
+	if r.Begin <= 0 && r.End <= 0 {
+		return "", nil
+	}
+	if r.Begin < 0 {
+		r.Begin = 0
+	}
+	if r.End < 1 {
+		return "", fmt.Errorf("invalid range end %d, must be greater then 0", r.End)
+	}
+	return fmt.Sprintf("bytes=%d-%d", r.Begin, r.End), nil
+}
+
+// ParseHTTPRange parses the value of the Range header of an HTTP request, like
+// "bytes=0-499", "bytes=500-" or "bytes=-500", for a file of the given size,
+// so that it can be forwarded in DownloadOptions. An empty header returns the
+// zero Range, meaning the whole file. The end of the range is capped at the
+// end of the file, and ErrRangeNotSatisfiable is returned if it starts past it.
+//
+// Multiple ranges are not supported. Neither is "bytes=0-0" on files longer
+// than one byte, as the zero Range means the whole file.
+func ParseHTTPRange(header string, size int64) (Range, error) {
+	if header == "" {
+		return Range{}, nil
+	}
+	invalid := func() (Range, error) {
+		return Range{}, fmt.Errorf("invalid range %q", header)
+	}
+	if !strings.HasPrefix(header, "bytes=") {
+		return invalid()
+	}
+	spec := header[len("bytes="):]
+	if strings.Contains(spec, ",") {
+		return Range{}, fmt.Errorf("multiple ranges are not supported: %q", header)
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return invalid()
+	}
+	var r Range
+	switch {
+	case first == "": // suffix, like "-500"
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return invalid()
+		}
+		if n == 0 {
+			return Range{}, ErrRangeNotSatisfiable
+		}
+		r = Range{Begin: size - n, End: size - 1}
+		if r.Begin < 0 {
+			r.Begin = 0
+		}
+	default:
+		begin, err := strconv.ParseInt(first, 10, 64)
+		if err != nil || begin < 0 {
+			return invalid()
+		}
+		r = Range{Begin: begin, End: size - 1}
+		if last != "" {
+			end, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || end < begin {
+				return invalid()
+			}
+			if end < r.End {
+				r.End = end
+			}
+		}
+	}
+	if r.Begin >= size {
+		return Range{}, ErrRangeNotSatisfiable
+	}
+	if r.Begin == 0 && r.End == 0 && size > 1 {
+		return Range{}, fmt.Errorf("unsupported range %q", header)
+	}
+	return r, nil
+}
+
+// DownloadOptions select the file to download and how. The file is found by
+// FileID if set, else by the ID of FileInfo if set, else by Bucket (or
+// BucketID) and FileName. Downloading by ID needs no bucket name.
 type DownloadOptions struct {
 	// Download file by ID.
 	FileID string
 
+	// FileInfo, if set, downloads the file version it describes by ID, like
+	// a FileInfo from a listing, to skip resolving the name.
+	FileInfo *FileInfo
+
 	// Download file by bucket and filename.
 	Bucket   string
 	FileName string
 
+	// BucketID can be set instead of Bucket with FileName. The name of the
+	// bucket is then looked up once, and cached by the Client.
+	BucketID string
+
 	// Zero based indicies.
 	Range Range
+
+	// Buffers, if set, provides the buffers used when copying the file
+	// contents, like in DownloadTo. Buffers should be at least 32KB.
+	Buffers BufferPool
+
+	// Decompress, if the file is stored with Content-Encoding "gzip",
+	// returns its decompressed contents instead of the stored ones. The
+	// FileInfo still describes the stored file: ContentLength and
+	// ContentSHA1 are those of the compressed bytes. Files without that
+	// Content-Encoding are returned untouched.
+	Decompress bool
+
+	// MaxBytes, if positive, limits the contents returned to the first
+	// MaxBytes bytes, or decompressed bytes with Decompress. Unlike Range,
+	// it does not require knowing the size of the file. Closing the body
+	// aborts the rest of the transfer.
+	MaxBytes int64
+
+	// MaxBytesPerSec, if positive, limits the download bandwidth, for
+	// example to keep background downloads from saturating the network.
+	MaxBytesPerSec int64
+
+	// AcceptEncoding, if set, is the Accept-Encoding header of the request,
+	// and the body is then not decompressed transparently: use Decompress.
+	// B2 always serves the stored bytes, with the Content-Encoding set at
+	// upload, but a proxy or CDN in front of it, see
+	// Client.DownloadURLOverride, may compress compressible files on the fly.
+	// Set it to "identity" to ask for the stored bytes.
+	//
+	// If it's empty, and neither Decompress nor RawBody is set, the header is
+	// left to net/http, which asks for gzip and transparently decompresses
+	// gzip responses, including files stored with Content-Encoding "gzip".
+	// The ContentLength of the FileInfo is then -1, as the length of the
+	// stored bytes isn't known. Decompress alone asks for "gzip".
+	//
+	// ContentSHA1 is always the SHA1 of the stored bytes. It matches the
+	// body if it was neither compressed on the fly nor decompressed, or if
+	// Decompress or net/http undid a compression on the fly.
+	AcceptEncoding string
+
+	// RawBody guarantees the body is made of the exact stored bytes, for
+	// byte-exact verification against ContentSHA1: the request asks for the
+	// "identity" encoding, and the download fails if the response was still
+	// compressed on the fly. It can't be combined with Decompress, nor with
+	// an AcceptEncoding other than "identity".
+	RawBody bool
+
+	// SidecarMetadata merges the metadata stored in the sidecar file, if any,
+	// in the returned FileInfo. It requires Bucket or BucketID, and it costs an
+	// extra download transaction. See (*Bucket).UploadWithSidecar.
+	SidecarMetadata bool
+}
+
+// downloadURL returns the base URL for file downloads.
+func (c *Client) downloadURL() string {
+	if c.DownloadURLOverride != "" {
+		return c.DownloadURLOverride
+	}
+	return c.auth().loginInfo.Load().(*LoginInfo).DownloadURL
+}
+
+// Bounds of the validity of download authorizations.
+const (
+	minDownloadAuthorization = time.Second
+	maxDownloadAuthorization = 7 * 24 * time.Hour
+)
+
+// A DownloadAuthorization allows downloading the files of a private bucket
+// whose name starts with FileNamePrefix, until Expires, without the account
+// authorization. See (*Bucket).GetDownloadAuthorization.
+type DownloadAuthorization struct {
+	BucketID       string
+	FileNamePrefix string
+	Token          string
+
+	// Expires is when the token stops being valid. B2 doesn't return it, so
+	// it's estimated before the request, from the requested duration.
+	Expires time.Time
+}
+
+// Remaining returns how long the token remains valid, negative once expired.
+// Since B2 checks the validity when the download starts, leave a margin for
+// the time it takes a client to use it.
+func (a *DownloadAuthorization) Remaining() time.Duration {
+	return time.Until(a.Expires)
+}
+
+// GetDownloadAuthorization calls b2_get_download_authorization to get a token
+// that allows downloading the files of the bucket whose name starts with
+// prefix, for ttl. ttl is clamped to the range B2 accepts, from one second to
+// one week.
+//
+// The token is passed either in the Authorization header of the download
+// request, or in the Authorization query parameter, see SignedURL.
+func (b *Bucket) GetDownloadAuthorization(ctx context.Context, prefix string, ttl time.Duration) (*DownloadAuthorization, error) {
+	if ttl < minDownloadAuthorization {
+		ttl = minDownloadAuthorization
+	}
+	if ttl > maxDownloadAuthorization {
+		ttl = maxDownloadAuthorization
+	}
+	expires := time.Now().Add(ttl)
+	res, err := b.c.doRequest(ctx, "b2_get_download_authorization", map[string]interface{}{
+		"bucketId":               b.ID,
+		"fileNamePrefix":         prefix,
+		"validDurationInSeconds": int64(ttl / time.Second),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer drainAndClose(res.Body)
+	var a struct {
+		BucketID           string `json:"bucketId"`
+		FileNamePrefix     string `json:"fileNamePrefix"`
+		AuthorizationToken string `json:"authorizationToken"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&a); err != nil {
+		return nil, err
+	}
+	return &DownloadAuthorization{
+		BucketID:       a.BucketID,
+		FileNamePrefix: a.FileNamePrefix,
+		Token:          a.AuthorizationToken,
+		Expires:        expires,
+	}, nil
+}
+
+// SignedURL returns a URL to download the file fileName of the private bucket
+// for ttl, without any other credential, for example to pass through a CDN.
+// It gets a download authorization with fileName as the prefix, see
+// GetDownloadAuthorization, and adds it to the download URL, which honors
+// Client.DownloadURLOverride, as the Authorization query parameter.
+//
+// B2 only authorizes prefixes: the token in the URL also allows downloading
+// every other file whose name starts with fileName, like fileName+".bak", so
+// don't share it if such names must stay private.
+func (b *Bucket) SignedURL(ctx context.Context, fileName string, ttl time.Duration) (string, error) {
+	name, err := b.c.bucketName(ctx, b.ID)
+	if err != nil {
+		return "", err
+	}
+	a, err := b.GetDownloadAuthorization(ctx, fileName, ttl)
+	if err != nil {
+		return "", err
+	}
+	return b.c.downloadURL() + "/file/" + s3Escape(name) + "/" + s3Escape(fileName) +
+		"?Authorization=" + url.QueryEscape(a.Token), nil
 }
 
 // DownloadFile gets file contents. The ReadCloser must be
-// closed by the caller once done reading.
+// closed by the caller once done reading. Closing it before the end of the
+// file aborts the download, without reading the rest.
 //
 // Note: the (*FileInfo).CustomMetadata values returned by this function are
 // all represented as strings, because they are delivered by HTTP headers.
 func (c *Client) DownloadFile(ctx context.Context, o DownloadOptions) (io.ReadCloser, *FileInfo, error) {
-	downloadURL := c.loginInfo.Load().(*LoginInfo).DownloadURL
+	res, fi, err := c.DownloadFileResponse(ctx, o)
+	if err != nil {
+		return nil, nil, err
+	}
+	return res.Body, fi, nil
+}
+
+// DownloadFileResponse is like DownloadFile, but also returns the HTTP
+// response, to access headers not exposed by FileInfo. The response Body is
+// the same ReadCloser DownloadFile would return, already wrapped according
+// to o, and the caller is responsible for closing it. On error, the body is
+// already closed.
+func (c *Client) DownloadFileResponse(ctx context.Context, o DownloadOptions) (*http.Response, *FileInfo, error) {
+	if len(o.FileID) == 0 && o.FileInfo != nil {
+		if o.FileInfo.Action != FileUpload && o.FileInfo.Action != "" {
+			return nil, nil, fmt.Errorf("can't download %s, a %s action", o.FileInfo.Name, o.FileInfo.Action)
+		}
+		o.FileID = o.FileInfo.ID
+	}
+	if len(o.Bucket) == 0 && len(o.BucketID) > 0 && (len(o.FileID) == 0 || o.SidecarMetadata) {
+		name, err := c.bucketName(ctx, o.BucketID)
+		if err != nil {
+			return nil, nil, err
+		}
+		o.Bucket = name
+	}
+	downloadURL := c.downloadURL()
 	var U string
 	switch {
 	default:
@@ -68,25 +399,347 @@ func (c *Client) DownloadFile(ctx context.Context, o DownloadOptions) (io.ReadCl
 		}
 		U = downloadURL + "/file/" + o.Bucket + "/" + o.FileName
 	}
-	var rs string
-	if r := o.Range; r.Begin > 0 || r.End > 0 {
-		if r.Begin < 0 {
-			r.Begin = 0
+	rs, err := o.Range.header()
+	if err != nil {
+		return nil, nil, err
+	}
+	acceptEncoding := o.AcceptEncoding
+	if o.RawBody {
+		if o.Decompress {
+			return nil, nil, errors.New("RawBody can't be combined with Decompress")
 		}
-		if r.End < 1 {
-			return nil, nil, fmt.Errorf("invalid range end %d, must be greater then 0", r.End)
+		if acceptEncoding != "" && !strings.EqualFold(acceptEncoding, "identity") {
+			return nil, nil, fmt.Errorf("RawBody can't be combined with AcceptEncoding %q", acceptEncoding)
 		}
-		rs = fmt.Sprintf("bytes=%d-%d", r.Begin, r.End)
+		acceptEncoding = "identity"
 	}
-	res, err := c.getWithAuth(ctx, U, rs)
+	if o.Decompress && acceptEncoding == "" {
+		acceptEncoding = "gzip"
+	}
+	res, err := c.getWithAuth(ctx, U, rs, acceptEncoding)
 	if err != nil {
-		debugf("download %s: %s", U, err)
+		c.debugf("download %s: %s", U, err)
 		return nil, nil, err
 	}
-	debugf("download %s (%s)", U, res.Header.Get("X-Bz-Content-Sha1"))
+	c.debugf("download %s (%s)", U, res.Header.Get("X-Bz-Content-Sha1"))
 
 	fi, err := parseFileInfoHeaders(res.Header)
-	return res.Body, fi, err
+	if err == nil && o.RawBody && compressedOnTheFly(res.Header) {
+		err = fmt.Errorf("download %s: compressed on the fly with Content-Encoding %q despite RawBody", U, res.Header.Get("Content-Encoding"))
+	}
+	if err == nil && o.SidecarMetadata {
+		var name string
+		name, err = sidecarName(o, fi)
+		if err == nil {
+			err = c.mergeSidecar(ctx, o.Bucket, name, fi)
+		}
+	}
+	body := res.Body
+	if err == nil && o.Decompress && strings.EqualFold(res.Header.Get("Content-Encoding"), "gzip") {
+		body, err = newGzipBody(res.Body)
+	}
+	if err != nil {
+		res.Body.Close()
+		return nil, nil, err
+	}
+	if o.MaxBytes > 0 {
+		body = &wrappedBody{Reader: io.LimitReader(body, o.MaxBytes), Closer: body}
+	}
+	if o.MaxBytesPerSec > 0 {
+		body = &wrappedBody{Reader: throttle(ctx, body, o.MaxBytesPerSec), Closer: body}
+	}
+	res.Body = body
+	return res, fi, nil
+}
+
+// wrappedBody reads from a wrapper of a body, like a LimitReader, and closes
+// the body itself.
+type wrappedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// gzipBody decompresses a response body, and closes it when closed.
+type gzipBody struct {
+	*gzip.Reader
+	body io.ReadCloser
+}
+
+func newGzipBody(body io.ReadCloser) (*gzipBody, error) {
+	zr, err := gzip.NewReader(body)
+	if err != nil {
+		return nil, err
+	}
+	return &gzipBody{Reader: zr, body: body}, nil
+}
+
+func (b *gzipBody) Close() error {
+	b.Reader.Close()
+	return b.body.Close()
+}
+
+// DownloadTo is like DownloadFile, but writes the file contents to w.
+func (c *Client) DownloadTo(ctx context.Context, o DownloadOptions, w io.Writer) (*FileInfo, error) {
+	rc, fi, err := c.DownloadFile(ctx, o)
+	if err != nil {
+		if rc != nil {
+			rc.Close()
+		}
+		return nil, err
+	}
+	defer rc.Close()
+	if _, err := copyBuffer(w, rc, o.Buffers); err != nil {
+		return nil, err
+	}
+	return fi, nil
+}
+
+// DownloadVersionTo downloads the file version fileID, like an older version
+// from ListFileVersions, to w, and checks its SHA1 once done, if B2 knows it,
+// returning ErrSHA1Mismatch if it doesn't match. The stored bytes are
+// downloaded, as with DownloadOptions.RawBody. If the version doesn't exist,
+// or no longer does, ErrFileNotFound is returned.
+func (c *Client) DownloadVersionTo(ctx context.Context, fileID string, w io.Writer) (*FileInfo, error) {
+	res, fi, err := c.DownloadFileResponse(ctx, DownloadOptions{FileID: fileID, RawBody: true})
+	if e, ok := UnwrapError(err); ok && (e.Status == http.StatusNotFound || e.Status == http.StatusGone) {
+		return nil, ErrFileNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	h := sha1.New()
+	if _, err := io.Copy(io.MultiWriter(w, h), res.Body); err != nil {
+		return nil, err
+	}
+	if err := checkSHA1(h, fi.ContentSHA1); err != nil {
+		return nil, err
+	}
+	return fi, nil
+}
+
+// ErrBufferTooSmall is returned by DownloadInto when the file does not fit in
+// the buffer.
+var ErrBufferTooSmall = errors.New("b2: file larger than the buffer")
+
+// DownloadInto is like DownloadFile, but reads the file contents into buf,
+// without allocating. It returns the number of bytes read.
+//
+// If the file is larger than buf, buf is filled with its first len(buf) bytes,
+// and ErrBufferTooSmall is returned along with the FileInfo. Otherwise, if the
+// whole file was downloaded as stored, see DownloadOptions.AcceptEncoding, its
+// SHA1 is checked, and ErrSHA1Mismatch is returned if it doesn't match.
+func (c *Client) DownloadInto(ctx context.Context, o DownloadOptions, buf []byte) (n int, fi *FileInfo, err error) {
+	res, fi, err := c.DownloadFileResponse(ctx, o)
+	if err != nil {
+		return 0, nil, err
+	}
+	rc := res.Body
+	defer rc.Close()
+	n, err = io.ReadFull(rc, buf)
+	switch err {
+	case nil:
+		var b [1]byte
+		if m, _ := io.ReadFull(rc, b[:]); m > 0 {
+			return n, fi, ErrBufferTooSmall
+		}
+	case io.EOF, io.ErrUnexpectedEOF:
+	default:
+		return n, nil, err
+	}
+	if o.storedBytes(res.Header) {
+		h := sha1.New()
+		h.Write(buf[:n])
+		if err := checkSHA1(h, fi.ContentSHA1); err != nil {
+			return n, nil, err
+		}
+	}
+	return n, fi, nil
+}
+
+// DownloadToFile is like DownloadTo, but writes the file contents to the local
+// file path. They are written to a temporary file in the same directory,
+// verified against the SHA1 reported by B2 if the whole file was downloaded
+// as stored, see DownloadOptions.AcceptEncoding, and renamed to path, so that
+// path never holds a partial or corrupt download. Like any temporary file, it
+// is created with mode 0600; Chmod path if it must be readable by others.
+func (c *Client) DownloadToFile(ctx context.Context, o DownloadOptions, path string) (*FileInfo, error) {
+	f, fi, err := c.downloadTemp(ctx, o, path)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name()) // fails harmlessly after the rename
+	err = f.Close()
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return fi, nil
+}
+
+// DownloadToFileOpen is like DownloadToFile, but also returns the downloaded
+// file, open for reading at its start, to process it without reopening it.
+// It's the verified file, once renamed to path. The caller owns it, and must
+// close it. On error, no file is returned.
+func (c *Client) DownloadToFileOpen(ctx context.Context, o DownloadOptions, path string) (io.ReadSeekCloser, *FileInfo, error) {
+	f, fi, err := c.downloadTemp(ctx, o, path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.Remove(f.Name()) // fails harmlessly after the rename
+	err = os.Rename(f.Name(), path)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, fi, nil
+}
+
+// downloadTemp downloads to a temporary file next to path, and verifies it.
+// It returns the file still open, or removes it on error.
+func (c *Client) downloadTemp(ctx context.Context, o DownloadOptions, path string) (*os.File, *FileInfo, error) {
+	res, fi, err := c.DownloadFileResponse(ctx, o)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+	f, err := os.CreateTemp(filepath.Dir(path), ".b2-download-*")
+	if err != nil {
+		return nil, nil, err
+	}
+	h := sha1.New()
+	_, err = copyBuffer(io.MultiWriter(f, h), res.Body, o.Buffers)
+	if err == nil && o.storedBytes(res.Header) {
+		err = checkSHA1(h, fi.ContentSHA1)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, nil, err
+	}
+	return f, fi, nil
+}
+
+// maxLatestPages bounds the listing of DownloadLatest, to 10000 files.
+const maxLatestPages = 10
+
+// DownloadLatest downloads to w the most recently uploaded file whose name
+// starts with prefix, like the newest of timestamped builds. Listings are not
+// sorted by time, so every file under prefix is listed to find it, up to
+// 10000 files, beyond which ErrListingTruncated is returned. If there is no
+// file under prefix, ErrFileNotFound is returned.
+func (b *Bucket) DownloadLatest(ctx context.Context, prefix string, w io.Writer) (*FileInfo, error) {
+	var latest *FileInfo
+	l := b.ListFiles(ctx, ListOptions{Prefix: prefix, MaxPages: maxLatestPages})
+	l.SetPageCount(maxCount)
+	for l.Next() {
+		if fi := l.FileInfo(); latest == nil || fi.UploadTimestampMillis > latest.UploadTimestampMillis {
+			latest = fi
+		}
+	}
+	if err := l.Err(); err != nil {
+		return nil, err
+	}
+	if latest == nil {
+		return nil, ErrFileNotFound
+	}
+	return b.c.DownloadTo(ctx, DownloadOptions{FileID: latest.ID}, w)
+}
+
+// storedBytes reports whether the body of a full download with o, whose
+// response headers are h, is made of the stored bytes of the file, which
+// ContentSHA1 describes.
+func (o *DownloadOptions) storedBytes(h http.Header) bool {
+	ce, stored := h.Get("Content-Encoding"), h.Get("X-Bz-Info-b2-content-encoding")
+	if ce == "" && stored != "" && !strings.EqualFold(stored, "identity") {
+		// Decompressed by net/http, which removes Content-Encoding.
+		return false
+	}
+	gzipped := strings.EqualFold(ce, "gzip")
+	decompressed := o.Decompress && gzipped
+	return compressedOnTheFly(h) == decompressed && o.Range == (Range{}) && o.MaxBytes <= 0
+}
+
+// compressedOnTheFly reports whether a download response with headers h was
+// compressed by a proxy, rather than stored compressed.
+func compressedOnTheFly(h http.Header) bool {
+	ce := h.Get("Content-Encoding")
+	return ce != "" && !strings.EqualFold(ce, "identity") &&
+		!strings.EqualFold(h.Get("X-Bz-Info-b2-content-encoding"), ce)
+}
+
+// DownloadRanges downloads several ranges of a file concurrently, one
+// request each, like DownloadFile with o.Range set to each of ranges in turn.
+// It returns once every request has been answered, with one ReadCloser per
+// range, in order, which must all be closed by the caller.
+//
+// Failures are reported per range: a range that could not be downloaded gets
+// a ReadCloser that returns the error on Read, and the others are usable.
+// The error is only non-nil if the options are invalid.
+func (c *Client) DownloadRanges(ctx context.Context, o DownloadOptions, ranges []Range) ([]io.ReadCloser, error) {
+	if o.Range != (Range{}) {
+		return nil, errors.New("DownloadOptions.Range must be empty, use ranges")
+	}
+	for _, r := range ranges {
+		if _, err := r.header(); err != nil {
+			return nil, err
+		}
+	}
+	rcs := make([]io.ReadCloser, len(ranges))
+	var wg sync.WaitGroup
+	for i, r := range ranges {
+		wg.Add(1)
+		go func(i int, r Range) {
+			defer wg.Done()
+			o := o
+			o.Range = r
+			rc, _, err := c.DownloadFile(ctx, o)
+			if err != nil {
+				rc = errReadCloser{err}
+			}
+			rcs[i] = rc
+		}(i, r)
+	}
+	wg.Wait()
+	return rcs, nil
+}
+
+// errReadCloser fails every Read with err.
+type errReadCloser struct {
+	err error
+}
+
+func (e errReadCloser) Read([]byte) (int, error) { return 0, e.err }
+func (e errReadCloser) Close() error             { return nil }
+
+// A BufferPool provides the buffers used to copy file contents, to reduce
+// allocations when serving many concurrent downloads. Get can return a nil
+// or empty slice, in which case a 32KB buffer is allocated. It is usually
+// implemented on top of a sync.Pool.
+type BufferPool interface {
+	Get() []byte
+	Put([]byte)
+}
+
+const defaultBufferSize = 32 * 1024
+
+// copyBuffer is like io.Copy, but uses a buffer from pool if not nil.
+func copyBuffer(w io.Writer, r io.Reader, pool BufferPool) (int64, error) {
+	var buf []byte
+	if pool != nil {
+		buf = pool.Get()
+		defer pool.Put(buf)
+	}
+	if len(buf) == 0 {
+		buf = make([]byte, defaultBufferSize)
+	}
+	// Hide ReaderFrom and WriterTo, which would not use buf.
+	return io.CopyBuffer(struct{ io.Writer }{w}, struct{ io.Reader }{r}, buf)
 }
 
 // DownloadFileByID gets file contents by file ID. The ReadCloser must be
@@ -95,14 +748,14 @@ func (c *Client) DownloadFile(ctx context.Context, o DownloadOptions) (io.ReadCl
 // Note: the (*FileInfo).CustomMetadata values returned by this function are
 // all represented as strings, because they are delivered by HTTP headers.
 func (c *Client) DownloadFileByID(ctx context.Context, id string) (io.ReadCloser, *FileInfo, error) {
-	downloadURL := c.loginInfo.Load().(*LoginInfo).DownloadURL
+	downloadURL := c.downloadURL()
 	U := downloadURL + apiPath + "b2_download_file_by_id?fileId=" + id
-	res, err := c.getWithAuth(ctx, U, "")
+	res, err := c.getWithAuth(ctx, U, "", "")
 	if err != nil {
-		debugf("download %s: %s", id, err)
+		c.debugf("download %s: %s", id, err)
 		return nil, nil, err
 	}
-	debugf("download %s (%s)", id, res.Header.Get("X-Bz-Content-Sha1"))
+	c.debugf("download %s (%s)", id, res.Header.Get("X-Bz-Content-Sha1"))
 
 	fi, err := parseFileInfoHeaders(res.Header)
 	return res.Body, fi, err
@@ -114,35 +767,97 @@ func (c *Client) DownloadFileByID(ctx context.Context, id string) (io.ReadCloser
 // Note: the (*FileInfo).CustomMetadata values returned by this function are
 // all represented as strings, because they are delivered by HTTP headers.
 func (c *Client) DownloadFileByName(ctx context.Context, bucket, file string) (io.ReadCloser, *FileInfo, error) {
-	downloadURL := c.loginInfo.Load().(*LoginInfo).DownloadURL
+	downloadURL := c.downloadURL()
 	U := downloadURL + "/file/" + bucket + "/" + file
-	res, err := c.getWithAuth(ctx, U, "")
+	res, err := c.getWithAuth(ctx, U, "", "")
 	if err != nil {
-		debugf("download %s: %s", file, err)
+		c.debugf("download %s: %s", file, err)
 		return nil, nil, err
 	}
-	debugf("download %s (%s)", file, res.Header.Get("X-Bz-Content-Sha1"))
+	c.debugf("download %s (%s)", file, res.Header.Get("X-Bz-Content-Sha1"))
 
 	fi, err := parseFileInfoHeaders(res.Header)
 	return res.Body, fi, err
 }
 
+// maxLineSize is the longest line a Lines can return.
+const maxLineSize = 16 * 1024 * 1024
+
+// Lines is a bufio.Scanner over the body of a download, returned by OpenLines.
+// The body is closed as soon as Scan returns false, either because the end of
+// the file was reached or because of an error.
+type Lines struct {
+	*bufio.Scanner
+	body io.ReadCloser
+}
+
+// Scan advances to the next line, closing the body when there are no more.
+func (l *Lines) Scan() bool {
+	if l.Scanner.Scan() {
+		return true
+	}
+	l.Close()
+	return false
+}
+
+// Close closes the body of the download. It is only needed when stopping
+// before Scan returns false, and it is safe to call multiple times.
+func (l *Lines) Close() error {
+	if l.body == nil {
+		return nil
+	}
+	err := l.body.Close()
+	l.body = nil
+	return err
+}
+
+// OpenLines is like DownloadFile, but returns the file contents split into
+// lines. Lines up to 16MB long are supported, longer ones make Scan fail with
+// bufio.ErrTooLong.
+func (c *Client) OpenLines(ctx context.Context, o DownloadOptions) (*Lines, *FileInfo, error) {
+	rc, fi, err := c.DownloadFile(ctx, o)
+	if err != nil {
+		if rc != nil {
+			rc.Close()
+		}
+		return nil, nil, err
+	}
+	s := bufio.NewScanner(rc)
+	s.Buffer(make([]byte, 64*1024), maxLineSize)
+	return &Lines{Scanner: s, body: rc}, fi, nil
+}
+
 func parseFileInfoHeaders(h http.Header) (*FileInfo, error) {
 	fi := &FileInfo{
 		ID:          h.Get("X-Bz-File-Id"),
 		Name:        h.Get("X-Bz-File-Name"),
 		ContentType: h.Get("Content-Type"),
-		ContentSHA1: h.Get("X-Bz-Content-Sha1"),
+		ContentSHA1: largeFileSHA1(h.Get("X-Bz-Content-Sha1"), h.Get("X-Bz-Info-large_file_sha1")),
 		Action:      "upload",
 	}
 	timestamp, err := strconv.ParseInt(h.Get("X-Bz-Upload-Timestamp"), 10, 64)
 	if err != nil {
 		return nil, err
 	}
-	fi.UploadTimestamp = time.Unix(timestamp/1e3, timestamp%1e3*1e6)
-	fi.ContentLength, err = strconv.ParseInt(h.Get("Content-Length"), 10, 64)
-	if err != nil {
-		return nil, err
+	fi.UploadTimestamp = time.UnixMilli(timestamp)
+	fi.UploadTimestampMillis = timestamp
+	// net/http removes Content-Length when it decompresses a body.
+	fi.ContentLength = -1
+	if cl := h.Get("Content-Length"); cl != "" {
+		if fi.ContentLength, err = strconv.ParseInt(cl, 10, 64); err != nil {
+			return nil, err
+		}
+	}
+
+	if alg := h.Get("X-Bz-Server-Side-Encryption"); alg != "" {
+		fi.Encryption = EncryptionInfo{Mode: "SSE-B2", Algorithm: alg}
+	}
+	if alg := h.Get("X-Bz-Server-Side-Encryption-Customer-Algorithm"); alg != "" {
+		fi.Encryption = EncryptionInfo{
+			Mode:           "SSE-C",
+			Algorithm:      alg,
+			CustomerKeyMD5: h.Get("X-Bz-Server-Side-Encryption-Customer-Key-Md5"),
+		}
 	}
 
 	fi.CustomMetadata = make(map[string]string)
@@ -150,7 +865,11 @@ func parseFileInfoHeaders(h http.Header) (*FileInfo, error) {
 		if !strings.HasPrefix(name, "X-Bz-Info-") {
 			continue
 		}
-		fi.CustomMetadata[name[len("X-Bz-Info-"):]] = h.Get(name)
+		v := h.Get(name)
+		if u, err := url.PathUnescape(v); err == nil {
+			v = u
+		}
+		fi.CustomMetadata[name[len("X-Bz-Info-"):]] = v
 	}
 
 	return fi, nil
@@ -0,0 +1,79 @@
+package b2
+
+import (
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+)
+
+// ContentHashes are the hashes computed by EncodeContent.
+type ContentHashes struct {
+	// ContentSHA1 is the hex SHA1 of the original contents, which doesn't
+	// depend on the encoding, for example to key a deduplication index.
+	ContentSHA1   string
+	ContentLength int64
+
+	// StoredSHA1 is the hex SHA1 of the encoded bytes, which B2 stores and
+	// reports as FileInfo.ContentSHA1.
+	StoredSHA1   string
+	StoredLength int64
+}
+
+// EncodeContent reads r, encodes it with encoding, either "gzip" or "" (or
+// "identity") for none, and writes the result to dst, which can be nil to
+// only compute the hashes. Both the original and the encoded contents are
+// hashed in the same pass.
+//
+// The gzip output has no name nor modification time, so that the same
+// contents always produce the same StoredSHA1. Upload the result with the
+// matching UploadOptions.ContentEncoding.
+func EncodeContent(dst io.Writer, r io.Reader, encoding string) (ContentHashes, error) {
+	if dst == nil {
+		dst = io.Discard
+	}
+	stored := &countingHash{h: sha1.New()}
+	out := io.MultiWriter(dst, stored)
+
+	var w io.Writer
+	var zw *gzip.Writer
+	switch strings.ToLower(encoding) {
+	case "", "identity":
+		w = out
+	case "gzip":
+		zw = gzip.NewWriter(out)
+		w = zw
+	default:
+		return ContentHashes{}, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+
+	content := &countingHash{h: sha1.New()}
+	if _, err := io.Copy(io.MultiWriter(w, content), r); err != nil {
+		return ContentHashes{}, err
+	}
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			return ContentHashes{}, err
+		}
+	}
+	return ContentHashes{
+		ContentSHA1:   hex.EncodeToString(content.h.Sum(nil)),
+		ContentLength: content.n,
+		StoredSHA1:    hex.EncodeToString(stored.h.Sum(nil)),
+		StoredLength:  stored.n,
+	}, nil
+}
+
+// countingHash hashes and counts the bytes written to it.
+type countingHash struct {
+	h hash.Hash
+	n int64
+}
+
+func (c *countingHash) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return c.h.Write(p)
+}
@@ -4,7 +4,14 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 )
 
 // DeleteFile deletes a file version.
@@ -19,6 +26,170 @@ func (c *Client) DeleteFile(ctx context.Context, id, name string) error {
 	return nil
 }
 
+// MetadataDirective controls the content type and metadata of a copied file.
+type MetadataDirective string
+
+const (
+	MetadataCopy    MetadataDirective = "COPY"    // Keep the ones of the source file.
+	MetadataReplace MetadataDirective = "REPLACE" // Use the ones in CopyOptions.
+)
+
+// CopyOptions are the options of (*Client).CopyFile.
+type CopyOptions struct {
+	// DestinationBucketID is the bucket to copy to. If "", the file is
+	// copied in the bucket of the source file.
+	DestinationBucketID string
+
+	// Range, if set, copies only part of the source file.
+	Range Range
+
+	// MetadataDirective defaults to MetadataCopy. ContentType is required
+	// with MetadataReplace, and ContentType and Metadata must be empty with
+	// MetadataCopy.
+	MetadataDirective MetadataDirective
+	ContentType       string
+	Metadata          map[string]string
+
+	// FileRetention and LegalHold, if set, lock the copy, which requires a
+	// destination bucket with file lock enabled, and an application key
+	// allowed to write file retentions and legal holds. Otherwise the
+	// default retention of the bucket, if any, applies.
+	FileRetention *FileRetention
+	LegalHold     LegalHold
+}
+
+// A FileRetention prevents a file version from being deleted or overwritten
+// until RetainUntil. In "governance" mode, it can be lifted by keys with the
+// bypassGovernance capability; in "compliance" mode, it can only be extended.
+type FileRetention struct {
+	Mode        string // "governance" or "compliance"
+	RetainUntil time.Time
+}
+
+func (r *FileRetention) params() (map[string]interface{}, error) {
+	switch r.Mode {
+	case "governance", "compliance":
+	default:
+		return nil, fmt.Errorf("invalid retention mode %q", r.Mode)
+	}
+	if !r.RetainUntil.After(time.Now()) {
+		return nil, fmt.Errorf("retention until %v is not in the future", r.RetainUntil)
+	}
+	return map[string]interface{}{
+		"mode":                 r.Mode,
+		"retainUntilTimestamp": r.RetainUntil.UnixMilli(),
+	}, nil
+}
+
+// A LegalHold prevents a file version from being deleted or overwritten while
+// it's on, regardless of its retention.
+type LegalHold string
+
+const (
+	LegalHoldOn  LegalHold = "on"
+	LegalHoldOff LegalHold = "off"
+)
+
+// CopyFile calls b2_copy_file to make a server-side copy of the file version
+// sourceID, named name.
+func (c *Client) CopyFile(ctx context.Context, sourceID, name string, o CopyOptions) (*FileInfo, error) {
+	if err := ValidateFileName(name); err != nil {
+		return nil, err
+	}
+	params := map[string]interface{}{
+		"sourceFileId": sourceID,
+		"fileName":     name,
+	}
+	switch o.MetadataDirective {
+	case "", MetadataCopy:
+		if len(o.ContentType) > 0 || len(o.Metadata) > 0 {
+			return nil, errors.New("ContentType and Metadata can only be set with MetadataReplace")
+		}
+	case MetadataReplace:
+		if len(o.ContentType) == 0 {
+			return nil, errors.New("ContentType is required with MetadataReplace")
+		}
+		params["metadataDirective"] = o.MetadataDirective
+		params["contentType"] = o.ContentType
+		params["fileInfo"] = o.Metadata
+		if o.Metadata == nil {
+			params["fileInfo"] = map[string]string{}
+		}
+	default:
+		return nil, fmt.Errorf("invalid metadata directive %q", o.MetadataDirective)
+	}
+	if len(o.DestinationBucketID) > 0 {
+		params["destinationBucketId"] = o.DestinationBucketID
+	}
+	locked := o.FileRetention != nil || o.LegalHold != ""
+	if o.FileRetention != nil {
+		r, err := o.FileRetention.params()
+		if err != nil {
+			return nil, err
+		}
+		params["fileRetention"] = r
+	}
+	switch o.LegalHold {
+	case "":
+	case LegalHoldOn, LegalHoldOff:
+		params["legalHold"] = o.LegalHold
+	default:
+		return nil, fmt.Errorf("invalid legal hold %q", o.LegalHold)
+	}
+	// The source bucket is unknown here: B2 rejects the copy if it's used
+	// and doesn't have file lock enabled.
+	if locked && len(o.DestinationBucketID) > 0 {
+		if err := c.checkFileLock(ctx, o.DestinationBucketID); err != nil {
+			return nil, err
+		}
+	}
+	rs, err := o.Range.header()
+	if err != nil {
+		return nil, err
+	}
+	if len(rs) > 0 {
+		params["range"] = rs
+	}
+
+	res, err := c.doRequest(ctx, "b2_copy_file", params)
+	if _, ok := UnwrapError(err); ok && locked {
+		return nil, fmt.Errorf("copying %s with a retention or legal hold: %w", name, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer drainAndClose(res.Body)
+	var fi fileInfoObj
+	if err := json.NewDecoder(res.Body).Decode(&fi); err != nil {
+		return nil, err
+	}
+	return fi.makeFileInfo(), nil
+}
+
+// CopyFileMergeMetadata copies the file version sourceID like CopyFile, in
+// the same bucket, keeping its content type and custom metadata but adding
+// or overwriting the keys of add. The source metadata is fetched first, so a
+// concurrent change to it may be lost. To change the content type too, use
+// CopyFile with MetadataReplace.
+func (c *Client) CopyFileMergeMetadata(ctx context.Context, sourceID, destName string, add map[string]string) (*FileInfo, error) {
+	src, err := c.GetFileInfoByID(ctx, sourceID)
+	if err != nil {
+		return nil, err
+	}
+	metadata := make(map[string]string, len(src.CustomMetadata)+len(add))
+	for k, v := range src.CustomMetadata {
+		metadata[k] = v
+	}
+	for k, v := range add {
+		metadata[k] = v
+	}
+	return c.CopyFile(ctx, sourceID, destName, CopyOptions{
+		MetadataDirective: MetadataReplace,
+		ContentType:       src.ContentType,
+		Metadata:          metadata,
+	})
+}
+
 type FileAction string
 
 const (
@@ -36,16 +207,91 @@ type FileInfo struct {
 	// Had to remove BucketID since it is not returned by b2_download_file_by_*
 	// BucketID string
 
-	ContentSHA1   string // hex encoded
+	// ContentSHA1 is hex encoded. For large files, it's the large_file_sha1
+	// file info value if set, or "none" otherwise.
+	ContentSHA1   string
 	ContentLength int64
 	ContentType   string
 
 	CustomMetadata  map[string]string
 	UploadTimestamp time.Time
 
+	// UploadTimestampMillis is the upload time as returned by B2, in
+	// milliseconds since the Unix epoch, UTC.
+	UploadTimestampMillis int64
+
 	// If Action is "hide", this ID does not refer to a file version
 	// but to an hiding action. Otherwise "upload".
 	Action FileAction
+
+	// Encryption describes the server-side encryption of the file, and is
+	// zero for unencrypted files.
+	Encryption EncryptionInfo
+}
+
+// MetadataInt returns the custom metadata value key parsed as a decimal
+// integer. ok is false if the key is missing or the value is not an integer.
+func (fi *FileInfo) MetadataInt(key string) (n int64, ok bool) {
+	n, err := strconv.ParseInt(fi.CustomMetadata[key], 10, 64)
+	return n, err == nil
+}
+
+// MetadataTime returns the custom metadata value key parsed as a time, either
+// an integer number of milliseconds since the Unix epoch, like
+// src_last_modified_millis, an RFC 3339 time, or an HTTP date, like
+// b2-expires. ok is false if the key is missing or the value is none of them.
+func (fi *FileInfo) MetadataTime(key string) (t time.Time, ok bool) {
+	if ms, ok := fi.MetadataInt(key); ok {
+		return time.UnixMilli(ms), true
+	}
+	v := fi.CustomMetadata[key]
+	if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+		return t, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// LastModified returns the modification time of the source of the file, from
+// the src_last_modified_millis key set by UploadOptions.LastModified. ok is
+// false if it's missing or invalid.
+func (fi *FileInfo) LastModified() (t time.Time, ok bool) {
+	ms, ok := fi.MetadataInt("src_last_modified_millis")
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(ms), true
+}
+
+// EncryptionInfo describes the server-side encryption of a file.
+type EncryptionInfo struct {
+	// Mode is "SSE-B2" for keys managed by B2, or "SSE-C" for keys provided
+	// by the customer.
+	Mode      string
+	Algorithm string // like "AES256"
+
+	// CustomerKeyMD5 is the base64 MD5 of the customer key, for SSE-C. It is
+	// only set on download.
+	CustomerKeyMD5 string
+}
+
+type encryptionObj struct {
+	Mode      *string `json:"mode"`
+	Algorithm *string `json:"algorithm"`
+}
+
+func (e *encryptionObj) makeEncryptionInfo() EncryptionInfo {
+	var info EncryptionInfo
+	if e == nil || e.Mode == nil {
+		return info
+	}
+	info.Mode = *e.Mode
+	if e.Algorithm != nil {
+		info.Algorithm = *e.Algorithm
+	}
+	return info
 }
 
 type fileInfoObj struct {
@@ -59,18 +305,30 @@ type fileInfoObj struct {
 	FileName        string            `json:"fileName"`
 	UploadTimestamp int64             `json:"uploadTimestamp"`
 	Action          string            `json:"action"`
+	Encryption      *encryptionObj    `json:"serverSideEncryption"`
+}
+
+// largeFileSHA1 returns the large_file_sha1 file info value for large files,
+// whose reported SHA1 is "none".
+func largeFileSHA1(sha1Sum, largeSHA1 string) string {
+	if sha1Sum == "none" && largeSHA1 != "" {
+		return largeSHA1
+	}
+	return sha1Sum
 }
 
 func (fi *fileInfoObj) makeFileInfo() *FileInfo {
 	return &FileInfo{
-		ID:              fi.FileID,
-		Name:            fi.FileName,
-		ContentLength:   fi.ContentLength,
-		ContentSHA1:     fi.ContentSHA1,
-		ContentType:     fi.ContentType,
-		CustomMetadata:  fi.FileInfo,
-		Action:          FileAction(fi.Action),
-		UploadTimestamp: time.Unix(fi.UploadTimestamp/1e3, fi.UploadTimestamp%1e3*1e6),
+		ID:                    fi.FileID,
+		Name:                  fi.FileName,
+		ContentLength:         fi.ContentLength,
+		ContentSHA1:           largeFileSHA1(fi.ContentSHA1, fi.FileInfo["large_file_sha1"]),
+		ContentType:           fi.ContentType,
+		CustomMetadata:        fi.FileInfo,
+		Action:                FileAction(fi.Action),
+		UploadTimestamp:       time.UnixMilli(fi.UploadTimestamp),
+		UploadTimestampMillis: fi.UploadTimestamp,
+		Encryption:            fi.Encryption.makeEncryptionInfo(),
 	}
 }
 
@@ -112,6 +370,163 @@ func (b *Bucket) GetFileInfoByName(ctx context.Context, name string) (*FileInfo,
 	return nil, ErrFileNotFound
 }
 
+// ExistingNames calls GetFileInfoByName for each of names, up to n at the same
+// time, or 4 if n is 0, and returns the FileInfo of those that exist, by name.
+// Missing files are left out of the map. On the first other error, the
+// remaining calls are abandoned and the error is returned.
+func (b *Bucket) ExistingNames(ctx context.Context, names []string, n int) (map[string]*FileInfo, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		mu       sync.Mutex
+		existing = make(map[string]*FileInfo)
+		firstErr error
+	)
+	work := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency(n); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range work {
+				fi, err := b.GetFileInfoByName(ctx, name)
+				mu.Lock()
+				switch {
+				case err == nil:
+					existing[name] = fi
+				case err != ErrFileNotFound && firstErr == nil:
+					firstErr = err
+					cancel()
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+send:
+	for _, name := range names {
+		select {
+		case work <- name:
+		case <-ctx.Done():
+			break send
+		}
+	}
+	close(work)
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return existing, nil
+}
+
+// InfoForNames returns the FileInfo of the current version of those of names
+// that exist, by name, like ExistingNames, but lists the common prefix of the
+// names once instead of looking each of them up, from the first name to the
+// last, so that names clustered under a prefix cost a few list pages rather
+// than one call each. Missing files are left out of the map.
+//
+// The listing is limited to a page for every other name. If it's cut short,
+// because the names are spread over many other files, the names not reached
+// yet are looked up with ExistingNames, as are all the names when they have
+// no common prefix.
+func (b *Bucket) InfoForNames(ctx context.Context, names []string) (map[string]*FileInfo, error) {
+	sorted := make([]string, 0, len(names))
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if !seen[name] {
+			seen[name] = true
+			sorted = append(sorted, name)
+		}
+	}
+	if len(sorted) == 0 {
+		return make(map[string]*FileInfo), nil
+	}
+	sort.Strings(sorted)
+	first, last := sorted[0], sorted[len(sorted)-1]
+	prefix := commonPrefix(first, last)
+	if len(sorted) == 1 || prefix == "" {
+		return b.ExistingNames(ctx, sorted, 0)
+	}
+
+	found := make(map[string]*FileInfo)
+	l := b.ListFiles(ctx, ListOptions{Prefix: prefix, FromName: first, MaxPages: (len(sorted) + 1) / 2})
+	l.SetPageCount(maxCount)
+	reached := ""
+	for l.Next() {
+		fi := l.FileInfo()
+		if fi.Name > last {
+			return found, nil
+		}
+		if seen[fi.Name] {
+			found[fi.Name] = fi
+		}
+		reached = fi.Name
+	}
+	switch err := l.Err(); {
+	case err == ErrListingTruncated:
+	case err != nil:
+		return nil, err
+	default:
+		return found, nil
+	}
+
+	b.c.debugf("InfoForNames %s: listing truncated at %q", prefix, reached)
+	rest := sorted[sort.SearchStrings(sorted, reached):]
+	if len(rest) > 0 && rest[0] == reached {
+		rest = rest[1:]
+	}
+	existing, err := b.ExistingNames(ctx, rest, 0)
+	if err != nil {
+		return nil, err
+	}
+	for name, fi := range existing {
+		found[name] = fi
+	}
+	return found, nil
+}
+
+// commonPrefix returns the longest common prefix of a and b, cut to a whole
+// number of UTF-8 characters.
+func commonPrefix(a, b string) string {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	for n > 0 && n < len(a) && !utf8.RuneStart(a[n]) {
+		n--
+	}
+	return a[:n]
+}
+
+// WaitForFile calls GetFileInfoByName until the file name exists, waiting
+// between attempts with an exponential backoff from 100ms up to 5s. If the
+// file does not appear within timeout, ErrFileNotFound is returned.
+//
+// B2 listings, which GetFileInfoByName relies on, are eventually consistent:
+// a file might not appear in them for a short while after its upload
+// succeeded, even to the client that uploaded it.
+func (b *Bucket) WaitForFile(ctx context.Context, name string, timeout time.Duration) (*FileInfo, error) {
+	deadline := time.Now().Add(timeout)
+	delay := 100 * time.Millisecond
+	for {
+		fi, err := b.GetFileInfoByName(ctx, name)
+		if err != ErrFileNotFound {
+			return fi, err
+		}
+		if time.Until(deadline) < delay {
+			return nil, ErrFileNotFound
+		}
+		if err := sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+		if delay *= 2; delay > 5*time.Second {
+			delay = 5 * time.Second
+		}
+	}
+}
+
 // A Listing is the result of (*Bucket).ListFiles[Versions].
 // It works like sql.Rows: use Next to advance and then FileInfo.
 // Check Err once Next returns false.
@@ -133,9 +548,17 @@ type Listing struct {
 	ctx              context.Context
 	b                *Bucket
 	versions         bool
+	unfinished       bool
 	nextPageCount    int
 	nextName, nextID *string
+	startName        *string // to Reset nextName
+	startID          *string // to Reset nextID
 	prefix, delim    string
+	extraDelims      []string
+	lastFolder       string // last folder made from extraDelims
+	since            time.Time
+	filters          []func(*FileInfo) bool
+	maxPages, pages  int
 	objects          []*FileInfo // in reverse order
 	err              error
 }
@@ -158,6 +581,66 @@ func (l *Listing) SetPageCount(n int) {
 // or an error happened while preparing it. Err should be
 // consulted to distinguish between the two cases.
 func (l *Listing) Next() bool {
+	for l.next() {
+		if l.match(l.FileInfo()) && l.collapse() {
+			return true
+		}
+	}
+	return false
+}
+
+// collapse replaces the current result with a folder if its name contains
+// one of the extra delimiters after the prefix, like B2 does for Delimiter.
+// It returns false if the folder was already returned.
+func (l *Listing) collapse() bool {
+	if len(l.extraDelims) == 0 {
+		return true
+	}
+	rest := strings.TrimPrefix(l.FileInfo().Name, l.prefix)
+	end := -1
+	for _, d := range l.extraDelims {
+		if i := strings.Index(rest, d); i >= 0 && (end < 0 || i+len(d) < end) {
+			end = i + len(d)
+		}
+	}
+	if end < 0 {
+		return true
+	}
+	// Names sharing a folder are listed contiguously.
+	name := l.prefix + rest[:end]
+	if name == l.lastFolder {
+		return false
+	}
+	l.lastFolder = name
+	l.objects[len(l.objects)-1] = &FileInfo{Name: name, Action: FileFolder}
+	return true
+}
+
+// match reports whether fi passes the client-side filters of the Listing.
+func (l *Listing) match(fi *FileInfo) bool {
+	if !l.since.IsZero() && fi.UploadTimestamp.Before(l.since) {
+		return false
+	}
+	for _, f := range l.filters {
+		if !f(fi) {
+			return false
+		}
+	}
+	return true
+}
+
+// Filter makes the Listing skip the results for which fn returns false, and
+// returns it, so that it can be chained after ListFiles. Filters add up.
+//
+// Filtering happens client-side, as pages are consumed: it doesn't reduce the
+// number of API calls. Err still reports the errors of the Listing.
+func (l *Listing) Filter(fn func(*FileInfo) bool) *Listing {
+	l.filters = append(l.filters, fn)
+	return l
+}
+
+// next advances to the next result, fetching a new page if needed.
+func (l *Listing) next() bool {
 	if l.err != nil {
 		return false
 	}
@@ -170,22 +653,34 @@ func (l *Listing) Next() bool {
 	if l.nextName == nil {
 		return false // end of iteration
 	}
+	if l.maxPages > 0 && l.pages >= l.maxPages {
+		l.err = ErrListingTruncated
+		return false
+	}
+	l.pages++
 
 	data := map[string]interface{}{
-		"bucketId":      l.b.ID,
-		"startFileName": *l.nextName,
-		"maxFileCount":  l.nextPageCount,
+		"bucketId":     l.b.ID,
+		"maxFileCount": l.nextPageCount,
 	}
-	if len(l.prefix) > 0 {
-		data["prefix"] = l.prefix
-	}
-	if len(l.delim) > 0 {
-		data["delimiter"] = l.delim
-	}
-
 	endpoint := "b2_list_file_names"
-	if l.versions {
-		endpoint = "b2_list_file_versions"
+	switch {
+	case l.unfinished:
+		endpoint = "b2_list_unfinished_large_files"
+		if len(l.prefix) > 0 {
+			data["namePrefix"] = l.prefix
+		}
+	default:
+		if l.versions {
+			endpoint = "b2_list_file_versions"
+		}
+		data["startFileName"] = *l.nextName
+		if len(l.prefix) > 0 {
+			data["prefix"] = l.prefix
+		}
+		if len(l.delim) > 0 {
+			data["delimiter"] = l.delim
+		}
 	}
 	if l.nextID != nil && *l.nextID != "" {
 		data["startFileId"] = *l.nextID
@@ -211,9 +706,26 @@ func (l *Listing) Next() bool {
 		l.objects[len(l.objects)-1-i] = f.makeFileInfo()
 	}
 	l.nextName, l.nextID = x.NextFileName, x.NextFileID
+	if l.unfinished && x.NextFileID != nil {
+		// b2_list_unfinished_large_files only paginates by ID.
+		l.nextName = new(string)
+	}
 	return len(l.objects) > 0
 }
 
+// Reset rewinds the Listing to its starting point, discarding any buffered
+// results, so that the following call to Next fetches the first page again.
+func (l *Listing) Reset() {
+	if l.b == nil {
+		return // invalid options, keep the error
+	}
+	l.nextName, l.nextID = l.startName, l.startID
+	l.pages = 0
+	l.objects = nil
+	l.lastFolder = ""
+	l.err = nil
+}
+
 // FileInfo returns the FileInfo object made available by Next.
 //
 // FileInfo must only be called after a call to Next returned true.
@@ -231,8 +743,30 @@ type ListOptions struct {
 	FromID    string // Only used for List File Versions, must set FromName.
 	Prefix    string
 	Delimiter string
+
+	// ExtraDelimiters are folder delimiters applied client-side, in addition
+	// to Delimiter, as B2 only supports one. Names containing one of them
+	// after the prefix are returned as a single FileFolder entry, cut after
+	// the first delimiter, like B2 does for Delimiter. Files collapsed this
+	// way are still fetched, so this doesn't reduce the number of API calls.
+	ExtraDelimiters []string
+
+	// Since, if set, skips files uploaded before it, including folders.
+	// B2 can't filter by time, and listings are not sorted by time, so
+	// this doesn't reduce the number of API calls: the whole listing is
+	// still fetched, and filtered as pages are consumed.
+	Since time.Time
+
+	// MaxPages, if positive, limits the number of list API calls made by the
+	// Listing, to bound the transaction cost. If more results are left
+	// once the limit is reached, Err returns ErrListingTruncated.
+	MaxPages int
 }
 
+// ErrListingTruncated is returned by (*Listing).Err when ListOptions.MaxPages
+// stopped the Listing before its end.
+var ErrListingTruncated = errors.New("b2: listing truncated by MaxPages")
+
 // ListFiles returns a Listing of files in the Bucket, alphabetically sorted,
 // starting from the file named fromName (included if it exists). To start from
 // the first file in the bucket, set fileName to "".
@@ -240,13 +774,18 @@ type ListOptions struct {
 // ListFiles only returns the most recent version of each (non-hidden) file.
 // If you want to fetch all versions, use ListFilesVersions.
 func (b *Bucket) ListFiles(ctx context.Context, o ListOptions) *Listing {
-	return &Listing{
-		ctx:      ctx,
-		b:        b,
-		nextName: &o.FromName,
-		prefix:   o.Prefix,
-		delim:    o.Delimiter,
+	l := &Listing{
+		ctx:         ctx,
+		b:           b,
+		nextName:    &o.FromName,
+		prefix:      o.Prefix,
+		delim:       o.Delimiter,
+		extraDelims: o.ExtraDelimiters,
+		since:       o.Since,
+		maxPages:    o.MaxPages,
 	}
+	l.startName = l.nextName
+	return l
 }
 
 // ListFilesVersions is like ListFiles, but returns all file versions,
@@ -259,13 +798,475 @@ func (b *Bucket) ListFileVersions(ctx context.Context, o ListOptions) *Listing {
 			err: errors.New("can't set FromID if FromName is not set"),
 		}
 	}
-	return &Listing{
-		ctx:      ctx,
-		b:        b,
-		versions: true,
-		nextName: &o.FromName,
-		nextID:   &o.FromID,
-		prefix:   o.Prefix,
-		delim:    o.Delimiter,
+	l := &Listing{
+		ctx:         ctx,
+		b:           b,
+		versions:    true,
+		nextName:    &o.FromName,
+		nextID:      &o.FromID,
+		prefix:      o.Prefix,
+		delim:       o.Delimiter,
+		extraDelims: o.ExtraDelimiters,
+		since:       o.Since,
+		maxPages:    o.MaxPages,
+	}
+	l.startName, l.startID = l.nextName, l.nextID
+	return l
+}
+
+// ListGroupedVersions is like ListFileVersions, but calls fn once per file
+// name, with all the versions of that file, newest first. Versions spanning
+// multiple pages of results are grouped together.
+//
+// If fn returns an error, the listing stops and the error is returned.
+func (b *Bucket) ListGroupedVersions(ctx context.Context, o ListOptions, fn func(name string, versions []*FileInfo) error) error {
+	l := b.ListFileVersions(ctx, o)
+	var versions []*FileInfo
+	for l.Next() {
+		fi := l.FileInfo()
+		if len(versions) > 0 && versions[0].Name != fi.Name {
+			if err := fn(versions[0].Name, versions); err != nil {
+				return err
+			}
+			versions = nil
+		}
+		versions = append(versions, fi)
+	}
+	if err := l.Err(); err != nil {
+		return err
+	}
+	if len(versions) > 0 {
+		return fn(versions[0].Name, versions)
+	}
+	return nil
+}
+
+// SplitKey splits name after its last delimiter, ignoring a trailing one, into
+// the directory it's in and its base name. The directory keeps its trailing
+// delimiter, so that it can be used as a Prefix or with ListDir, and is ""
+// at the root. A folder name keeps its trailing delimiter in base:
+// SplitKey("a/b/c.txt", "/") is "a/b/", "c.txt", and SplitKey("a/b/", "/")
+// is "a/", "b/". An empty delimiter returns "", name.
+func SplitKey(name, delimiter string) (dir, base string) {
+	if delimiter == "" {
+		return "", name
+	}
+	i := strings.LastIndex(strings.TrimSuffix(name, delimiter), delimiter)
+	if i < 0 {
+		return "", name
+	}
+	i += len(delimiter)
+	return name[:i], name[i:]
+}
+
+// ListDir lists the immediate folders and files under dir, using delimiter to
+// separate folders, like a file manager would show them. An empty dir is the
+// root of the bucket, and a delimiter is appended to dir if missing.
+//
+// Folders are full names with their trailing delimiter, as B2 returns them,
+// so that each one can be passed back to ListDir, or to SplitKey for its
+// base name. Both folders and files are sorted by name.
+func (b *Bucket) ListDir(ctx context.Context, dir, delimiter string) (folders []string, files []*FileInfo, err error) {
+	if delimiter == "" {
+		return nil, nil, errors.New("ListDir needs a delimiter")
+	}
+	if dir != "" && !strings.HasSuffix(dir, delimiter) {
+		dir += delimiter
+	}
+	l := b.ListFiles(ctx, ListOptions{Prefix: dir, Delimiter: delimiter})
+	l.SetPageCount(maxCount)
+	for l.Next() {
+		fi := l.FileInfo()
+		if fi.Action == FileFolder {
+			folders = append(folders, fi.Name)
+		} else {
+			files = append(files, fi)
+		}
+	}
+	if err := l.Err(); err != nil {
+		return nil, nil, err
+	}
+	return folders, files, nil
+}
+
+// ErrAlreadyHidden is returned by HideFile when the file is already hidden.
+var ErrAlreadyHidden = errors.New("b2: file already hidden")
+
+// HideFile hides the file name with b2_hide_file, by uploading a hide marker
+// as its newest version, and returns that marker. The file is no longer
+// returned by ListFiles nor downloadable by name, but its older versions are
+// kept. If the file is already hidden, ErrAlreadyHidden is returned; use
+// HideFileIfVisible to make it a no-op instead.
+func (b *Bucket) HideFile(ctx context.Context, name string) (*FileInfo, error) {
+	res, err := b.c.doRequest(ctx, "b2_hide_file", map[string]interface{}{
+		"bucketId": b.ID,
+		"fileName": name,
+	})
+	if e, ok := UnwrapError(err); ok && e.Code == "already_hidden" {
+		return nil, ErrAlreadyHidden
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer drainAndClose(res.Body)
+	var fi fileInfoObj
+	if err := json.NewDecoder(res.Body).Decode(&fi); err != nil {
+		return nil, err
+	}
+	return fi.makeFileInfo(), nil
+}
+
+// HideFileIfVisible is like HideFile, but if the file is already hidden, it
+// returns the existing hide marker instead of an error, so that hide jobs can
+// safely be run again. hidden reports whether the file was hidden by this
+// call.
+func (b *Bucket) HideFileIfVisible(ctx context.Context, name string) (marker *FileInfo, hidden bool, err error) {
+	marker, err = b.HideFile(ctx, name)
+	if err != ErrAlreadyHidden {
+		return marker, err == nil, err
+	}
+	l := b.ListFileVersions(ctx, ListOptions{FromName: name, Prefix: name})
+	l.SetPageCount(1)
+	if l.Next() && l.FileInfo().Name == name && l.FileInfo().Action == FileHide {
+		return l.FileInfo(), false, nil
+	}
+	if err := l.Err(); err != nil {
+		return nil, false, err
+	}
+	// Unhidden concurrently.
+	return nil, false, ErrAlreadyHidden
+}
+
+// IsPrefix reports whether name is only a virtual folder, like the FileFolder
+// entries of a listing with the "/" delimiter: there are files under name
+// followed by "/", unless it already ends with one, but no file named
+// exactly name. If both a file and files under it exist, it returns false,
+// as name can be downloaded; use ListDir to list the folder anyway.
+func (b *Bucket) IsPrefix(ctx context.Context, name string) (bool, error) {
+	prefix := name
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	l := b.ListFiles(ctx, ListOptions{Prefix: prefix, Delimiter: "/"})
+	// A file named exactly prefix is listed first.
+	l.SetPageCount(2)
+	children := false
+	for !children && l.Next() {
+		children = l.FileInfo().Name != prefix
+	}
+	if err := l.Err(); err != nil {
+		return false, err
+	}
+	if !children {
+		return false, nil
+	}
+	switch _, err := b.GetFileInfoByName(ctx, name); err {
+	case ErrFileNotFound:
+		return true, nil
+	case nil:
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// deleteAllVersions deletes every version of the file name.
+func (b *Bucket) deleteAllVersions(ctx context.Context, name string) error {
+	l := b.ListFileVersions(ctx, ListOptions{FromName: name, Prefix: name})
+	for l.Next() {
+		fi := l.FileInfo()
+		if fi.Name != name {
+			break
+		}
+		if err := b.c.DeleteFile(ctx, fi.ID, fi.Name); err != nil {
+			return err
+		}
+	}
+	return l.Err()
+}
+
+// deleteAllFileVersions deletes every file version listed in the bucket,
+// including hide markers and unfinished large files.
+func (b *Bucket) deleteAllFileVersions(ctx context.Context) error {
+	l := b.ListFileVersions(ctx, ListOptions{})
+	for l.Next() {
+		fi := l.FileInfo()
+		if err := b.c.DeleteFile(ctx, fi.ID, fi.Name); err != nil {
+			return err
+		}
+	}
+	return l.Err()
+}
+
+// MovePrefix renames every file under oldPrefix to start with newPrefix
+// instead, up to n files at a time, or 4 if n is 0. B2 can't rename files, so
+// the current version of each one is copied server-side with CopyFile,
+// keeping its metadata, and then deleted. Older versions are left under the
+// old name, as is any version uploaded during the move. Files larger than the
+// 5GB CopyFile limit can't be moved.
+//
+// It stops at the first error, which is returned with the number of files
+// already moved, so that it can be called again to resume.
+func (b *Bucket) MovePrefix(ctx context.Context, oldPrefix, newPrefix string, n int) (moved int, err error) {
+	switch {
+	case oldPrefix == "" || newPrefix == "":
+		return 0, errors.New("MovePrefix needs non-empty prefixes")
+	case strings.HasPrefix(newPrefix, oldPrefix):
+		return 0, fmt.Errorf("new prefix %q is under the old prefix %q", newPrefix, oldPrefix)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		mu       sync.Mutex
+		firstErr error
+	)
+	files := make(chan *FileInfo)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency(n); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for fi := range files {
+				newName := newPrefix + strings.TrimPrefix(fi.Name, oldPrefix)
+				_, err := b.c.CopyFile(ctx, fi.ID, newName, CopyOptions{})
+				if err == nil {
+					err = b.c.DeleteFile(ctx, fi.ID, fi.Name)
+				}
+				mu.Lock()
+				if err == nil {
+					moved++
+				} else if firstErr == nil {
+					firstErr = fmt.Errorf("moving %s: %w", fi.Name, err)
+					cancel()
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	l := b.ListFiles(ctx, ListOptions{Prefix: oldPrefix})
+	l.SetPageCount(maxCount)
+list:
+	for l.Next() {
+		select {
+		case files <- l.FileInfo():
+		case <-ctx.Done():
+			break list
+		}
+	}
+	close(files)
+	wg.Wait()
+	if firstErr != nil {
+		return moved, firstErr
+	}
+	if err := l.Err(); err != nil {
+		return moved, err
+	}
+	return moved, ctx.Err()
+}
+
+// A VersionStat describes a version of a file, see VersionStats.
+type VersionStat struct {
+	ID              string
+	UploadTimestamp time.Time
+	Size            int64 // stored bytes, zero for hide markers
+
+	// Hide is true if the version is a hide marker, hiding the older ones.
+	Hide bool
+	// Current is true for the version returned when downloading the file by
+	// name. If the newest version is a hide marker, no version is current.
+	Current bool
+}
+
+// VersionStats returns the versions of the file name, newest first, with the
+// storage used by each. Unfinished large files are not included.
+func (b *Bucket) VersionStats(ctx context.Context, name string) ([]VersionStat, error) {
+	var stats []VersionStat
+	l := b.ListFileVersions(ctx, ListOptions{FromName: name, Prefix: name})
+	for l.Next() {
+		fi := l.FileInfo()
+		if fi.Name != name {
+			break
+		}
+		if fi.Action != FileUpload && fi.Action != FileHide {
+			continue
+		}
+		stats = append(stats, VersionStat{
+			ID:              fi.ID,
+			UploadTimestamp: fi.UploadTimestamp,
+			Size:            fi.ContentLength,
+			Hide:            fi.Action == FileHide,
+			Current:         len(stats) == 0 && fi.Action == FileUpload,
+		})
+	}
+	if err := l.Err(); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// GetFileVersionAsOf returns the version of the file name that was current at
+// time t: the newest version uploaded at or before t. If that version is a
+// hide marker, or if no version existed yet, the file was absent at t and
+// ErrFileNotFound is returned. Versions deleted since are not known.
+func (b *Bucket) GetFileVersionAsOf(ctx context.Context, name string, t time.Time) (*FileInfo, error) {
+	l := b.ListFileVersions(ctx, ListOptions{FromName: name, Prefix: name})
+	l.SetPageCount(maxCount)
+	for l.Next() {
+		fi := l.FileInfo()
+		if fi.Name != name {
+			break
+		}
+		if fi.Action != FileUpload && fi.Action != FileHide || fi.UploadTimestamp.After(t) {
+			continue
+		}
+		// Versions are listed newest first.
+		if fi.Action == FileHide {
+			return nil, ErrFileNotFound
+		}
+		return fi, nil
+	}
+	if err := l.Err(); err != nil {
+		return nil, err
+	}
+	return nil, ErrFileNotFound
+}
+
+// ErrUnsupported is returned by the methods wrapping features the B2 API
+// does not offer, so that callers can detect them.
+var ErrUnsupported = errors.New("b2: not supported by the B2 API")
+
+// FileStats holds the download statistics of a file.
+type FileStats struct {
+	Downloads int64
+}
+
+// FileStats returns the download statistics of the file name.
+//
+// The B2 API has no endpoint for download statistics, so it always returns
+// ErrUnsupported for now.
+func (b *Bucket) FileStats(ctx context.Context, name string) (*FileStats, error) {
+	return nil, ErrUnsupported
+}
+
+// ListUnfinishedLargeFiles returns a Listing of the large files in the Bucket
+// that were started but not finished or canceled, in the order they were
+// started. Only the Prefix, FromID and MaxPages options are used.
+func (b *Bucket) ListUnfinishedLargeFiles(ctx context.Context, o ListOptions) *Listing {
+	l := &Listing{
+		ctx:        ctx,
+		b:          b,
+		unfinished: true,
+		nextName:   new(string),
+		nextID:     &o.FromID,
+		prefix:     o.Prefix,
+		maxPages:   o.MaxPages,
+	}
+	l.startName, l.startID = l.nextName, l.nextID
+	return l
+}
+
+// IsEmpty reports whether the Bucket holds no file versions at all,
+// including hide markers and unfinished large files, which would make
+// Delete fail. It lists at most one file of each kind.
+func (b *Bucket) IsEmpty(ctx context.Context) (bool, error) {
+	l := b.ListFileVersions(ctx, ListOptions{})
+	l.SetPageCount(1)
+	if l.Next() {
+		return false, nil
+	}
+	if err := l.Err(); err != nil {
+		return false, err
+	}
+	l = b.ListUnfinishedLargeFiles(ctx, ListOptions{})
+	l.SetPageCount(1)
+	if l.Next() {
+		return false, nil
+	}
+	if err := l.Err(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// TotalSize returns the number of files in the Bucket, and the sum of their
+// lengths. If includeAllVersions is true, every file version is counted, as
+// well as hide markers, which have no length; otherwise only the current
+// version of the visible files is.
+//
+// It lists the whole bucket, 1000 files per API call, so it costs one class C
+// transaction per 1000 files.
+func (b *Bucket) TotalSize(ctx context.Context, includeAllVersions bool) (files, bytes int64, err error) {
+	var l *Listing
+	if includeAllVersions {
+		l = b.ListFileVersions(ctx, ListOptions{})
+	} else {
+		l = b.ListFiles(ctx, ListOptions{})
+	}
+	l.SetPageCount(maxCount)
+	for l.Next() {
+		files++
+		bytes += l.FileInfo().ContentLength
+	}
+	if err := l.Err(); err != nil {
+		return 0, 0, err
+	}
+	return files, bytes, nil
+}
+
+// BucketSummary is the usage of a bucket, returned by AccountSummary.
+type BucketSummary struct {
+	Name, Type   string
+	Files, Bytes int64 // like TotalSize with includeAllVersions
+}
+
+// AccountSummary returns the usage of every bucket of the account, sorted by
+// name, counting all file versions like TotalSize with includeAllVersions.
+// Up to n buckets are listed at the same time, or 4 if n is 0. The first
+// error stops all the listings.
+//
+// Like TotalSize, it costs one class C transaction per 1000 file versions.
+func (c *Client) AccountSummary(ctx context.Context, n int) ([]BucketSummary, error) {
+	buckets, err := c.Buckets(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	summaries := make([]BucketSummary, len(buckets))
+	errs := make([]error, len(buckets))
+	sem := make(chan struct{}, concurrency(n))
+	var wg sync.WaitGroup
+	for i, b := range buckets {
+		summaries[i] = BucketSummary{Name: b.Name, Type: b.Type}
+		wg.Add(1)
+		go func(i int, b *BucketInfo) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				errs[i] = ctx.Err()
+				return
+			}
+			s := &summaries[i]
+			s.Files, s.Bytes, errs[i] = b.TotalSize(ctx, true)
+			if errs[i] != nil {
+				cancel()
+			}
+		}(i, b)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		// Report the error that stopped the others, not their cancellation.
+		if err != nil && !errors.Is(err, context.Canceled) {
+			return nil, err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
+	return summaries, nil
 }
@@ -0,0 +1,791 @@
+package b2
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUploadTimestamp(t *testing.T) {
+	for _, ms := range []int64{
+		0, 1, 999, 1000, 1001, -1, -999, -1000, -1001,
+		1700000000123, -1700000000123,
+	} {
+		want := time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(ms) * time.Millisecond)
+
+		fi := (&fileInfoObj{UploadTimestamp: ms}).makeFileInfo()
+		if !fi.UploadTimestamp.Equal(want) || fi.UploadTimestampMillis != ms {
+			t.Errorf("makeFileInfo(%d) = %v, %d; want %v", ms, fi.UploadTimestamp, fi.UploadTimestampMillis, want)
+		}
+		if fi.UploadTimestamp.UnixMilli() != ms {
+			t.Errorf("makeFileInfo(%d) does not round trip: %d", ms, fi.UploadTimestamp.UnixMilli())
+		}
+
+		h := http.Header{}
+		h.Set("X-Bz-Upload-Timestamp", strconv.FormatInt(ms, 10))
+		h.Set("Content-Length", "0")
+		fi, err := parseFileInfoHeaders(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !fi.UploadTimestamp.Equal(want) || fi.UploadTimestampMillis != ms {
+			t.Errorf("parseFileInfoHeaders(%d) = %v, %d; want %v", ms, fi.UploadTimestamp, fi.UploadTimestampMillis, want)
+		}
+	}
+}
+
+func TestStoredBytes(t *testing.T) {
+	for _, tt := range []struct {
+		stored, served string // Content-Encoding
+		decompress     bool
+		want           bool
+	}{
+		{"", "", false, true},
+		{"", "", true, true},
+		{"gzip", "gzip", false, true},
+		{"gzip", "gzip", true, false},
+		{"", "gzip", false, false}, // compressed on the fly by a proxy
+		{"", "gzip", true, true},
+		{"", "br", false, false},
+		{"gzip", "", false, false}, // decompressed by net/http
+	} {
+		h := http.Header{}
+		h.Set("Content-Encoding", tt.served)
+		if tt.stored != "" {
+			h.Set("X-Bz-Info-b2-content-encoding", tt.stored)
+		}
+		o := &DownloadOptions{Decompress: tt.decompress}
+		if got := o.storedBytes(h); got != tt.want {
+			t.Errorf("stored %q, served %q, decompress %v: got %v", tt.stored, tt.served, tt.decompress, got)
+		}
+	}
+	if (&DownloadOptions{Range: Range{Begin: 1, End: 2}}).storedBytes(http.Header{}) {
+		t.Error("a Range is not the whole file")
+	}
+}
+
+// encodingTransport serves a download with the given Content-Encoding,
+// recording the URL and Accept-Encoding of the request.
+type encodingTransport struct {
+	served         string
+	url            string
+	acceptEncoding string
+}
+
+func (t *encodingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.url = req.URL.String()
+	t.acceptEncoding = req.Header.Get("Accept-Encoding")
+	h := http.Header{}
+	h.Set("X-Bz-Upload-Timestamp", "0")
+	h.Set("Content-Length", "4")
+	h.Set("Content-Encoding", t.served)
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     h,
+		Body:       io.NopCloser(strings.NewReader("data")),
+		Request:    req,
+	}, nil
+}
+
+func TestRawBody(t *testing.T) {
+	ctx := context.Background()
+	tr := &encodingTransport{}
+	c := &Client{hc: &http.Client{Transport: tr}}
+	c.loginInfo.Store(&LoginInfo{DownloadURL: "https://download.example.com"})
+
+	rc, _, err := c.DownloadFile(ctx, DownloadOptions{FileID: "id", RawBody: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc.Close()
+	if tr.acceptEncoding != "identity" {
+		t.Errorf("Accept-Encoding %q, want identity", tr.acceptEncoding)
+	}
+
+	tr.served = "gzip"
+	if _, _, err := c.DownloadFile(ctx, DownloadOptions{FileID: "id", RawBody: true}); err == nil {
+		t.Error("body compressed on the fly accepted")
+	}
+	rc, _, err = c.DownloadFile(ctx, DownloadOptions{FileID: "id"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc.Close()
+	if tr.acceptEncoding != "" {
+		t.Errorf("default Accept-Encoding %q, want it left to net/http", tr.acceptEncoding)
+	}
+	rc, _, err = c.DownloadFile(ctx, DownloadOptions{FileID: "id", Decompress: true})
+	if err == nil {
+		rc.Close()
+	}
+	if tr.acceptEncoding != "gzip" {
+		t.Errorf("Decompress Accept-Encoding %q, want gzip", tr.acceptEncoding)
+	}
+
+	for _, o := range []DownloadOptions{
+		{FileID: "id", RawBody: true, Decompress: true},
+		{FileID: "id", RawBody: true, AcceptEncoding: "gzip"},
+	} {
+		if _, _, err := c.DownloadFile(ctx, o); err == nil {
+			t.Errorf("%+v: conflicting options accepted", o)
+		}
+	}
+}
+
+func TestDownloadFileInfo(t *testing.T) {
+	ctx := context.Background()
+	tr := &encodingTransport{}
+	c := &Client{hc: &http.Client{Transport: tr}}
+	c.loginInfo.Store(&LoginInfo{DownloadURL: "https://download.example.com"})
+
+	listed := &FileInfo{ID: "listed", Name: "name", Action: FileUpload}
+	for _, tt := range []struct {
+		o    DownloadOptions
+		want string
+	}{
+		{DownloadOptions{FileInfo: listed}, "/b2api/v2/b2_download_file_by_id?fileId=listed"},
+		{DownloadOptions{FileInfo: listed, Bucket: "bucket", FileName: "name"}, "/b2api/v2/b2_download_file_by_id?fileId=listed"},
+		{DownloadOptions{FileID: "id", FileInfo: listed}, "/b2api/v2/b2_download_file_by_id?fileId=id"},
+		{DownloadOptions{Bucket: "bucket", FileName: "name"}, "/file/bucket/name"},
+	} {
+		rc, _, err := c.DownloadFile(ctx, tt.o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rc.Close()
+		if want := "https://download.example.com" + tt.want; tr.url != want {
+			t.Errorf("%+v: downloaded %s, want %s", tt.o, tr.url, want)
+		}
+	}
+	if _, _, err := c.DownloadFile(ctx, DownloadOptions{FileInfo: &FileInfo{ID: "id", Action: FileHide}}); err == nil {
+		t.Error("downloaded a hide marker")
+	}
+}
+
+// apiTransport answers API calls with the body set for their name, recording
+// the parameters of each call.
+type apiTransport struct {
+	bodies map[string]string
+	params map[string]map[string]interface{}
+}
+
+func (t *apiTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	name := req.URL.Path[strings.LastIndexByte(req.URL.Path, '/')+1:]
+	var params map[string]interface{}
+	if req.Body != nil {
+		if err := json.NewDecoder(req.Body).Decode(&params); err != nil {
+			return nil, err
+		}
+	}
+	t.params[name] = params
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+		Body:       io.NopCloser(strings.NewReader(t.bodies[name])),
+		Request:    req,
+	}, nil
+}
+
+func TestCopyFileLock(t *testing.T) {
+	ctx := context.Background()
+	tr := &apiTransport{
+		bodies: map[string]string{
+			"b2_list_buckets": `{"buckets": [{"bucketId": "locked", "bucketName": "locked",
+				"fileLockConfiguration": {"value": {"isFileLockEnabled": true}}}]}`,
+			"b2_copy_file": `{"fileId": "copy", "fileName": "name", "action": "copy"}`,
+		},
+		params: make(map[string]map[string]interface{}),
+	}
+	c := &Client{hc: &http.Client{Transport: tr}}
+	c.loginInfo.Store(&LoginInfo{ApiURL: "https://api.example.com"})
+
+	until := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	_, err := c.CopyFile(ctx, "source", "name", CopyOptions{
+		DestinationBucketID: "locked",
+		FileRetention:       &FileRetention{Mode: "compliance", RetainUntil: until},
+		LegalHold:           LegalHoldOn,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := tr.params["b2_copy_file"]
+	r, _ := p["fileRetention"].(map[string]interface{})
+	if r["mode"] != "compliance" || r["retainUntilTimestamp"] != float64(until.UnixMilli()) || p["legalHold"] != "on" {
+		t.Errorf("bad b2_copy_file parameters %v", p)
+	}
+
+	tr.bodies["b2_list_buckets"] = `{"buckets": [{"bucketId": "open", "bucketName": "open"}]}`
+	delete(tr.params, "b2_copy_file")
+	_, err = c.CopyFile(ctx, "source", "name", CopyOptions{DestinationBucketID: "open", LegalHold: LegalHoldOn})
+	if !errors.Is(err, ErrFileLockDisabled) {
+		t.Errorf("got %v, want ErrFileLockDisabled", err)
+	}
+	if tr.params["b2_copy_file"] != nil {
+		t.Error("copied to a bucket without file lock")
+	}
+
+	for _, o := range []CopyOptions{
+		{FileRetention: &FileRetention{Mode: "legal", RetainUntil: until}},
+		{FileRetention: &FileRetention{Mode: "governance", RetainUntil: time.Now().Add(-time.Hour)}},
+		{LegalHold: "yes"},
+	} {
+		if _, err := c.CopyFile(ctx, "source", "name", o); err == nil {
+			t.Errorf("%+v: invalid options accepted", o)
+		}
+	}
+}
+
+func TestDownloadToFileOpen(t *testing.T) {
+	ctx := context.Background()
+	tr := &rangeTransport{id: "id", data: []byte("contents")}
+	c := &Client{hc: &http.Client{Transport: tr}}
+	c.loginInfo.Store(&LoginInfo{DownloadURL: "https://download.example.com"})
+	path := filepath.Join(t.TempDir(), "file")
+
+	f, fi, err := c.DownloadToFileOpen(ctx, DownloadOptions{FileID: "id"}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	open, err := f.(*os.File).Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renamed, err := os.Stat(path); err != nil || !os.SameFile(open, renamed) {
+		t.Errorf("returned file is not the one at path: %v", err)
+	}
+	if got, err := io.ReadAll(f); err != nil || string(got) != "contents" || fi.ID != "id" {
+		t.Errorf("read %q, %+v: %v", got, fi, err)
+	}
+	if _, err := f.Seek(3, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := io.ReadAll(f); string(got) != "tents" {
+		t.Errorf("read %q after Seek", got)
+	}
+
+	// A corrupt download leaves path untouched.
+	c.hc.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		res, err := tr.RoundTrip(req)
+		if err == nil {
+			res.Header.Set("X-Bz-Content-Sha1", strings.Repeat("0", 40))
+		}
+		return res, err
+	})
+	if _, err := c.DownloadToFile(ctx, DownloadOptions{FileID: "id"}, path); err != ErrSHA1Mismatch {
+		t.Errorf("got %v, want ErrSHA1Mismatch", err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != "contents" {
+		t.Errorf("path holds %q", got)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("temporary file left behind: %v", entries)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestDownloadVersionTo(t *testing.T) {
+	ctx := context.Background()
+	tr := &rangeTransport{id: "id", data: []byte("old version")}
+	c := &Client{}
+	c.hc = &http.Client{Transport: &transport{t: tr, c: c}}
+	c.loginInfo.Store(&LoginInfo{DownloadURL: "https://download.example.com"})
+
+	var buf bytes.Buffer
+	fi, err := c.DownloadVersionTo(ctx, "id", &buf)
+	if err != nil || fi.ID != "id" || buf.String() != "old version" {
+		t.Errorf("got %q, %+v: %v", buf.String(), fi, err)
+	}
+
+	c.hc.Transport = &transport{c: c, t: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusNotFound,
+			Header:     http.Header{},
+			Body:       io.NopCloser(strings.NewReader(`{"code": "not_found", "message": "gone", "status": 404}`)),
+			Request:    req,
+		}, nil
+	})}
+	if _, err := c.DownloadVersionTo(ctx, "deleted", io.Discard); err != ErrFileNotFound {
+		t.Errorf("got %v, want ErrFileNotFound", err)
+	}
+}
+
+func TestSignedURL(t *testing.T) {
+	ctx := context.Background()
+	tr := &apiTransport{
+		bodies: map[string]string{
+			"b2_list_buckets": `{"buckets": [{"bucketId": "id", "bucketName": "private"}]}`,
+			"b2_get_download_authorization": `{"bucketId": "id", "fileNamePrefix": "dir/a b.txt",
+				"authorizationToken": "tok/en+"}`,
+		},
+		params: make(map[string]map[string]interface{}),
+	}
+	c := &Client{hc: &http.Client{Transport: tr}}
+	c.loginInfo.Store(&LoginInfo{ApiURL: "https://api.example.com", DownloadURL: "https://f000.example.com"})
+	b := c.BucketByID("id")
+
+	u, err := b.SignedURL(ctx, "dir/a b.txt", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "https://f000.example.com/file/private/dir/a%20b.txt?Authorization=tok%2Fen%2B"; u != want {
+		t.Errorf("got %s, want %s", u, want)
+	}
+	p := tr.params["b2_get_download_authorization"]
+	if p["fileNamePrefix"] != "dir/a b.txt" || p["validDurationInSeconds"] != float64(3600) {
+		t.Errorf("bad parameters %v", p)
+	}
+
+	for ttl, want := range map[time.Duration]float64{
+		time.Millisecond:    1,
+		30 * 24 * time.Hour: 7 * 24 * 3600,
+	} {
+		a, err := b.GetDownloadAuthorization(ctx, "", ttl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := tr.params["b2_get_download_authorization"]["validDurationInSeconds"]; got != want {
+			t.Errorf("ttl %v: asked for %v seconds, want %v", ttl, got, want)
+		}
+		if r := a.Remaining(); r <= 0 || r > time.Duration(want)*time.Second {
+			t.Errorf("ttl %v: remaining %v", ttl, r)
+		}
+	}
+}
+
+func TestEncryptionInfo(t *testing.T) {
+	var fi fileInfoObj
+	err := json.Unmarshal([]byte(`{"serverSideEncryption": {"mode": "SSE-B2", "algorithm": "AES256"}}`), &fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := fi.makeFileInfo().Encryption; e != (EncryptionInfo{Mode: "SSE-B2", Algorithm: "AES256"}) {
+		t.Errorf("SSE-B2 listing: %+v", e)
+	}
+	fi = fileInfoObj{}
+	if err := json.Unmarshal([]byte(`{"serverSideEncryption": {"mode": null}}`), &fi); err != nil {
+		t.Fatal(err)
+	}
+	if e := fi.makeFileInfo().Encryption; e != (EncryptionInfo{}) {
+		t.Errorf("unencrypted listing: %+v", e)
+	}
+
+	h := http.Header{}
+	h.Set("X-Bz-Upload-Timestamp", "0")
+	h.Set("Content-Length", "0")
+	h.Set("X-Bz-Server-Side-Encryption-Customer-Algorithm", "AES256")
+	h.Set("X-Bz-Server-Side-Encryption-Customer-Key-Md5", "md5")
+	dfi, err := parseFileInfoHeaders(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := dfi.Encryption; e != (EncryptionInfo{Mode: "SSE-C", Algorithm: "AES256", CustomerKeyMD5: "md5"}) {
+		t.Errorf("SSE-C download: %+v", e)
+	}
+}
+
+// listTransport answers b2_list_file_names from the sorted names, counting
+// the calls.
+type listTransport struct {
+	names []string
+	calls int
+}
+
+func (t *listTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.calls++
+	var params struct {
+		StartFileName string `json:"startFileName"`
+		Prefix        string `json:"prefix"`
+		MaxFileCount  int    `json:"maxFileCount"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&params); err != nil {
+		return nil, err
+	}
+	var files []string
+	next := "null"
+	for _, name := range t.names[sort.SearchStrings(t.names, params.StartFileName):] {
+		if !strings.HasPrefix(name, params.Prefix) {
+			continue
+		}
+		if len(files) == params.MaxFileCount {
+			next = strconv.Quote(name)
+			break
+		}
+		files = append(files, fmt.Sprintf(`{"fileName": %q, "fileId": "id-%s", "action": "upload"}`, name, name))
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+		Body:       io.NopCloser(strings.NewReader(`{"files": [` + strings.Join(files, ",") + `], "nextFileName": ` + next + `}`)),
+		Request:    req,
+	}, nil
+}
+
+func TestInfoForNames(t *testing.T) {
+	ctx := context.Background()
+	var names []string
+	for i := 0; i < 2500; i++ {
+		names = append(names, fmt.Sprintf("dir/%04d", i))
+	}
+	names = append(names, "other")
+	tr := &listTransport{names: names}
+	c := &Client{hc: &http.Client{Transport: tr}}
+	c.loginInfo.Store(&LoginInfo{ApiURL: "https://api.example.com"})
+	b := c.BucketByID("bucket")
+
+	for _, tt := range []struct {
+		names []string
+		found []string
+		calls int
+	}{
+		{nil, nil, 0},
+		{[]string{"dir/0003", "dir/0001", "dir/0002x", "dir/0001"}, []string{"dir/0001", "dir/0003"}, 1},
+		{[]string{"dir/0000", "dir/1998", "dir/1500", "dir/0500"}, []string{"dir/0000", "dir/0500", "dir/1500", "dir/1998"}, 2},
+		// Truncated after two pages, the last two are looked up one by one.
+		{[]string{"dir/0000", "dir/2400", "dir/2499"}, []string{"dir/0000", "dir/2400", "dir/2499"}, 4},
+		{[]string{"dir/0000", "other", "missing"}, []string{"dir/0000", "other"}, 3},
+	} {
+		tr.calls = 0
+		found, err := b.InfoForNames(ctx, tt.names)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.names, err)
+		}
+		var got []string
+		for name, fi := range found {
+			if fi.Name != name {
+				t.Errorf("%q: %q has FileInfo of %q", tt.names, name, fi.Name)
+			}
+			got = append(got, name)
+		}
+		sort.Strings(got)
+		if strings.Join(got, " ") != strings.Join(tt.found, " ") || tr.calls != tt.calls {
+			t.Errorf("%q: found %q with %d calls, want %q with %d", tt.names, got, tr.calls, tt.found, tt.calls)
+		}
+	}
+}
+
+func TestCommonPrefix(t *testing.T) {
+	for _, tt := range []struct{ a, b, want string }{
+		{"dir/a", "dir/b", "dir/"},
+		{"dir", "dir/b", "dir"},
+		{"a", "b", ""},
+		{"dir/é", "dir/è", "dir/"},
+	} {
+		if got := commonPrefix(tt.a, tt.b); got != tt.want {
+			t.Errorf("commonPrefix(%q, %q) = %q, want %q", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestMovePrefixDeletesCopiedVersion(t *testing.T) {
+	ctx := context.Background()
+	tr := &apiTransport{
+		bodies: map[string]string{
+			"b2_list_file_names":     `{"files": [{"fileId": "v2", "fileName": "old/a", "action": "upload"}], "nextFileName": null}`,
+			"b2_copy_file":           `{"fileId": "copy", "fileName": "new/a", "action": "copy"}`,
+			"b2_delete_file_version": `{"fileId": "v2", "fileName": "old/a"}`,
+		},
+		params: make(map[string]map[string]interface{}),
+	}
+	c := &Client{hc: &http.Client{Transport: tr}}
+	c.loginInfo.Store(&LoginInfo{ApiURL: "https://api.example.com"})
+	moved, err := c.BucketByID("bucket").MovePrefix(ctx, "old/", "new/", 1)
+	if err != nil || moved != 1 {
+		t.Fatalf("moved %d: %v", moved, err)
+	}
+	if got := tr.params["b2_copy_file"]["fileName"]; got != "new/a" {
+		t.Errorf("copied to %v, want new/a", got)
+	}
+	if got := tr.params["b2_delete_file_version"]; got["fileId"] != "v2" || got["fileName"] != "old/a" {
+		t.Errorf("deleted %v, want only the copied version v2", got)
+	}
+	if _, ok := tr.params["b2_list_file_versions"]; ok {
+		t.Error("listed the other versions of the moved file")
+	}
+}
+
+func TestCopyFileLockError(t *testing.T) {
+	ctx := context.Background()
+	c := &Client{}
+	c.hc = &http.Client{Transport: &transport{c: c, t: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		res := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Request: req}
+		body := `{"buckets": [{"bucketId": "locked", "bucketName": "locked",
+			"fileLockConfiguration": {"value": {"isFileLockEnabled": true}}}]}`
+		if strings.HasSuffix(req.URL.Path, "/b2_copy_file") {
+			res.StatusCode = http.StatusBadRequest
+			body = `{"code": "bad_request", "message": "source file is too large", "status": 400}`
+		}
+		res.Body = io.NopCloser(strings.NewReader(body))
+		return res, nil
+	})}}
+	c.loginInfo.Store(&LoginInfo{ApiURL: "https://api.example.com"})
+
+	_, err := c.CopyFile(ctx, "source", "name", CopyOptions{DestinationBucketID: "locked", LegalHold: LegalHoldOn})
+	if !strings.Contains(fmt.Sprint(err), "legal hold") {
+		t.Errorf("got %v, want it to mention the legal hold", err)
+	}
+	if e, ok := UnwrapError(err); !ok || e.Status != http.StatusBadRequest || e.Code != "bad_request" {
+		t.Errorf("UnwrapError(%v) = %v, %v", err, e, ok)
+	}
+}
+
+func TestParseRangeError(t *testing.T) {
+	ctx := context.Background()
+	c := &Client{}
+	c.hc = &http.Client{Transport: &transport{c: c, t: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if got := req.Header.Get("Range"); got != "bytes=2000-3000" {
+			t.Errorf("got Range %q", got)
+		}
+		res := &http.Response{
+			StatusCode: http.StatusRequestedRangeNotSatisfiable,
+			Header:     http.Header{"Content-Range": {"bytes */1234"}},
+			Body:       io.NopCloser(strings.NewReader(`{"code": "range_not_satisfiable", "status": 416}`)),
+			Request:    req,
+		}
+		return res, nil
+	})}}
+	c.loginInfo.Store(&LoginInfo{DownloadURL: "https://download.example.com"})
+
+	_, _, err := c.DownloadFile(ctx, DownloadOptions{FileID: "id", Range: Range{Begin: 2000, End: 3000}})
+	if !errors.Is(err, ErrRangeNotSatisfiable) {
+		t.Fatalf("got %v, want ErrRangeNotSatisfiable", err)
+	}
+	var rangeErr *RangeNotSatisfiableError
+	if !errors.As(err, &rangeErr) || rangeErr.Size != 1234 {
+		t.Errorf("got %v, want a RangeNotSatisfiableError of size 1234", err)
+	}
+
+	for cr, want := range map[string]int64{"bytes */1234": 1234, "bytes */*": -1, "": -1} {
+		res := &http.Response{Header: http.Header{}, Body: io.NopCloser(strings.NewReader(""))}
+		res.Header.Set("Content-Range", cr)
+		if err, ok := parseRangeError(res).(*RangeNotSatisfiableError); !ok || err.Size != want {
+			t.Errorf("Content-Range %q: got %v, want size %d", cr, err, want)
+		}
+	}
+}
+
+// countingPool is a BufferPool handing out buf, counting the calls.
+type countingPool struct {
+	buf        []byte
+	gets, puts int
+}
+
+func (p *countingPool) Get() []byte { p.gets++; return p.buf }
+func (p *countingPool) Put([]byte)  { p.puts++ }
+
+// chunkWriter records the size of the largest write.
+type chunkWriter struct {
+	bytes.Buffer
+	max int
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	if len(p) > w.max {
+		w.max = len(p)
+	}
+	return w.Buffer.Write(p)
+}
+
+func TestDownloadBuffers(t *testing.T) {
+	ctx := context.Background()
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+	c := &Client{hc: &http.Client{Transport: &rangeTransport{id: "id", data: data}}}
+	c.loginInfo.Store(&LoginInfo{DownloadURL: "https://download.example.com"})
+
+	for _, tt := range []struct {
+		buf []byte
+		max int
+	}{
+		{make([]byte, 1000), 1000},
+		{nil, defaultBufferSize},
+		{[]byte{}, defaultBufferSize},
+	} {
+		pool := &countingPool{buf: tt.buf}
+		w := &chunkWriter{}
+		if _, err := c.DownloadTo(ctx, DownloadOptions{FileID: "id", Buffers: pool}, w); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(w.Bytes(), data) {
+			t.Errorf("buffer of %d bytes: wrong contents", len(tt.buf))
+		}
+		if pool.gets != 1 || pool.puts != 1 {
+			t.Errorf("buffer of %d bytes: %d Get and %d Put, want 1 each", len(tt.buf), pool.gets, pool.puts)
+		}
+		if w.max != tt.max {
+			t.Errorf("buffer of %d bytes: writes of up to %d bytes, want %d", len(tt.buf), w.max, tt.max)
+		}
+	}
+}
+
+// hangingBody returns data, then blocks until ctx is done.
+type hangingBody struct {
+	ctx  context.Context
+	data io.Reader
+}
+
+func (b *hangingBody) Read(p []byte) (int, error) {
+	if n, err := b.data.Read(p); err != io.EOF {
+		return n, err
+	}
+	<-b.ctx.Done()
+	return 0, b.ctx.Err()
+}
+
+func TestDownloadCloseCancels(t *testing.T) {
+	var reqCtx context.Context
+	c := &Client{hc: &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		reqCtx = req.Context()
+		h := http.Header{}
+		h.Set("X-Bz-Upload-Timestamp", "0")
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     h,
+			Body:       io.NopCloser(&hangingBody{ctx: req.Context(), data: strings.NewReader("head")}),
+			Request:    req,
+		}, nil
+	})}}
+	c.loginInfo.Store(&LoginInfo{DownloadURL: "https://download.example.com"})
+
+	rc, _, err := c.DownloadFile(context.Background(), DownloadOptions{FileID: "id"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(rc, buf); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() {
+		_, err := rc.Read(buf)
+		done <- err
+	}()
+	if err := rc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("read after Close: got %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not abort the download")
+	}
+	if reqCtx.Err() == nil {
+		t.Error("request context not canceled by Close")
+	}
+}
+
+func TestDownloadURLOverride(t *testing.T) {
+	ctx := context.Background()
+	var urls []string
+	c := &Client{
+		DownloadURLOverride: "https://proxy.example.com",
+		hc: &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			urls = append(urls, req.URL.String())
+			h := http.Header{}
+			h.Set("X-Bz-Upload-Timestamp", "0")
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     h,
+				Body:       io.NopCloser(strings.NewReader(`{"fileId": "id", "fileName": "name", "action": "upload"}`)),
+				Request:    req,
+			}, nil
+		})},
+	}
+	c.loginInfo.Store(&LoginInfo{ApiURL: "https://api.example.com", DownloadURL: "https://download.example.com"})
+
+	if _, err := c.GetFileInfoByID(ctx, "id"); err != nil {
+		t.Fatal(err)
+	}
+	rc, _, err := c.DownloadFileByID(ctx, "id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc.Close()
+	rc, _, err = c.DownloadFileByName(ctx, "bucket", "name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc.Close()
+
+	want := []string{
+		"https://api.example.com/b2api/v2/b2_get_file_info",
+		"https://proxy.example.com/b2api/v2/b2_download_file_by_id?fileId=id",
+		"https://proxy.example.com/file/bucket/name",
+	}
+	if !reflect.DeepEqual(urls, want) {
+		t.Errorf("got requests %q, want %q", urls, want)
+	}
+}
+
+func TestListGroupedVersionsPaging(t *testing.T) {
+	// The versions of "b" are split across the two pages.
+	pages := map[string]string{
+		"": `{"files": [
+			{"fileName": "a", "fileId": "a1", "action": "upload"},
+			{"fileName": "b", "fileId": "b3", "action": "upload"},
+			{"fileName": "b", "fileId": "b2", "action": "hide"}
+		], "nextFileName": "b", "nextFileId": "b1"}`,
+		"b1": `{"files": [
+			{"fileName": "b", "fileId": "b1", "action": "upload"},
+			{"fileName": "c", "fileId": "c1", "action": "upload"}
+		], "nextFileName": null, "nextFileId": null}`,
+	}
+	var calls int
+	tr := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		calls++
+		var params struct {
+			StartFileID string `json:"startFileId"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&params); err != nil {
+			return nil, err
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Body:       io.NopCloser(strings.NewReader(pages[params.StartFileID])),
+			Request:    req,
+		}, nil
+	})
+	c := &Client{hc: &http.Client{Transport: tr}}
+	c.loginInfo.Store(&LoginInfo{ApiURL: "https://api.example.com"})
+	b := &Bucket{c: c, ID: "bucket"}
+
+	got := make(map[string][]string)
+	var names []string
+	err := b.ListGroupedVersions(context.Background(), ListOptions{}, func(name string, versions []*FileInfo) error {
+		names = append(names, name)
+		for _, fi := range versions {
+			got[name] = append(got[name], fi.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Errorf("got %d list calls, want 2", calls)
+	}
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got groups %q, want %q", names, want)
+	}
+	want := map[string][]string{"a": {"a1"}, "b": {"b3", "b2", "b1"}, "c": {"c1"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got versions %v, want %v", got, want)
+	}
+}
@@ -2,13 +2,20 @@ package b2_test
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"crypto/rand"
 	"crypto/sha1"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	"os"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -103,6 +110,13 @@ func TestFileLifecycle(t *testing.T) {
 	if fi.ID != fiu.ID {
 		t.Error("Mismatched file ID in GetByName")
 	}
+	if _, err := b.WaitForFile(ctx, "test-foo", time.Minute); err != nil {
+		t.Error("WaitForFile:", err)
+	}
+	_, err = b.WaitForFile(ctx, "not-exists", 300*time.Millisecond)
+	if err != b2.ErrFileNotFound {
+		t.Errorf("b.WaitForFile did not return FileNotFoundError: %v", err)
+	}
 	_, err = b.GetFileInfoByName(ctx, "not-exists")
 	if err != b2.ErrFileNotFound {
 		t.Errorf("b.GetFileInfoByName did not return FileNotFoundError: %v", err)
@@ -209,6 +223,11 @@ func TestFileListing(t *testing.T) {
 		t.Errorf("got %d files, expected %d", i-1, len(fileIDs)-1)
 	}
 
+	l.Reset()
+	if !l.Next() || l.FileInfo().ID != fileIDs[1] {
+		t.Error("Reset did not restart from FromName")
+	}
+
 	i, l = 0, b.ListFileVersions(ctx, b2.ListOptions{})
 	l.SetPageCount(2)
 	for l.Next() {
@@ -220,4 +239,1131 @@ func TestFileListing(t *testing.T) {
 	if i != len(fileIDs)+2 {
 		t.Errorf("got %d files, expected %d", i-1, len(fileIDs)-1+2)
 	}
+
+	for _, tt := range []struct {
+		since time.Time
+		want  int
+	}{
+		{time.Now().Add(-time.Hour), len(fileIDs)},
+		{time.Now().Add(time.Hour), 0},
+	} {
+		i, l = 0, b.ListFiles(ctx, b2.ListOptions{Since: tt.since})
+		for l.Next() {
+			i++
+		}
+		if err := l.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if i != tt.want {
+			t.Errorf("Since %v: got %d files, expected %d", tt.since, i, tt.want)
+		}
+	}
+
+	for _, tt := range []struct {
+		maxPages, want int
+		err            error
+	}{
+		{1, 2, b2.ErrListingTruncated},
+		{3, len(fileIDs), nil},
+	} {
+		i, l = 0, b.ListFiles(ctx, b2.ListOptions{MaxPages: tt.maxPages})
+		l.SetPageCount(2)
+		for l.Next() {
+			i++
+		}
+		if err := l.Err(); err != tt.err {
+			t.Errorf("MaxPages %d: expected error %v, got %v", tt.maxPages, tt.err, err)
+		}
+		if i != tt.want {
+			t.Errorf("MaxPages %d: got %d files, expected %d", tt.maxPages, i, tt.want)
+		}
+	}
+
+	groups := make(map[string]int)
+	err := b.ListGroupedVersions(ctx, b2.ListOptions{}, func(name string, versions []*b2.FileInfo) error {
+		if _, ok := groups[name]; ok {
+			t.Errorf("name %s grouped twice", name)
+		}
+		groups[name] = len(versions)
+		for i := 1; i < len(versions); i++ {
+			if versions[i].UploadTimestamp.After(versions[i-1].UploadTimestamp) {
+				t.Errorf("versions of %s are not newest first", name)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != len(fileIDs) || groups["test-3"] != 3 {
+		t.Errorf("wrong grouping: %v", groups)
+	}
+}
+
+func TestOpenLines(t *testing.T) {
+	ctx := context.Background()
+	c := getClient(t, ctx)
+	b := getBucket(t, ctx, c)
+	defer deleteBucket(t, b)
+
+	long := strings.Repeat("x", 100*1024)
+	content := "first\n" + long + "\nlast"
+	fi, err := b.Upload(ctx, strings.NewReader(content), "test-lines", "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteFile(ctx, fi.ID, fi.Name)
+
+	l, _, err := c.OpenLines(ctx, b2.DownloadOptions{FileID: fi.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for l.Scan() {
+		lines = append(lines, l.Text())
+	}
+	if err := l.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(lines, []string{"first", long, "last"}) {
+		t.Errorf("got %d lines, expected 3", len(lines))
+	}
+}
+
+func TestSidecarMetadata(t *testing.T) {
+	ctx := context.Background()
+	c := getClient(t, ctx)
+	b := getBucket(t, ctx, c)
+	defer deleteBucket(t, b)
+	defer deleteAll(t, c, b)
+
+	metadata := map[string]string{"src_last_modified_millis": "1000"}
+	for i := 0; i < 15; i++ {
+		metadata[fmt.Sprintf("key%02d", i)] = fmt.Sprint(i)
+	}
+	fi, err := b.UploadWithSidecar(ctx, strings.NewReader("data"), "test-sidecar", "", metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteFile(ctx, fi.ID, fi.Name)
+	if !reflect.DeepEqual(fi.CustomMetadata, metadata) {
+		t.Error("mismatched upload metadata:", fi.CustomMetadata)
+	}
+	sfi, err := b.GetFileInfoByName(ctx, "test-sidecar"+b2.SidecarSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteFile(ctx, sfi.ID, sfi.Name)
+
+	rc, fi2, err := c.DownloadFile(ctx, b2.DownloadOptions{
+		FileID:          fi.ID,
+		Bucket:          b.Name,
+		SidecarMetadata: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc.Close()
+	if len(fi2.CustomMetadata) != len(metadata) {
+		t.Error("mismatched download metadata:", fi2.CustomMetadata)
+	}
+	if fi2.CustomMetadata["key14"] != "14" {
+		t.Error("sidecar metadata not merged:", fi2.CustomMetadata)
+	}
+	stored, err := c.GetFileInfoByID(ctx, fi.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.CustomMetadata["src_last_modified_millis"] != "1000" {
+		t.Error("reserved key moved to the sidecar:", stored.CustomMetadata)
+	}
+
+	// A new version without a sidecar doesn't get the stale one merged.
+	fi3, err := b.UploadWithSidecar(ctx, strings.NewReader("data"), "test-sidecar", "", map[string]string{"k": "v"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc, fi4, err := c.DownloadFile(ctx, b2.DownloadOptions{
+		FileID:          fi3.ID,
+		Bucket:          b.Name,
+		SidecarMetadata: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc.Close()
+	if !reflect.DeepEqual(fi4.CustomMetadata, map[string]string{"k": "v"}) {
+		t.Error("stale sidecar metadata merged:", fi4.CustomMetadata)
+	}
+}
+
+func TestBucketIsEmpty(t *testing.T) {
+	ctx := context.Background()
+	c := getClient(t, ctx)
+	b := getBucket(t, ctx, c)
+	defer deleteBucket(t, b)
+
+	if empty, err := b.IsEmpty(ctx); err != nil || !empty {
+		t.Fatal("new bucket is not empty:", empty, err)
+	}
+	fi, err := b.Upload(ctx, strings.NewReader("data"), "test-empty", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if empty, err := b.IsEmpty(ctx); err != nil || empty {
+		t.Error("bucket with a file is empty:", empty, err)
+	}
+	if err := c.DeleteFile(ctx, fi.ID, fi.Name); err != nil {
+		t.Fatal(err)
+	}
+	if empty, err := b.IsEmpty(ctx); err != nil || !empty {
+		t.Error("bucket is not empty after delete:", empty, err)
+	}
+}
+
+func TestDownloadRangeNotSatisfiable(t *testing.T) {
+	ctx := context.Background()
+	c := getClient(t, ctx)
+	b := getBucket(t, ctx, c)
+	defer deleteBucket(t, b)
+
+	fi, err := b.Upload(ctx, strings.NewReader("0123456789"), "test-range", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteFile(ctx, fi.ID, fi.Name)
+
+	_, _, err = c.DownloadFile(ctx, b2.DownloadOptions{
+		FileID: fi.ID,
+		Range:  b2.Range{Begin: 100, End: 200},
+	})
+	if !errors.Is(err, b2.ErrRangeNotSatisfiable) {
+		t.Fatalf("expected ErrRangeNotSatisfiable, got %v", err)
+	}
+	var rangeErr *b2.RangeNotSatisfiableError
+	if !errors.As(err, &rangeErr) {
+		t.Fatalf("expected a RangeNotSatisfiableError, got %T", err)
+	}
+	if rangeErr.Size != 10 {
+		t.Errorf("expected size 10, got %d", rangeErr.Size)
+	}
+}
+
+func TestCopyFileRange(t *testing.T) {
+	ctx := context.Background()
+	c := getClient(t, ctx)
+	b := getBucket(t, ctx, c)
+	defer deleteBucket(t, b)
+
+	fi, err := b.Upload(ctx, strings.NewReader("0123456789"), "test-copy", "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteFile(ctx, fi.ID, fi.Name)
+
+	cfi, err := c.CopyFile(ctx, fi.ID, "test-copy-preview", b2.CopyOptions{
+		Range:             b2.Range{Begin: 2, End: 5},
+		MetadataDirective: b2.MetadataReplace,
+		ContentType:       "application/x-preview",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteFile(ctx, cfi.ID, cfi.Name)
+	if cfi.ContentType != "application/x-preview" {
+		t.Error("mismatched content type:", cfi.ContentType)
+	}
+	var buf bytes.Buffer
+	if _, err := c.DownloadTo(ctx, b2.DownloadOptions{FileID: cfi.ID}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "2345" {
+		t.Errorf("wrong copy contents %q", buf.String())
+	}
+}
+
+func TestCopyFileMergeMetadata(t *testing.T) {
+	ctx := context.Background()
+	c := getClient(t, ctx)
+	b := getBucket(t, ctx, c)
+	defer deleteBucket(t, b)
+
+	fi, err := b.Upload(ctx, strings.NewReader("data"), "test-merge", "text/plain",
+		map[string]string{"keep": "1", "change": "old"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteFile(ctx, fi.ID, fi.Name)
+
+	cfi, err := c.CopyFileMergeMetadata(ctx, fi.ID, "test-merge-copy",
+		map[string]string{"change": "new", "added": "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteFile(ctx, cfi.ID, cfi.Name)
+	if cfi.ContentType != "text/plain" {
+		t.Error("content type not kept:", cfi.ContentType)
+	}
+	want := map[string]string{"keep": "1", "change": "new", "added": "2"}
+	for k, v := range want {
+		if cfi.CustomMetadata[k] != v {
+			t.Errorf("metadata %q = %q, want %q", k, cfi.CustomMetadata[k], v)
+		}
+	}
+}
+
+func TestCopyFileOptions(t *testing.T) {
+	ctx := context.Background()
+	c := &b2.Client{} // fails before any API call
+
+	for _, o := range []b2.CopyOptions{
+		{MetadataDirective: b2.MetadataReplace},
+		{ContentType: "text/plain"},
+		{MetadataDirective: b2.MetadataCopy, Metadata: map[string]string{"foo": "bar"}},
+		{MetadataDirective: "MERGE"},
+	} {
+		if _, err := c.CopyFile(ctx, "id", "name", o); err == nil {
+			t.Errorf("%+v: expected an error", o)
+		}
+	}
+}
+
+func TestDownloadDecompress(t *testing.T) {
+	ctx := context.Background()
+	c := getClient(t, ctx)
+	b := getBucket(t, ctx, c)
+	defer deleteBucket(t, b)
+
+	const content = `{"hello": "world"}`
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(content))
+	zw.Close()
+	compressed := buf.Bytes()
+
+	fi, err := b.UploadFile(ctx, bytes.NewReader(compressed), b2.UploadOptions{
+		Name:            "test-gzip",
+		ContentType:     "application/json",
+		ContentEncoding: "gzip",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteFile(ctx, fi.ID, fi.Name)
+	plain, err := b.Upload(ctx, strings.NewReader(content), "test-plain", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteFile(ctx, plain.ID, plain.Name)
+
+	for _, tt := range []struct {
+		id             string
+		decompress     bool
+		acceptEncoding string
+		want           string
+	}{
+		{fi.ID, false, "", content}, // decompressed by net/http
+		{fi.ID, false, "gzip", string(compressed)},
+		{fi.ID, true, "", content},
+		{plain.ID, true, "", content},
+	} {
+		var got bytes.Buffer
+		o := b2.DownloadOptions{FileID: tt.id, Decompress: tt.decompress, AcceptEncoding: tt.acceptEncoding}
+		fi2, err := c.DownloadTo(ctx, o, &got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.String() != tt.want {
+			t.Errorf("%s (Decompress %v, AcceptEncoding %q): got %q", tt.id, tt.decompress, tt.acceptEncoding, got.String())
+		}
+		if tt.id == fi.ID && (tt.decompress || tt.acceptEncoding != "") && fi2.ContentLength != int64(len(compressed)) {
+			t.Errorf("ContentLength is %d, expected the compressed length", fi2.ContentLength)
+		}
+	}
+}
+
+func TestPublicURL(t *testing.T) {
+	ctx := context.Background()
+	c := getClient(t, ctx)
+	b := getBucket(t, ctx, c)
+	defer deleteBucket(t, b)
+	if _, err := b.PublicURL("file"); err == nil {
+		t.Error("PublicURL succeeded for a private bucket")
+	}
+
+	r := make([]byte, 6)
+	rand.Read(r)
+	pb, err := c.CreateBucket(ctx, "test-"+hex.EncodeToString(r), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer deleteBucket(t, pb)
+	fi, err := pb.Upload(ctx, strings.NewReader("picture"), "gallery/a b?#.txt", "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteFile(ctx, fi.ID, fi.Name)
+
+	U, err := pb.PublicURL(fi.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.Get(U)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK || string(body) != "picture" {
+		t.Errorf("GET %s: %s %q", U, res.Status, body)
+	}
+}
+
+func TestDownloadMaxBytes(t *testing.T) {
+	ctx := context.Background()
+	c := getClient(t, ctx)
+	b := getBucket(t, ctx, c)
+	defer deleteBucket(t, b)
+
+	content := make([]byte, 123456)
+	rand.Read(content)
+	fi, err := b.Upload(ctx, bytes.NewReader(content), "test-max", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteFile(ctx, fi.ID, fi.Name)
+
+	for _, max := range []int64{1000, int64(len(content)), 1 << 20} {
+		var buf bytes.Buffer
+		if _, err := c.DownloadTo(ctx, b2.DownloadOptions{FileID: fi.ID, MaxBytes: max}, &buf); err != nil {
+			t.Fatal(err)
+		}
+		want := content
+		if max < int64(len(content)) {
+			want = content[:max]
+		}
+		if !bytes.Equal(buf.Bytes(), want) {
+			t.Errorf("MaxBytes %d: got %d bytes, expected %d", max, buf.Len(), len(want))
+		}
+	}
+}
+
+func TestParseHTTPRange(t *testing.T) {
+	for _, tt := range []struct {
+		header string
+		size   int64
+		want   b2.Range
+		err    bool
+	}{
+		{"", 1000, b2.Range{}, false},
+		{"bytes=0-499", 1000, b2.Range{Begin: 0, End: 499}, false},
+		{"bytes=500-", 1000, b2.Range{Begin: 500, End: 999}, false},
+		{"bytes=500-5000", 1000, b2.Range{Begin: 500, End: 999}, false},
+		{"bytes=-100", 1000, b2.Range{Begin: 900, End: 999}, false},
+		{"bytes=-5000", 1000, b2.Range{Begin: 0, End: 999}, false},
+		{"bytes=999-999", 1000, b2.Range{Begin: 999, End: 999}, false},
+		{"bytes=0-0", 1, b2.Range{}, false},
+		{"bytes=0-0", 1000, b2.Range{}, true},
+		{"bytes=1000-", 1000, b2.Range{}, true},
+		{"bytes=-0", 1000, b2.Range{}, true},
+		{"bytes=0-10,20-30", 1000, b2.Range{}, true},
+		{"bytes=10-5", 1000, b2.Range{}, true},
+		{"bytes=a-b", 1000, b2.Range{}, true},
+		{"bytes=5", 1000, b2.Range{}, true},
+		{"items=0-10", 1000, b2.Range{}, true},
+	} {
+		got, err := b2.ParseHTTPRange(tt.header, tt.size)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("ParseHTTPRange(%q, %d) = %+v, %v", tt.header, tt.size, got, err)
+		}
+	}
+	if _, err := b2.ParseHTTPRange("bytes=1000-", 1000); !errors.Is(err, b2.ErrRangeNotSatisfiable) {
+		t.Errorf("expected ErrRangeNotSatisfiable, got %v", err)
+	}
+}
+
+func TestDownloadFileResponse(t *testing.T) {
+	ctx := context.Background()
+	c := getClient(t, ctx)
+	b := getBucket(t, ctx, c)
+	defer deleteBucket(t, b)
+
+	fi, err := b.UploadFile(ctx, strings.NewReader("data"), b2.UploadOptions{
+		Name:         "test-response",
+		CacheControl: "max-age=60",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteFile(ctx, fi.ID, fi.Name)
+
+	res, fi2, err := c.DownloadFileResponse(ctx, b2.DownloadOptions{FileID: fi.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if fi2.ID != fi.ID {
+		t.Error("mismatched FileInfo ID:", fi2.ID)
+	}
+	if v := res.Header.Get("Cache-Control"); v != "max-age=60" {
+		t.Error("mismatched Cache-Control header:", v)
+	}
+	if body, err := io.ReadAll(res.Body); err != nil || string(body) != "data" {
+		t.Errorf("body: %q, %v", body, err)
+	}
+}
+
+func TestBucketTotalSize(t *testing.T) {
+	ctx := context.Background()
+	c := getClient(t, ctx)
+	b := getBucket(t, ctx, c)
+	defer deleteBucket(t, b)
+	defer deleteAll(t, c, b)
+
+	for _, content := range []string{"old", "newer", "other"} {
+		name := "test-size"
+		if content == "other" {
+			name = "test-other"
+		}
+		if _, err := b.Upload(ctx, strings.NewReader(content), name, "", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tt := range []struct {
+		all          bool
+		files, bytes int64
+	}{
+		{false, 2, int64(len("newer") + len("other"))},
+		{true, 3, int64(len("old") + len("newer") + len("other"))},
+	} {
+		files, bytes, err := b.TotalSize(ctx, tt.all)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if files != tt.files || bytes != tt.bytes {
+			t.Errorf("TotalSize(%v) = %d, %d; expected %d, %d", tt.all, files, bytes, tt.files, tt.bytes)
+		}
+	}
+}
+
+func TestDownloadByBucketID(t *testing.T) {
+	ctx := context.Background()
+	c := getClient(t, ctx)
+	b := getBucket(t, ctx, c)
+	defer deleteBucket(t, b)
+
+	fi, err := b.Upload(ctx, strings.NewReader(`{"a": 1}`), "config.json", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteFile(ctx, fi.ID, fi.Name)
+
+	// A fresh Client has no cached bucket names.
+	c2, err := b2.NewClient(ctx, os.Getenv("ACCOUNT_ID"), os.Getenv("APPLICATION_KEY"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		var buf bytes.Buffer
+		_, err := c2.DownloadTo(ctx, b2.DownloadOptions{BucketID: b.ID, FileName: "config.json"}, &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != `{"a": 1}` {
+			t.Errorf("got %q", buf.String())
+		}
+	}
+}
+
+func TestBucketVerify(t *testing.T) {
+	ctx := context.Background()
+	c := getClient(t, ctx)
+	b := getBucket(t, ctx, c)
+	defer deleteBucket(t, b)
+	defer deleteAll(t, c, b)
+
+	for _, name := range []string{"a", "b", "c"} {
+		if _, err := b.Upload(ctx, strings.NewReader("contents of "+name), name, "", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var mu sync.Mutex
+	verified := make(map[string]bool)
+	err := b.Verify(ctx, 2, func(fi *b2.FileInfo, ok bool, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !ok || err != nil {
+			t.Errorf("%s: ok %v, err %v", fi.Name, ok, err)
+		}
+		verified[fi.Name] = true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(verified) != 3 {
+		t.Errorf("verified %v, expected 3 files", verified)
+	}
+}
+
+func TestDownloadInto(t *testing.T) {
+	ctx := context.Background()
+	c := getClient(t, ctx)
+	b := getBucket(t, ctx, c)
+	defer deleteBucket(t, b)
+
+	fi, err := b.Upload(ctx, strings.NewReader("cached value"), "test-into", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteFile(ctx, fi.ID, fi.Name)
+
+	buf := make([]byte, 100)
+	n, _, err := c.DownloadInto(ctx, b2.DownloadOptions{FileID: fi.ID}, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "cached value" {
+		t.Errorf("got %q", buf[:n])
+	}
+
+	n, fi2, err := c.DownloadInto(ctx, b2.DownloadOptions{FileID: fi.ID}, buf[:6])
+	if err != b2.ErrBufferTooSmall {
+		t.Errorf("expected ErrBufferTooSmall, got %v", err)
+	}
+	if string(buf[:n]) != "cached" || fi2 == nil || fi2.ContentLength != fi.ContentLength {
+		t.Errorf("truncated download: %q, %+v", buf[:n], fi2)
+	}
+}
+
+func TestVersionStats(t *testing.T) {
+	ctx := context.Background()
+	c := getClient(t, ctx)
+	b := getBucket(t, ctx, c)
+	defer deleteBucket(t, b)
+	defer deleteAll(t, c, b)
+
+	for _, content := range []string{"first", "second!"} {
+		if _, err := b.Upload(ctx, strings.NewReader(content), "test-versions", "", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := b.Upload(ctx, strings.NewReader("other"), "test-versions-other", "", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := b.VersionStats(ctx, "test-versions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 2 {
+		t.Fatalf("got %d versions, expected 2: %+v", len(stats), stats)
+	}
+	if !stats[0].Current || stats[0].Size != int64(len("second!")) {
+		t.Errorf("wrong newest version: %+v", stats[0])
+	}
+	if stats[1].Current || stats[1].Size != int64(len("first")) {
+		t.Errorf("wrong oldest version: %+v", stats[1])
+	}
+}
+
+func TestGetFileVersionAsOf(t *testing.T) {
+	ctx := context.Background()
+	c := getClient(t, ctx)
+	b := getBucket(t, ctx, c)
+	defer deleteBucket(t, b)
+	defer deleteAll(t, c, b)
+
+	v1, err := b.Upload(ctx, strings.NewReader("first"), "test-asof", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	hide, err := b.HideFile(ctx, "test-asof")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	v2, err := b.Upload(ctx, strings.NewReader("second"), "test-asof", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		t    time.Time
+		want string // version ID, "" for absent
+	}{
+		{v1.UploadTimestamp.Add(-time.Millisecond), ""},
+		{v1.UploadTimestamp, v1.ID},
+		{hide.UploadTimestamp.Add(-time.Millisecond), v1.ID},
+		{hide.UploadTimestamp, ""},
+		{v2.UploadTimestamp, v2.ID},
+		{time.Now().Add(time.Hour), v2.ID},
+	} {
+		fi, err := b.GetFileVersionAsOf(ctx, "test-asof", tt.t)
+		switch {
+		case tt.want == "" && err != b2.ErrFileNotFound:
+			t.Errorf("as of %v: got %v, %v; want ErrFileNotFound", tt.t, fi, err)
+		case tt.want != "" && (err != nil || fi.ID != tt.want):
+			t.Errorf("as of %v: got %v, %v; want %s", tt.t, fi, err, tt.want)
+		}
+	}
+}
+
+func TestMetadataAccessors(t *testing.T) {
+	fi := &b2.FileInfo{CustomMetadata: map[string]string{
+		"count":                    "42",
+		"negative":                 "-7",
+		"text":                     "hello",
+		"src_last_modified_millis": "1700000000123",
+		"rfc3339":                  "2024-05-06T07:08:09.5Z",
+		"b2-expires":               "Mon, 06 May 2024 07:08:09 GMT",
+	}}
+	for key, want := range map[string]int64{"count": 42, "negative": -7} {
+		if n, ok := fi.MetadataInt(key); !ok || n != want {
+			t.Errorf("MetadataInt(%q) = %d, %v; want %d", key, n, ok, want)
+		}
+	}
+	for _, key := range []string{"text", "missing", "rfc3339"} {
+		if _, ok := fi.MetadataInt(key); ok {
+			t.Errorf("MetadataInt(%q) succeeded", key)
+		}
+	}
+
+	for key, want := range map[string]time.Time{
+		"src_last_modified_millis": time.UnixMilli(1700000000123),
+		"rfc3339":                  time.Date(2024, 5, 6, 7, 8, 9, 5e8, time.UTC),
+		"b2-expires":               time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC),
+	} {
+		if got, ok := fi.MetadataTime(key); !ok || !got.Equal(want) {
+			t.Errorf("MetadataTime(%q) = %v, %v; want %v", key, got, ok, want)
+		}
+	}
+	for _, key := range []string{"text", "missing"} {
+		if _, ok := fi.MetadataTime(key); ok {
+			t.Errorf("MetadataTime(%q) succeeded", key)
+		}
+	}
+
+	if got, ok := fi.LastModified(); !ok || got.UnixMilli() != 1700000000123 {
+		t.Errorf("LastModified() = %v, %v", got, ok)
+	}
+	if _, ok := (&b2.FileInfo{}).LastModified(); ok {
+		t.Error("LastModified succeeded without metadata")
+	}
+}
+
+func TestFileStatsUnsupported(t *testing.T) {
+	b := (&b2.Client{}).BucketByID("unused")
+	if _, err := b.FileStats(context.Background(), "foo-file"); err != b2.ErrUnsupported {
+		t.Errorf("expected ErrUnsupported, got %v", err)
+	}
+}
+
+func TestDownloadRanges(t *testing.T) {
+	ctx := context.Background()
+	c := getClient(t, ctx)
+	b := getBucket(t, ctx, c)
+	defer deleteBucket(t, b)
+
+	fi, err := b.Upload(ctx, strings.NewReader("0123456789"), "test-ranges", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteFile(ctx, fi.ID, fi.Name)
+
+	rcs, err := c.DownloadRanges(ctx, b2.DownloadOptions{FileID: fi.ID}, []b2.Range{
+		{Begin: 6, End: 8},
+		{Begin: 20, End: 30}, // past the end
+		{Begin: 1, End: 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"678", "", "12"} {
+		data, err := io.ReadAll(rcs[i])
+		rcs[i].Close()
+		if i == 1 {
+			if !errors.Is(err, b2.ErrRangeNotSatisfiable) {
+				t.Errorf("range %d: expected ErrRangeNotSatisfiable, got %v", i, err)
+			}
+			continue
+		}
+		if err != nil || string(data) != want {
+			t.Errorf("range %d: got %q, %v, want %q", i, data, err, want)
+		}
+	}
+}
+
+func TestDownloadS3(t *testing.T) {
+	ctx := context.Background()
+	c := getClient(t, ctx)
+	b := getBucket(t, ctx, c)
+	defer deleteBucket(t, b)
+
+	fi, err := b.Upload(ctx, strings.NewReader("data"), "dir/test s3+file", "text/plain", map[string]string{"foo": "bar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteFile(ctx, fi.ID, fi.Name)
+
+	rc, sfi, err := c.DownloadS3(ctx, b.Name, fi.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "data" {
+		t.Errorf("wrong contents %q", data)
+	}
+	if sfi.ContentLength != 4 || sfi.ContentType != "text/plain" || sfi.CustomMetadata["foo"] != "bar" {
+		t.Errorf("wrong file info %+v", sfi)
+	}
+}
+
+func TestHideFile(t *testing.T) {
+	ctx := context.Background()
+	c := getClient(t, ctx)
+	b := getBucket(t, ctx, c)
+	defer deleteBucket(t, b)
+	defer deleteAll(t, c, b)
+
+	if _, err := b.Upload(ctx, strings.NewReader("data"), "hidden", "", nil); err != nil {
+		t.Fatal(err)
+	}
+	marker, err := b.HideFile(ctx, "hidden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if marker.Action != b2.FileHide || marker.Name != "hidden" {
+		t.Errorf("bad hide marker %+v", marker)
+	}
+	if _, err := b.GetFileInfoByName(ctx, "hidden"); err != b2.ErrFileNotFound {
+		t.Errorf("hidden file still listed: %v", err)
+	}
+	if _, err := b.HideFile(ctx, "hidden"); err != b2.ErrAlreadyHidden {
+		t.Errorf("got %v, want ErrAlreadyHidden", err)
+	}
+	again, hidden, err := b.HideFileIfVisible(ctx, "hidden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hidden || again.ID != marker.ID {
+		t.Errorf("HideFileIfVisible = %+v, %v; want the existing marker %s", again, hidden, marker.ID)
+	}
+}
+
+func TestSplitKey(t *testing.T) {
+	for _, tt := range []struct {
+		name, delim, dir, base string
+	}{
+		{"a/b/c.txt", "/", "a/b/", "c.txt"},
+		{"a/b/", "/", "a/", "b/"},
+		{"c.txt", "/", "", "c.txt"},
+		{"b/", "/", "", "b/"},
+		{"", "/", "", ""},
+		{"a::b", "::", "a::", "b"},
+		{"a/b", "", "", "a/b"},
+	} {
+		dir, base := b2.SplitKey(tt.name, tt.delim)
+		if dir != tt.dir || base != tt.base {
+			t.Errorf("SplitKey(%q, %q) = %q, %q; want %q, %q", tt.name, tt.delim, dir, base, tt.dir, tt.base)
+		}
+	}
+}
+
+func TestListDir(t *testing.T) {
+	ctx := context.Background()
+	c := getClient(t, ctx)
+	b := getBucket(t, ctx, c)
+	defer deleteBucket(t, b)
+	defer deleteAll(t, c, b)
+
+	for _, name := range []string{"root", "top/a", "top/dir/b", "top/dir/sub/c", "top/z"} {
+		if _, err := b.Upload(ctx, strings.NewReader("data"), name, "", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tt := range []struct {
+		dir     string
+		folders []string
+		files   []string
+	}{
+		{"", []string{"top/"}, []string{"root"}},
+		{"top", []string{"top/dir/"}, []string{"top/a", "top/z"}},
+		{"top/dir/", []string{"top/dir/sub/"}, []string{"top/dir/b"}},
+	} {
+		folders, files, err := b.ListDir(ctx, tt.dir, "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, fi := range files {
+			names = append(names, fi.Name)
+		}
+		if !reflect.DeepEqual(folders, tt.folders) || !reflect.DeepEqual(names, tt.files) {
+			t.Errorf("ListDir(%q) = %q, %q; want %q, %q", tt.dir, folders, names, tt.folders, tt.files)
+		}
+	}
+}
+
+func TestIsPrefix(t *testing.T) {
+	ctx := context.Background()
+	c := getClient(t, ctx)
+	b := getBucket(t, ctx, c)
+	defer deleteBucket(t, b)
+	defer deleteAll(t, c, b)
+
+	for _, name := range []string{"photos/a.jpg", "both", "both/child", "marker/", "marker/x", "empty/", "file"} {
+		if _, err := b.Upload(ctx, strings.NewReader("data"), name, "", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, want := range map[string]bool{
+		"photos":       true,
+		"photos/":      true,
+		"photos/a.jpg": false,
+		"both":         false, // also a file
+		"marker/":      false, // also a file
+		"marker":       true,
+		"empty/":       false,
+		"file":         false,
+		"missing":      false,
+	} {
+		got, err := b.IsPrefix(ctx, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("IsPrefix(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestManifest(t *testing.T) {
+	ctx := context.Background()
+	c := getClient(t, ctx)
+	b := getBucket(t, ctx, c)
+	defer deleteBucket(t, b)
+	defer deleteAll(t, c, b)
+
+	for _, name := range []string{"m/b", "m/a", "other"} {
+		if _, err := b.Upload(ctx, strings.NewReader(name), name, "text/plain", map[string]string{"z": "1", "a": "2"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf1, buf2 bytes.Buffer
+	if err := b.Manifest(ctx, "m/", &buf1); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Manifest(ctx, "m/", &buf2); err != nil {
+		t.Fatal(err)
+	}
+	if buf1.String() != buf2.String() {
+		t.Errorf("manifests differ:\n%s\n%s", buf1.String(), buf2.String())
+	}
+	lines := strings.Split(strings.TrimSuffix(buf1.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2:\n%s", len(lines), buf1.String())
+	}
+	var e b2.ManifestEntry
+	if err := json.Unmarshal([]byte(lines[0]), &e); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha1.Sum([]byte("m/a"))
+	if e.Name != "m/a" || e.Size != 3 || e.SHA1 != hex.EncodeToString(sum[:]) || e.ContentType != "text/plain" || e.Metadata["z"] != "1" {
+		t.Errorf("bad entry %+v", e)
+	}
+	if !strings.HasPrefix(lines[0], `{"name":"m/a","size":3,`) || !strings.Contains(lines[0], `"metadata":{"a":"2","z":"1"}`) {
+		t.Errorf("unstable field order: %s", lines[0])
+	}
+}
+
+func TestListExtraDelimiters(t *testing.T) {
+	ctx := context.Background()
+	c := getClient(t, ctx)
+	b := getBucket(t, ctx, c)
+	defer deleteBucket(t, b)
+	defer deleteAll(t, c, b)
+
+	for _, name := range []string{"top/a", "top/dir/b", "top/ns:x", "top/ns:y/c", "top/ns:z", "top/z"} {
+		if _, err := b.Upload(ctx, strings.NewReader("data"), name, "", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l := b.ListFiles(ctx, b2.ListOptions{
+		Prefix:          "top/",
+		Delimiter:       "/",
+		ExtraDelimiters: []string{":"},
+	})
+	var got []string
+	for l.Next() {
+		got = append(got, l.FileInfo().Name)
+	}
+	if err := l.Err(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"top/a", "top/dir/", "top/ns:", "top/z"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	l = b.ListFiles(ctx, b2.ListOptions{Prefix: "top/"}).Filter(func(fi *b2.FileInfo) bool {
+		return !strings.HasPrefix(fi.Name, "top/ns:")
+	}).Filter(func(fi *b2.FileInfo) bool {
+		return fi.Name != "top/z"
+	})
+	got = nil
+	for l.Next() {
+		got = append(got, l.FileInfo().Name)
+	}
+	if err := l.Err(); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"top/a", "top/dir/b"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("filtered: got %q, want %q", got, want)
+	}
+}
+
+func TestExistingNames(t *testing.T) {
+	ctx := context.Background()
+	c := getClient(t, ctx)
+	b := getBucket(t, ctx, c)
+	defer deleteBucket(t, b)
+	defer deleteAll(t, c, b)
+
+	for _, name := range []string{"test-a", "test-c"} {
+		if _, err := b.Upload(ctx, strings.NewReader("data"), name, "", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	existing, err := b.ExistingNames(ctx, []string{"test-a", "test-b", "test-c", "test-d"}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(existing) != 2 || existing["test-a"] == nil || existing["test-c"] == nil {
+		t.Errorf("wrong existing names: %v", existing)
+	}
+}
+
+func TestMovePrefix(t *testing.T) {
+	ctx := context.Background()
+	c := getClient(t, ctx)
+	b := getBucket(t, ctx, c)
+	defer deleteBucket(t, b)
+	defer deleteAll(t, c, b)
+
+	for _, name := range []string{"old/a", "old/dir/b", "other/c"} {
+		if _, err := b.Upload(ctx, strings.NewReader(name), name, "", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := b.MovePrefix(ctx, "old/", "old/sub/", 2); err == nil {
+		t.Error("moving under the old prefix was accepted")
+	}
+	moved, err := b.MovePrefix(ctx, "old/", "new/", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved != 2 {
+		t.Errorf("moved %d files, expected 2", moved)
+	}
+	var got []string
+	l := b.ListFileVersions(ctx, b2.ListOptions{})
+	for l.Next() {
+		got = append(got, l.FileInfo().Name)
+	}
+	if err := l.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"new/a", "new/dir/b", "other/c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestMigrateBucket(t *testing.T) {
+	ctx := context.Background()
+	c := getClient(t, ctx)
+	src := getBucket(t, ctx, c)
+	defer deleteBucket(t, src)
+	defer deleteAll(t, c, src)
+	dst := getBucket(t, ctx, c)
+	defer deleteBucket(t, dst)
+	defer deleteAll(t, c, dst)
+
+	for _, name := range []string{"keep/a", "move/b", "move/c"} {
+		if _, err := src.Upload(ctx, strings.NewReader(name), name, "text/plain", map[string]string{"k": name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Already copied by an interrupted run.
+	if _, err := dst.Upload(ctx, strings.NewReader("move/b"), "move/b", "text/plain", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	actions := make(map[string]b2.MigrateAction)
+	err := c.MigrateBucket(ctx, src.ID, dst.ID, b2.MigrateOptions{
+		Prefix:       "move/",
+		DeleteSource: true,
+		Progress: func(name string, action b2.MigrateAction, err error) {
+			if err != nil {
+				t.Errorf("%s: %v", name, err)
+			}
+			mu.Lock()
+			actions[name] = action
+			mu.Unlock()
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]b2.MigrateAction{"move/b": b2.MigrateSkip, "move/c": b2.MigrateCopy}
+	if !reflect.DeepEqual(actions, want) {
+		t.Errorf("got actions %v, want %v", actions, want)
+	}
+	fi, err := dst.GetFileInfoByName(ctx, "move/c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.ContentType != "text/plain" || fi.CustomMetadata["k"] != "move/c" {
+		t.Errorf("metadata not preserved: %+v", fi)
+	}
+	for _, name := range []string{"move/b", "move/c"} {
+		if _, err := src.GetFileInfoByName(ctx, name); err != b2.ErrFileNotFound {
+			t.Errorf("%s not deleted from the source: %v", name, err)
+		}
+	}
+	if _, err := src.GetFileInfoByName(ctx, "keep/a"); err != nil {
+		t.Errorf("file outside the prefix: %v", err)
+	}
+}
+
+func TestDownloadLatest(t *testing.T) {
+	ctx := context.Background()
+	c := getClient(t, ctx)
+	b := getBucket(t, ctx, c)
+	defer deleteBucket(t, b)
+	defer deleteAll(t, c, b)
+
+	// Uploaded in reverse name order, so the latest is not the last listed.
+	for _, name := range []string{"builds/app-3", "builds/app-1", "builds/app-2"} {
+		if _, err := b.Upload(ctx, strings.NewReader(name), name, "", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	fi, err := b.DownloadLatest(ctx, "builds/app-", &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Name != "builds/app-2" || buf.String() != "builds/app-2" {
+		t.Errorf("downloaded %s: %q", fi.Name, buf.String())
+	}
+	if _, err := b.DownloadLatest(ctx, "missing/", &buf); err != b2.ErrFileNotFound {
+		t.Errorf("expected ErrFileNotFound, got %v", err)
+	}
 }
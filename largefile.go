@@ -0,0 +1,865 @@
+package b2
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPartSize = 100 * 1000 * 1000
+	maxPartSize     = 5 * 1000 * 1000 * 1000
+	maxParts        = 10000
+)
+
+// LargeUploadOptions control how a file is split into parts.
+type LargeUploadOptions struct {
+	// PartSize is the size of every part but the last one. If 0, 100MB
+	// is used. LoginInfo.RecommendedPartSize is usually the best choice.
+	PartSize int64
+
+	// LargeFileSHA1, if true, makes UploadLarge compute the SHA1 of the
+	// whole file, in the same pass as the SHA1 of each part, and store it
+	// in the large_file_sha1 file info key. B2 does not compute it for
+	// large files, so this is the only way to verify them after download.
+	LargeFileSHA1 bool
+
+	// ChunkSHA1, if set, is called with the hex SHA1 of each consecutive
+	// chunk of the file, in order, as the file is hashed before uploading.
+	// Chunks are ChunkSize bytes long, or 4MB if ChunkSize is 0, but never
+	// span two parts: a chunk is cut short at the end of each part, so
+	// chunks always align with parts when PartSize is a multiple of ChunkSize.
+	ChunkSHA1 func(offset, length int64, sha1Sum string)
+	ChunkSize int64
+}
+
+const defaultChunkSize = 4 * 1000 * 1000
+
+// An UploadPlan describes how a file is split into parts. See PlanUpload.
+type UploadPlan struct {
+	// Multipart is false if the file fits in a single part, in which
+	// case it is uploaded with b2_upload_file instead.
+	Multipart bool
+
+	PartSize     int64 // the size of all the parts but the last
+	Parts        int
+	LastPartSize int64
+}
+
+// PlanUpload returns how a file of the given size would be split into parts.
+//
+// The part size is raised if needed to fit in the 10000 parts a large file can
+// have, and capped at the 5GB maximum part size.
+func PlanUpload(size int64, o LargeUploadOptions) UploadPlan {
+	partSize := o.PartSize
+	if partSize <= 0 {
+		partSize = defaultPartSize
+	}
+	if size > partSize*maxParts {
+		partSize = (size + maxParts - 1) / maxParts
+	}
+	if partSize > maxPartSize {
+		partSize = maxPartSize
+	}
+	if size <= partSize {
+		return UploadPlan{PartSize: partSize, Parts: 1, LastPartSize: size}
+	}
+	p := UploadPlan{
+		Multipart:    true,
+		PartSize:     partSize,
+		Parts:        int((size + partSize - 1) / partSize),
+		LastPartSize: size % partSize,
+	}
+	if p.LastPartSize == 0 {
+		p.LastPartSize = partSize
+	}
+	return p
+}
+
+// UploadLarge uploads r as a large file, split in parts according to lo, or
+// like UploadFile if it fits in a single part.
+//
+// r is read twice: once to compute the SHA1 of each part, and once to upload
+// them. Parts are uploaded one at a time and retried like in Upload. If the
+// upload fails, the large file is left unfinished.
+func (b *Bucket) UploadLarge(ctx context.Context, r io.ReadSeeker, o UploadOptions, lo LargeUploadOptions) (*FileInfo, error) {
+	if err := ValidateFileName(o.Name); err != nil {
+		return nil, err
+	}
+	info, err := b.fileInfo(&o)
+	if err != nil {
+		return nil, err
+	}
+	if err := o.checkExtraHeaders(); err != nil {
+		return nil, err
+	}
+	if o.ContentType, err = b.c.detectContentType(o.Name, o.ContentType, r); err != nil {
+		return nil, err
+	}
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	plan := PlanUpload(size, lo)
+
+	var chunks *chunkHasher
+	if lo.ChunkSHA1 != nil {
+		chunks = &chunkHasher{size: lo.ChunkSize, fn: lo.ChunkSHA1, h: sha1.New()}
+		if chunks.size <= 0 {
+			chunks.size = defaultChunkSize
+		}
+	}
+	partSHA1s := make([]string, plan.Parts)
+	whole, wholeMD5 := sha1.New(), md5.New()
+	for i := range partSHA1s {
+		h := sha1.New()
+		w := []io.Writer{h}
+		if lo.LargeFileSHA1 {
+			w = append(w, whole)
+		}
+		if o.ContentMD5 {
+			w = append(w, wholeMD5)
+		}
+		if chunks != nil {
+			w = append(w, chunks)
+		}
+		if _, err := io.CopyN(io.MultiWriter(w...), r, plan.partLength(i)); err != nil {
+			return nil, err
+		}
+		partSHA1s[i] = hex.EncodeToString(h.Sum(nil))
+		if chunks != nil {
+			chunks.flush()
+		}
+	}
+	if o.ContentMD5 {
+		o.contentMD5 = hex.EncodeToString(wholeMD5.Sum(nil))
+		info["content-md5"] = o.contentMD5
+	}
+	if !plan.Multipart {
+		return b.uploadSeeker(ctx, r, o, partSHA1s[0], size)
+	}
+	wholeSHA1 := hex.EncodeToString(whole.Sum(nil))
+	if lo.LargeFileSHA1 {
+		info["large_file_sha1"] = wholeSHA1
+	}
+
+	lf, err := b.StartLargeFile(ctx, o.Name, o.ContentType, info)
+	if err != nil {
+		return nil, err
+	}
+	for i, sha1Sum := range partSHA1s {
+		offset, length := int64(i)*plan.PartSize, plan.partLength(i)
+		err := b.c.retryUpload(ctx, func(ctx context.Context) error {
+			if _, err := r.Seek(offset, io.SeekStart); err != nil {
+				return err
+			}
+			part := throttle(ctx, io.LimitReader(r, length), o.MaxBytesPerSec)
+			return lf.UploadPart(ctx, i+1, part, sha1Sum, length)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return lf.finishUpload(ctx, &o, partSHA1s)
+}
+
+// finishUpload finishes the large file uploaded with o, first checking, if
+// o.VerifyAfterUpload is set, that its parts have the SHA1s partSHA1s, and
+// canceling it otherwise.
+func (lf *LargeFile) finishUpload(ctx context.Context, o *UploadOptions, partSHA1s []string) (*FileInfo, error) {
+	if o.VerifyAfterUpload {
+		if err := lf.checkParts(partSHA1s); err != nil {
+			if cerr := lf.Cancel(ctx); cerr != nil {
+				return nil, fmt.Errorf("%w, and canceling it failed: %v", err, cerr)
+			}
+			return nil, err
+		}
+	}
+	return lf.Finish(ctx)
+}
+
+// UploadStream uploads r, whose length is not known in advance, like a live
+// export. It reads r one part at a time into a memory buffer growing up to
+// the part size, uploading each part as a large file part as soon as it's
+// full, and finishes the large file once r is exhausted. If r fits in a
+// single part, it's uploaded like UploadFile instead.
+//
+// Only the PartSize option is used, and it's raised if needed to the
+// LoginInfo.AbsoluteMinimumPartSize. Parts are retried like in Upload. If
+// the upload fails, the large file is left unfinished.
+//
+// ContentMD5 is not supported, as the file info of a large file must be set
+// before its contents are known.
+func (b *Bucket) UploadStream(ctx context.Context, r io.Reader, o UploadOptions, lo LargeUploadOptions) (*FileInfo, error) {
+	if o.ContentMD5 {
+		return nil, errors.New("UploadStream does not support UploadOptions.ContentMD5")
+	}
+	if err := ValidateFileName(o.Name); err != nil {
+		return nil, err
+	}
+	info, err := b.fileInfo(&o)
+	if err != nil {
+		return nil, err
+	}
+	if err := o.checkExtraHeaders(); err != nil {
+		return nil, err
+	}
+	li, err := b.c.LoginInfo(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	partSize := lo.PartSize
+	if partSize <= 0 {
+		partSize = defaultPartSize
+	}
+	if partSize < li.AbsoluteMinimumPartSize {
+		partSize = li.AbsoluteMinimumPartSize
+	}
+
+	br := bufio.NewReader(r)
+	var buf []byte
+	var lf *LargeFile
+	var partSHA1s []string
+	for partNumber := 1; ; partNumber++ {
+		buf, err = readPart(br, buf, partSize)
+		last := err == io.EOF
+		if err != nil && !last {
+			return nil, err
+		}
+		if !last {
+			// Look ahead, so that a stream ending on a part boundary
+			// doesn't leave an empty last part.
+			if _, err := br.Peek(1); err == io.EOF {
+				last = true
+			} else if err != nil {
+				return nil, err
+			}
+		}
+		if partNumber == 1 && last {
+			return b.UploadFile(ctx, bytes.NewReader(buf), o)
+		}
+		if partNumber == 1 && o.ContentType == "" && b.c.DetectContentType != nil {
+			o.ContentType = b.c.DetectContentType(o.Name, buf[:min64(int64(len(buf)), sniffLen)])
+		}
+		if partNumber > maxParts {
+			return nil, fmt.Errorf("stream longer than %d parts of %d bytes", maxParts, partSize)
+		}
+
+		if lf == nil {
+			if lf, err = b.StartLargeFile(ctx, o.Name, o.ContentType, info); err != nil {
+				return nil, err
+			}
+		}
+		digest := sha1.Sum(buf)
+		sha1Sum := hex.EncodeToString(digest[:])
+		partSHA1s = append(partSHA1s, sha1Sum)
+		err = b.c.retryUpload(ctx, func(ctx context.Context) error {
+			part := throttle(ctx, bytes.NewReader(buf), o.MaxBytesPerSec)
+			return lf.UploadPart(ctx, partNumber, part, sha1Sum, int64(len(buf)))
+		})
+		if err != nil {
+			return nil, err
+		}
+		if last {
+			return lf.finishUpload(ctx, &o, partSHA1s)
+		}
+	}
+}
+
+// minPartBuffer is the initial capacity of the part buffer of UploadStream.
+const minPartBuffer = 64 * 1024
+
+// readPart reads size bytes from r into buf, reused from the previous part,
+// returning io.EOF with what was read if r ends before. buf grows as data
+// comes in, instead of taking a whole part up front, which is a waste for
+// streams much shorter than the part size.
+func readPart(r io.Reader, buf []byte, size int64) ([]byte, error) {
+	buf = buf[:0]
+	for int64(len(buf)) < size {
+		if len(buf) == cap(buf) {
+			c := 2 * int64(cap(buf))
+			if c < minPartBuffer {
+				c = minPartBuffer
+			}
+			if c > size {
+				c = size
+			}
+			grown := make([]byte, len(buf), c)
+			copy(grown, buf)
+			buf = grown
+		}
+		n, err := r.Read(buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		if err != nil {
+			return buf, err
+		}
+	}
+	return buf, nil
+}
+
+// chunkHasher calls fn with the SHA1 of each chunk of what is written to it.
+type chunkHasher struct {
+	size   int64
+	fn     func(offset, length int64, sha1Sum string)
+	h      hash.Hash
+	offset int64 // of the current chunk
+	n      int64 // bytes written to the current chunk
+}
+
+func (c *chunkHasher) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		k := c.size - c.n
+		if int64(len(p)) < k {
+			k = int64(len(p))
+		}
+		c.h.Write(p[:k])
+		c.n += k
+		p = p[k:]
+		if c.n == c.size {
+			c.flush()
+		}
+	}
+	return written, nil
+}
+
+// flush reports the current chunk, if not empty, and starts a new one.
+func (c *chunkHasher) flush() {
+	if c.n == 0 {
+		return
+	}
+	c.fn(c.offset, c.n, hex.EncodeToString(c.h.Sum(nil)))
+	c.offset += c.n
+	c.n = 0
+	c.h.Reset()
+}
+
+// partLength returns the length of the part at index i.
+func (p UploadPlan) partLength(i int) int64 {
+	if i == p.Parts-1 {
+		return p.LastPartSize
+	}
+	return p.PartSize
+}
+
+// A LargeFile is an unfinished large file, created with (*Bucket).StartLargeFile.
+// Its parts are uploaded with UploadPart, possibly concurrently, and it becomes
+// a regular file once Finish is called.
+//
+// The embedded FileInfo is the one returned by b2_start_large_file, so it
+// carries the content type and metadata the file will have once finished.
+type LargeFile struct {
+	FileInfo
+
+	b *Bucket
+
+	parts    map[int]part // by part number
+	canceled bool
+	partsMu  sync.Mutex // guards parts and canceled
+
+	uploadURLs   []*uploadURL
+	uploadURLsMu sync.Mutex
+}
+
+// StartLargeFile calls b2_start_large_file. If mimeType is "", "b2/x-auto"
+// will be used.
+//
+// The content type and metadata of a large file can only be set here: B2 does
+// not accept them when finishing the file, and they are carried through to
+// the FileInfo returned by Finish.
+func (b *Bucket) StartLargeFile(ctx context.Context, name, mimeType string, metadata map[string]string) (*LargeFile, error) {
+	if err := ValidateFileName(name); err != nil {
+		return nil, err
+	}
+	if mimeType == "" {
+		mimeType = "b2/x-auto"
+	}
+	params := map[string]interface{}{
+		"bucketId":    b.ID,
+		"fileName":    name,
+		"contentType": mimeType,
+	}
+	if len(metadata) > 0 || b.c.session != "" {
+		// Copied, to leave the map of the caller alone.
+		info := make(map[string]string, len(metadata)+1)
+		for k, v := range metadata {
+			info[k] = v
+		}
+		if info = b.c.sessionInfo(info); len(info) > 0 {
+			params["fileInfo"] = info
+		}
+	}
+	res, err := b.c.doRequest(ctx, "b2_start_large_file", params)
+	if err != nil {
+		return nil, err
+	}
+	defer drainAndClose(res.Body)
+	var fi fileInfoObj
+	if err := json.NewDecoder(res.Body).Decode(&fi); err != nil {
+		return nil, err
+	}
+	return &LargeFile{
+		FileInfo: *fi.makeFileInfo(),
+		b:        b,
+		parts:    make(map[int]part),
+	}, nil
+}
+
+type part struct {
+	sha1   string // hex
+	length int64
+	b2SHA1 string // as reported by B2
+}
+
+// checkParts returns an error matching ErrUploadSHA1Mismatch if B2 reported
+// a SHA1 other than sha1s[i] for the part i+1.
+func (lf *LargeFile) checkParts(sha1s []string) error {
+	lf.partsMu.Lock()
+	defer lf.partsMu.Unlock()
+	for i, sum := range sha1s {
+		if got := lf.parts[i+1].b2SHA1; got != sum {
+			return fmt.Errorf("%w: part %d of %s has SHA1 %s, want %s", ErrUploadSHA1Mismatch, i+1, lf.Name, got, sum)
+		}
+	}
+	return nil
+}
+
+// partTooSmall returns an error for a part smaller than the minimum part
+// size. Only the last part is allowed to be smaller.
+func (lf *LargeFile) partTooSmall(partNumber int, length int64) error {
+	minSize := lf.b.c.auth().loginInfo.Load().(*LoginInfo).AbsoluteMinimumPartSize
+	if length >= minSize {
+		return nil
+	}
+	return fmt.Errorf("part %d is %d bytes, but all parts except the last must be at least %d bytes",
+		partNumber, length, minSize)
+}
+
+func (lf *LargeFile) getUploadPartURL(ctx context.Context) (u *uploadURL, err error) {
+	lf.uploadURLsMu.Lock()
+	if len(lf.uploadURLs) > 0 {
+		u = lf.uploadURLs[len(lf.uploadURLs)-1]
+		lf.uploadURLs = lf.uploadURLs[:len(lf.uploadURLs)-1]
+	}
+	lf.uploadURLsMu.Unlock()
+	if u != nil {
+		return
+	}
+
+	res, err := lf.b.c.doRequest(ctx, "b2_get_upload_part_url", map[string]any{
+		"fileId": lf.ID,
+	})
+	if err != nil {
+		return
+	}
+	defer drainAndClose(res.Body)
+	err = json.NewDecoder(res.Body).Decode(&u)
+	return
+}
+
+func (lf *LargeFile) putUploadPartURL(u *uploadURL) {
+	lf.uploadURLsMu.Lock()
+	defer lf.uploadURLsMu.Unlock()
+	lf.uploadURLs = append(lf.uploadURLs, u)
+}
+
+// UploadPart calls b2_upload_part to upload the part partNumber, starting
+// from 1, reading exactly length bytes from r. Like UploadWithSHA1, it never
+// does any buffering nor does it retry on failure.
+//
+// sha1Sum should be the hex encoding of the SHA1 sum of what will be read from r.
+// Uploading the same partNumber again replaces the previous part.
+//
+// All parts but the last must be at least LoginInfo.AbsoluteMinimumPartSize
+// bytes long. A smaller part is rejected if a later part was already uploaded,
+// and Finish checks all of them.
+func (lf *LargeFile) UploadPart(ctx context.Context, partNumber int, r io.Reader, sha1Sum string, length int64) error {
+	if partNumber < 1 || partNumber > maxParts {
+		return fmt.Errorf("invalid part number %d, must be between 1 and 10000", partNumber)
+	}
+	lf.partsMu.Lock()
+	if lf.canceled {
+		lf.partsMu.Unlock()
+		return ErrLargeFileCanceled
+	}
+	for n := range lf.parts {
+		if n > partNumber {
+			if err := lf.partTooSmall(partNumber, length); err != nil {
+				lf.partsMu.Unlock()
+				return err
+			}
+			break
+		}
+	}
+	lf.partsMu.Unlock()
+	uurl, err := lf.getUploadPartURL(ctx)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", uurl.UploadURL, io.NopCloser(r))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = length
+	req.Header.Set("Authorization", uurl.AuthorizationToken)
+	req.Header.Set("X-Bz-Part-Number", strconv.Itoa(partNumber))
+	req.Header.Set("X-Bz-Content-Sha1", sha1Sum)
+
+	res, err := lf.b.c.hc.Do(req)
+	if err != nil {
+		lf.b.c.debugf("upload part %s #%d: %s", lf.Name, partNumber, err)
+		return err
+	}
+	lf.b.c.debugf("upload part %s #%d (%d %s)", lf.Name, partNumber, length, sha1Sum)
+	var pr struct {
+		ContentSHA1 string `json:"contentSha1"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&pr)
+	drainAndClose(res.Body)
+	if err != nil {
+		return err
+	}
+
+	lf.partsMu.Lock()
+	lf.parts[partNumber] = part{sha1: sha1Sum, length: length, b2SHA1: pr.ContentSHA1}
+	lf.partsMu.Unlock()
+	lf.putUploadPartURL(uurl)
+	return nil
+}
+
+// Finish calls b2_finish_large_file with the SHA1 of every part uploaded
+// with UploadPart, in part number order. Part numbers must start from 1 with
+// no gaps, otherwise an error naming the missing part is returned.
+//
+// The returned FileInfo carries the content type and metadata set by
+// StartLargeFile. B2 does not compute the SHA1 of a large file as a whole,
+// so its ContentSHA1 is "none", unless the large_file_sha1 file info key was
+// set at start, like UploadLarge does.
+func (lf *LargeFile) Finish(ctx context.Context) (*FileInfo, error) {
+	lf.partsMu.Lock()
+	if lf.canceled {
+		lf.partsMu.Unlock()
+		return nil, ErrLargeFileCanceled
+	}
+	numbers := make([]int, 0, len(lf.parts))
+	for n := range lf.parts {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+	partSHA1s := make([]string, len(numbers))
+	for i, n := range numbers {
+		if n != i+1 {
+			lf.partsMu.Unlock()
+			return nil, fmt.Errorf("part %d was not uploaded", i+1)
+		}
+		p := lf.parts[n]
+		if i < len(numbers)-1 {
+			if err := lf.partTooSmall(n, p.length); err != nil {
+				lf.partsMu.Unlock()
+				return nil, err
+			}
+		}
+		partSHA1s[i] = p.sha1
+	}
+	lf.partsMu.Unlock()
+
+	return lf.b.c.FinishLargeFile(ctx, lf.ID, partSHA1s)
+}
+
+// FinishLargeFile calls b2_finish_large_file for the large file with the
+// given ID. partSHA1s must contain the hex SHA1 of each part, in order, and
+// is checked for empty or malformed entries before the call.
+//
+// On top of the retries of every API call when B2 is busy, the call is
+// attempted again on the other server errors, 500, 502 and 504, and on
+// network errors, since all the parts are already uploaded at this point.
+// If it still fails, a *FinishError carrying fileID and partSHA1s is
+// returned, and the large file is left unfinished, so that finishing it can
+// be tried again later without uploading anything.
+//
+// Most clients should use (*LargeFile).Finish, which keeps track of the parts.
+func (c *Client) FinishLargeFile(ctx context.Context, fileID string, partSHA1s []string) (*FileInfo, error) {
+	if err := checkPartSHA1s(partSHA1s); err != nil {
+		return nil, err
+	}
+	params := map[string]interface{}{
+		"fileId":        fileID,
+		"partSha1Array": partSHA1s,
+	}
+	var (
+		res *http.Response
+		err error
+	)
+	for attempt := 0; ; attempt++ {
+		res, err = c.doRequest(ctx, "b2_finish_large_file", params)
+		if err == nil {
+			break
+		}
+		if attempt == maxAPIAttempts-1 || !retryableFinishError(ctx, err) {
+			// An earlier attempt may have finished the file without us
+			// seeing the response, making the last one fail.
+			if fi, ferr := c.GetFileInfoByID(ctx, fileID); ferr == nil && fi.Action == FileUpload {
+				return fi, nil
+			}
+			return nil, &FinishError{FileID: fileID, PartSHA1s: partSHA1s, Err: err}
+		}
+		c.debugf("b2_finish_large_file %s: %v, retrying", fileID, err)
+		if err := sleep(ctx, c.retryDelay(err, attempt)); err != nil {
+			return nil, &FinishError{FileID: fileID, PartSHA1s: partSHA1s, Err: err}
+		}
+	}
+	defer drainAndClose(res.Body)
+	var fi fileInfoObj
+	if err := json.NewDecoder(res.Body).Decode(&fi); err != nil {
+		return nil, err
+	}
+	return fi.makeFileInfo(), nil
+}
+
+// retryableFinishError reports whether b2_finish_large_file should be
+// attempted again after failing with err: a server error or a network error
+// that doRequest doesn't retry already.
+func retryableFinishError(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if e, ok := UnwrapError(err); ok {
+		switch e.Status {
+		case http.StatusInternalServerError, http.StatusBadGateway, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	return !retryableAPIError(ctx, err)
+}
+
+// FinishError is returned when b2_finish_large_file fails after all the parts
+// of a large file were uploaded, by FinishLargeFile, and so by Finish,
+// UploadLarge and UploadStream. The large file is left unfinished, and can be
+// finished later with FinishLargeFile(ctx, e.FileID, e.PartSHA1s), or with
+// ResumeLargeFile, without uploading the parts again.
+type FinishError struct {
+	FileID    string
+	PartSHA1s []string // hex, in part number order
+	Err       error
+}
+
+func (e *FinishError) Error() string {
+	return fmt.Sprintf("b2: finishing large file %s: %v", e.FileID, e.Err)
+}
+
+func (e *FinishError) Unwrap() error {
+	return e.Err
+}
+
+// ResumeLargeFile returns the unfinished large file fileID, for example after
+// a restart, or after a FinishError, knowing the parts already uploaded from
+// ListParts. The missing parts, if any, can then be uploaded with UploadPart,
+// and Finish finishes the file without uploading the others again.
+//
+// The part SHA1s are those listed by B2, the ones it received, rather than
+// the PartSHA1s of a FinishError: to finish with those instead, pass them to
+// FinishLargeFile, which needs no LargeFile.
+func (b *Bucket) ResumeLargeFile(ctx context.Context, fileID string) (*LargeFile, error) {
+	fi, err := b.c.GetFileInfoByID(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if fi.Action != FileStart {
+		return nil, fmt.Errorf("file %s is not an unfinished large file", fileID)
+	}
+	parts, err := b.c.ListParts(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+	lf := &LargeFile{
+		FileInfo: *fi,
+		b:        b,
+		parts:    make(map[int]part, len(parts)),
+	}
+	for _, p := range parts {
+		lf.parts[p.PartNumber] = part{sha1: p.ContentSHA1, length: p.ContentLength}
+	}
+	return lf, nil
+}
+
+// checkPartSHA1s returns an error if partSHA1s is empty, too long, or has an
+// entry that is not a hex SHA1.
+func checkPartSHA1s(partSHA1s []string) error {
+	if len(partSHA1s) == 0 {
+		return errors.New("no parts to finish the large file with")
+	}
+	if len(partSHA1s) > maxParts {
+		return fmt.Errorf("%d parts, the maximum is %d", len(partSHA1s), maxParts)
+	}
+	for i, sha1Sum := range partSHA1s {
+		if _, err := hex.DecodeString(sha1Sum); err != nil || len(sha1Sum) != 2*sha1.Size {
+			return fmt.Errorf("invalid SHA1 %q for part %d", sha1Sum, i+1)
+		}
+	}
+	return nil
+}
+
+// A PartInfo describes an uploaded part of a large file.
+type PartInfo struct {
+	PartNumber    int
+	ContentLength int64
+	ContentSHA1   string // hex
+}
+
+// ListParts lists the parts uploaded for the large file fileID, in order,
+// with b2_list_parts. B2 only lists the parts of unfinished large files.
+func (c *Client) ListParts(ctx context.Context, fileID string) ([]PartInfo, error) {
+	var parts []PartInfo
+	next := 1
+	for next != 0 {
+		res, err := c.doRequest(ctx, "b2_list_parts", map[string]interface{}{
+			"fileId":          fileID,
+			"startPartNumber": next,
+			"maxPartCount":    maxCount,
+		})
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Parts []struct {
+				PartNumber    int    `json:"partNumber"`
+				ContentLength int64  `json:"contentLength"`
+				ContentSHA1   string `json:"contentSha1"`
+			} `json:"parts"`
+			NextPartNumber int `json:"nextPartNumber"`
+		}
+		err = json.NewDecoder(res.Body).Decode(&page)
+		drainAndClose(res.Body)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range page.Parts {
+			parts = append(parts, PartInfo(p))
+		}
+		next = page.NextPartNumber
+	}
+	return parts, nil
+}
+
+// ErrLargeFileCanceled is returned by the methods of a LargeFile after Cancel.
+var ErrLargeFileCanceled = errors.New("b2: large file was canceled")
+
+// Cancel cancels the large file with b2_cancel_large_file, deleting the parts
+// uploaded so far, for example when its upload is abandoned. Once it succeeds,
+// UploadPart and Finish fail with ErrLargeFileCanceled without calling B2,
+// and calling Cancel again does nothing.
+func (lf *LargeFile) Cancel(ctx context.Context) error {
+	lf.partsMu.Lock()
+	canceled := lf.canceled
+	lf.partsMu.Unlock()
+	if canceled {
+		return nil
+	}
+	if err := lf.b.c.cancelLargeFile(ctx, lf.ID); err != nil {
+		return err
+	}
+	lf.partsMu.Lock()
+	lf.canceled = true
+	lf.partsMu.Unlock()
+	return nil
+}
+
+// CancelLargeFile cancels the unfinished large file fileID, like
+// (*LargeFile).Cancel, when its LargeFile is not at hand anymore, for example
+// after a restart. The IDs of the unfinished large files of the Bucket are
+// listed by ListUnfinishedLargeFiles.
+func (b *Bucket) CancelLargeFile(ctx context.Context, fileID string) error {
+	return b.c.cancelLargeFile(ctx, fileID)
+}
+
+// cancelLargeFile cancels the unfinished large file fileID with
+// b2_cancel_large_file, deleting its uploaded parts.
+func (c *Client) cancelLargeFile(ctx context.Context, fileID string) error {
+	res, err := c.doRequest(ctx, "b2_cancel_large_file", map[string]interface{}{
+		"fileId": fileID,
+	})
+	if err != nil {
+		return err
+	}
+	drainAndClose(res.Body)
+	return nil
+}
+
+// CancelStaleLargeFiles cancels the unfinished large files of the Bucket that
+// were started more than olderThan ago, like abandoned uploads of crashed
+// processes, deleting their parts to reclaim their storage. Up to 4 files are
+// canceled at the same time.
+//
+// Unfinished large files are listed in the order they were started, so the
+// listing stops at the first one that is recent enough. It stops at the
+// first error, which is returned with the number of files already canceled.
+func (b *Bucket) CancelStaleLargeFiles(ctx context.Context, olderThan time.Duration) (canceled int, err error) {
+	cutoff := time.Now().Add(-olderThan)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		mu       sync.Mutex
+		firstErr error
+	)
+	files := make(chan *FileInfo)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency(0); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for fi := range files {
+				err := b.c.cancelLargeFile(ctx, fi.ID)
+				mu.Lock()
+				if err == nil {
+					canceled++
+				} else if firstErr == nil {
+					firstErr = fmt.Errorf("canceling %s: %w", fi.Name, err)
+					cancel()
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	l := b.ListUnfinishedLargeFiles(ctx, ListOptions{})
+	l.SetPageCount(maxCount)
+list:
+	for l.Next() {
+		fi := l.FileInfo()
+		if !fi.UploadTimestamp.Before(cutoff) {
+			break
+		}
+		select {
+		case files <- fi:
+		case <-ctx.Done():
+			break list
+		}
+	}
+	close(files)
+	wg.Wait()
+	if firstErr != nil {
+		return canceled, firstErr
+	}
+	if err := l.Err(); err != nil {
+		return canceled, err
+	}
+	return canceled, ctx.Err()
+}
@@ -0,0 +1,299 @@
+package b2
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// finishTransport answers b2_finish_large_file with an error status the first
+// failures times, and b2_get_file_info and b2_list_parts for the large file.
+type finishTransport struct {
+	failures int
+	status   int // of the failures, 500 if 0
+	// lost makes the first finish succeed, but fail as if its response
+	// was lost on the way back.
+	lost bool
+
+	mu        sync.Mutex
+	finishes  int
+	finished  bool
+	partSHA1s []interface{}
+}
+
+func (t *finishTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	res := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Request: req}
+	var body string
+	switch {
+	case strings.HasSuffix(req.URL.Path, "/b2_finish_large_file"):
+		t.finishes++
+		var params map[string]interface{}
+		if err := json.NewDecoder(req.Body).Decode(&params); err != nil {
+			return nil, err
+		}
+		t.partSHA1s, _ = params["partSha1Array"].([]interface{})
+		switch {
+		case t.finished:
+			res.StatusCode = http.StatusBadRequest
+			body = `{"code": "bad_request", "message": "no such file", "status": 400}`
+		case t.lost:
+			t.finished = true
+			return nil, errors.New("connection reset")
+		case t.finishes <= t.failures:
+			res.StatusCode = http.StatusInternalServerError
+			if t.status != 0 {
+				res.StatusCode = t.status
+			}
+			body = fmt.Sprintf(`{"code": "internal_error", "message": "oops", "status": %d}`, res.StatusCode)
+		default:
+			t.finished = true
+			body = `{"fileId": "large", "fileName": "name", "action": "upload"}`
+		}
+	case strings.HasSuffix(req.URL.Path, "/b2_get_file_info"):
+		action := "start"
+		if t.finished {
+			action = "upload"
+		}
+		body = `{"fileId": "large", "fileName": "name", "action": "` + action + `"}`
+	case strings.HasSuffix(req.URL.Path, "/b2_list_parts"):
+		body = `{"parts": [
+			{"partNumber": 1, "contentLength": 100, "contentSha1": "` + strings.Repeat("1", 40) + `"},
+			{"partNumber": 2, "contentLength": 10, "contentSha1": "` + strings.Repeat("2", 40) + `"}
+		], "nextPartNumber": null}`
+	default:
+		res.StatusCode = http.StatusNotFound
+		body = `{"code": "not_found", "message": "unexpected request", "status": 404}`
+	}
+	res.Body = io.NopCloser(strings.NewReader(body))
+	return res, nil
+}
+
+func newFinishClient(tr *finishTransport) *Client {
+	c := &Client{RetryPolicy: &RetryPolicy{MinBackoff: time.Millisecond}}
+	c.hc = &http.Client{Transport: &transport{t: tr, c: c}}
+	c.loginInfo.Store(&LoginInfo{ApiURL: "https://api.example.com", AbsoluteMinimumPartSize: 100})
+	return c
+}
+
+func TestFinishLargeFileRetry(t *testing.T) {
+	ctx := context.Background()
+	sha1s := []string{strings.Repeat("a", 40), strings.Repeat("b", 40)}
+
+	tr := &finishTransport{failures: 2}
+	fi, err := newFinishClient(tr).FinishLargeFile(ctx, "large", sha1s)
+	if err != nil || fi.ID != "large" || tr.finishes != 3 {
+		t.Errorf("got %+v after %d attempts: %v", fi, tr.finishes, err)
+	}
+
+	tr = &finishTransport{lost: true}
+	fi, err = newFinishClient(tr).FinishLargeFile(ctx, "large", sha1s)
+	if err != nil || fi.Action != FileUpload {
+		t.Errorf("finished file with a lost response: got %+v, %v", fi, err)
+	}
+
+	tr = &finishTransport{failures: 1000}
+	_, err = newFinishClient(tr).FinishLargeFile(ctx, "large", sha1s)
+	var fe *FinishError
+	if !errors.As(err, &fe) || fe.FileID != "large" || len(fe.PartSHA1s) != 2 || fe.PartSHA1s[1] != sha1s[1] {
+		t.Fatalf("got error %v, want a FinishError", err)
+	}
+	if e, ok := UnwrapError(err); !ok || e.Status != http.StatusInternalServerError {
+		t.Errorf("UnwrapError(%v) = %v", err, e)
+	}
+	if tr.finishes != maxAPIAttempts {
+		t.Errorf("%d attempts, want %d", tr.finishes, maxAPIAttempts)
+	}
+
+	// Busy answers are only retried by doRequest.
+	tr = &finishTransport{failures: 1000, status: http.StatusServiceUnavailable}
+	if _, err := newFinishClient(tr).FinishLargeFile(ctx, "large", sha1s); !errors.As(err, &fe) {
+		t.Errorf("got error %v, want a FinishError", err)
+	}
+	if tr.finishes != maxAPIAttempts {
+		t.Errorf("%d attempts with status 503, want %d", tr.finishes, maxAPIAttempts)
+	}
+}
+
+func TestResumeLargeFile(t *testing.T) {
+	ctx := context.Background()
+	tr := &finishTransport{}
+	b := newFinishClient(tr).BucketByID("bucket")
+	lf, err := b.ResumeLargeFile(ctx, "large")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := lf.Finish(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.ID != "large" || len(tr.partSHA1s) != 2 || tr.partSHA1s[0] != strings.Repeat("1", 40) {
+		t.Errorf("finished %+v with %v", fi, tr.partSHA1s)
+	}
+
+	if _, err := b.ResumeLargeFile(ctx, "large"); err == nil {
+		t.Error("resumed a finished file")
+	}
+}
+
+func TestLargeFileCancel(t *testing.T) {
+	ctx := context.Background()
+	tr := &apiTransport{
+		bodies: map[string]string{
+			"b2_start_large_file":  `{"fileId": "large", "fileName": "name", "action": "start"}`,
+			"b2_cancel_large_file": `{"fileId": "large", "fileName": "name"}`,
+		},
+		params: make(map[string]map[string]interface{}),
+	}
+	c := &Client{hc: &http.Client{Transport: tr}}
+	c.loginInfo.Store(&LoginInfo{ApiURL: "https://api.example.com"})
+	lf, err := c.BucketByID("bucket").StartLargeFile(ctx, "name", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lf.Cancel(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := tr.params["b2_cancel_large_file"]["fileId"]; got != "large" {
+		t.Errorf("canceled file %v, want large", got)
+	}
+
+	delete(tr.params, "b2_cancel_large_file")
+	if err := lf.Cancel(ctx); err != nil {
+		t.Errorf("second Cancel: %v", err)
+	}
+	if err := lf.UploadPart(ctx, 1, strings.NewReader("data"), strings.Repeat("0", 40), 4); err != ErrLargeFileCanceled {
+		t.Errorf("UploadPart after Cancel: got %v, want ErrLargeFileCanceled", err)
+	}
+	if _, err := lf.Finish(ctx); err != ErrLargeFileCanceled {
+		t.Errorf("Finish after Cancel: got %v, want ErrLargeFileCanceled", err)
+	}
+	if len(tr.params) != 1 {
+		t.Errorf("API called after Cancel: %v", tr.params)
+	}
+}
+
+func TestReadPart(t *testing.T) {
+	buf, err := readPart(strings.NewReader("short"), nil, 100*1000*1000)
+	if err != io.EOF || string(buf) != "short" || cap(buf) != minPartBuffer {
+		t.Errorf("got %q (cap %d), %v", buf, cap(buf), err)
+	}
+
+	const size = 3*minPartBuffer + 10
+	data := strings.Repeat("0123456789", 2*size/10+1)[:2*size]
+	r := strings.NewReader(data)
+	buf, err = readPart(r, nil, size)
+	if err != nil || string(buf) != data[:size] || cap(buf) != size {
+		t.Fatalf("got %d bytes (cap %d), %v", len(buf), cap(buf), err)
+	}
+	buf, err = readPart(r, buf, size)
+	if err != nil || string(buf) != data[size:] {
+		t.Fatalf("got %d bytes, %v", len(buf), err)
+	}
+	if buf, err = readPart(r, buf, size); err != io.EOF || len(buf) != 0 {
+		t.Errorf("got %d bytes at the end, %v", len(buf), err)
+	}
+}
+
+// staleTransport lists unfinished large files started at the given times,
+// in order, and cancels them, failing for the file fail.
+type staleTransport struct {
+	started map[string]time.Time
+	order   []string
+	fail    string
+
+	mu       sync.Mutex
+	canceled []string
+}
+
+func (t *staleTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Request: req}
+	var body string
+	switch {
+	case strings.HasSuffix(req.URL.Path, "/b2_list_unfinished_large_files"):
+		var files []string
+		for _, id := range t.order {
+			files = append(files, fmt.Sprintf(`{"fileId": %q, "fileName": %q, "action": "start", "uploadTimestamp": %d}`,
+				id, "name-"+id, t.started[id].UnixMilli()))
+		}
+		body = `{"files": [` + strings.Join(files, ",") + `], "nextFileId": null}`
+	case strings.HasSuffix(req.URL.Path, "/b2_cancel_large_file"):
+		var params struct {
+			FileID string `json:"fileId"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&params); err != nil {
+			return nil, err
+		}
+		if params.FileID == t.fail {
+			res.StatusCode = http.StatusBadRequest
+			body = `{"code": "bad_request", "message": "cannot cancel", "status": 400}`
+			break
+		}
+		t.mu.Lock()
+		t.canceled = append(t.canceled, params.FileID)
+		t.mu.Unlock()
+		body = `{"fileId": "` + params.FileID + `"}`
+	default:
+		res.StatusCode = http.StatusNotFound
+		body = `{"code": "not_found", "message": "unexpected request", "status": 404}`
+	}
+	res.Body = io.NopCloser(strings.NewReader(body))
+	return res, nil
+}
+
+func TestCancelStaleLargeFilesCutoff(t *testing.T) {
+	ctx := context.Background()
+	old, recent := time.Now().Add(-2*time.Hour), time.Now()
+	newTransport := func(fail string) *staleTransport {
+		return &staleTransport{
+			// Listed by start time, as B2 does, except "late": the listing
+			// stops at the first recent file.
+			order:   []string{"s1", "s2", "s3", "s4", "s5", "r1", "late"},
+			started: map[string]time.Time{"s1": old, "s2": old, "s3": old, "s4": old, "s5": old, "r1": recent, "late": old},
+			fail:    fail,
+		}
+	}
+	newBucket := func(tr *staleTransport) *Bucket {
+		c := &Client{}
+		c.hc = &http.Client{Transport: &transport{t: tr, c: c}}
+		c.loginInfo.Store(&LoginInfo{ApiURL: "https://api.example.com"})
+		return c.BucketByID("bucket")
+	}
+
+	tr := newTransport("")
+	n, err := newBucket(tr).CancelStaleLargeFiles(ctx, time.Hour)
+	sort.Strings(tr.canceled)
+	if err != nil || n != 5 || strings.Join(tr.canceled, " ") != "s1 s2 s3 s4 s5" {
+		t.Errorf("canceled %d %q: %v", n, tr.canceled, err)
+	}
+
+	tr = newTransport("s2")
+	n, err = newBucket(tr).CancelStaleLargeFiles(ctx, time.Hour)
+	if e, ok := UnwrapError(err); !ok || e.Code != "bad_request" || !strings.Contains(err.Error(), "name-s2") {
+		t.Errorf("got %v, want the error canceling s2", err)
+	}
+	if n != len(tr.canceled) || n > 4 {
+		t.Errorf("reported %d canceled, canceled %q", n, tr.canceled)
+	}
+	for _, id := range tr.canceled {
+		if id == "r1" || id == "late" {
+			t.Errorf("canceled %s", id)
+		}
+	}
+
+	tr = newTransport("")
+	canceledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	if n, err := newBucket(tr).CancelStaleLargeFiles(canceledCtx, time.Hour); err == nil || n != 0 || len(tr.canceled) != 0 {
+		t.Errorf("with a canceled context: canceled %d %q: %v", n, tr.canceled, err)
+	}
+}
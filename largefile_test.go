@@ -0,0 +1,218 @@
+package b2_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kardianos/b2"
+)
+
+func TestLargeFileLifecycle(t *testing.T) {
+	ctx := context.Background()
+	c := getClient(t, ctx)
+	b := getBucket(t, ctx, c)
+	defer deleteBucket(t, b)
+
+	metadata := map[string]string{"foo": "bar"}
+	lf, err := b.StartLargeFile(ctx, "test-large", "application/x-test", metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lf.CustomMetadata["foo"] != "bar" {
+		t.Error("metadata missing from started file:", lf.CustomMetadata)
+	}
+
+	// All parts but the last must be at least absoluteMinimumPartSize (5MB).
+	parts := [][]byte{make([]byte, 5*1000*1000), make([]byte, 1234)}
+	var size int64
+	for i, part := range parts {
+		rand.Read(part)
+		digest := sha1.Sum(part)
+		err := lf.UploadPart(ctx, i+1, bytes.NewReader(part), hex.EncodeToString(digest[:]), int64(len(part)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		size += int64(len(part))
+	}
+
+	small := parts[1]
+	digest := sha1.Sum(small)
+	if err := lf.UploadPart(ctx, 1, bytes.NewReader(small), hex.EncodeToString(digest[:]), int64(len(small))); err == nil {
+		t.Error("too small part 1 was accepted")
+	}
+
+	fi, err := lf.Finish(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteFile(ctx, fi.ID, fi.Name)
+	if fi.ID != lf.ID {
+		t.Error("Mismatched file ID")
+	}
+	if fi.ContentLength != size {
+		t.Error("Mismatched file length", fi.ContentLength)
+	}
+	if fi.ContentType != "application/x-test" {
+		t.Error("Mismatched content type", fi.ContentType)
+	}
+	if fi.CustomMetadata["foo"] != "bar" {
+		t.Error("metadata missing from finished file:", fi.CustomMetadata)
+	}
+
+	fi2, err := c.GetFileInfoByID(ctx, fi.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi2.CustomMetadata["foo"] != "bar" {
+		t.Error("metadata missing from GetFileInfoByID:", fi2.CustomMetadata)
+	}
+}
+
+func TestCancelStaleLargeFiles(t *testing.T) {
+	ctx := context.Background()
+	c := getClient(t, ctx)
+	b := getBucket(t, ctx, c)
+	defer deleteBucket(t, b)
+
+	for _, name := range []string{"stale-1", "stale-2"} {
+		if _, err := b.StartLargeFile(ctx, name, "", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	canceled, err := b.CancelStaleLargeFiles(ctx, time.Hour)
+	if err != nil || canceled != 0 {
+		t.Errorf("canceled %d recent files: %v", canceled, err)
+	}
+	time.Sleep(time.Second)
+	canceled, err = b.CancelStaleLargeFiles(ctx, 0)
+	if err != nil || canceled != 2 {
+		t.Errorf("canceled %d stale files, want 2: %v", canceled, err)
+	}
+	l := b.ListUnfinishedLargeFiles(ctx, b2.ListOptions{})
+	if l.Next() {
+		t.Errorf("%s left unfinished", l.FileInfo().Name)
+	}
+	if err := l.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFinishLargeFileValidation(t *testing.T) {
+	ctx := context.Background()
+	c := &b2.Client{} // fails before any API call
+
+	valid := strings.Repeat("0a", 20)
+	for _, partSHA1s := range [][]string{
+		nil,
+		{valid, ""},
+		{valid, strings.Repeat("0", 39)},
+		{strings.Repeat("zz", 20)},
+	} {
+		if _, err := c.FinishLargeFile(ctx, "id", partSHA1s); err == nil {
+			t.Errorf("%q: expected an error", partSHA1s)
+		}
+	}
+}
+
+func TestPlanUpload(t *testing.T) {
+	const MB = 1000 * 1000
+	for _, tt := range []struct {
+		size int64
+		o    b2.LargeUploadOptions
+		want b2.UploadPlan
+	}{
+		{0, b2.LargeUploadOptions{}, b2.UploadPlan{PartSize: 100 * MB, Parts: 1}},
+		{50 * MB, b2.LargeUploadOptions{}, b2.UploadPlan{PartSize: 100 * MB, Parts: 1, LastPartSize: 50 * MB}},
+		{100 * MB, b2.LargeUploadOptions{}, b2.UploadPlan{PartSize: 100 * MB, Parts: 1, LastPartSize: 100 * MB}},
+		{250 * MB, b2.LargeUploadOptions{}, b2.UploadPlan{Multipart: true, PartSize: 100 * MB, Parts: 3, LastPartSize: 50 * MB}},
+		{200 * MB, b2.LargeUploadOptions{}, b2.UploadPlan{Multipart: true, PartSize: 100 * MB, Parts: 2, LastPartSize: 100 * MB}},
+		{12 * MB, b2.LargeUploadOptions{PartSize: 5 * MB}, b2.UploadPlan{Multipart: true, PartSize: 5 * MB, Parts: 3, LastPartSize: 2 * MB}},
+		{20000 * MB, b2.LargeUploadOptions{PartSize: 1 * MB}, b2.UploadPlan{Multipart: true, PartSize: 2 * MB, Parts: 10000, LastPartSize: 2 * MB}},
+	} {
+		if got := b2.PlanUpload(tt.size, tt.o); got != tt.want {
+			t.Errorf("PlanUpload(%d, %+v) = %+v, want %+v", tt.size, tt.o, got, tt.want)
+		}
+	}
+}
+
+func TestUploadLarge(t *testing.T) {
+	ctx := context.Background()
+	c := getClient(t, ctx)
+	b := getBucket(t, ctx, c)
+	defer deleteBucket(t, b)
+
+	file := make([]byte, 11*1000*1000)
+	rand.Read(file)
+	var chunks []int64
+	chunkSHA1 := func(offset, length int64, sha1Sum string) {
+		if want := sha1.Sum(file[offset : offset+length]); sha1Sum != hex.EncodeToString(want[:]) {
+			t.Errorf("wrong SHA1 for chunk at %d", offset)
+		}
+		chunks = append(chunks, length)
+	}
+	fi, err := b.UploadLarge(ctx, bytes.NewReader(file), b2.UploadOptions{Name: "test-large"},
+		b2.LargeUploadOptions{
+			PartSize:      5 * 1000 * 1000,
+			LargeFileSHA1: true,
+			ChunkSize:     2 * 1000 * 1000,
+			ChunkSHA1:     chunkSHA1,
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteFile(ctx, fi.ID, fi.Name)
+	const MB = 1000 * 1000
+	if want := []int64{2 * MB, 2 * MB, 1 * MB, 2 * MB, 2 * MB, 1 * MB, 1 * MB}; !reflect.DeepEqual(chunks, want) {
+		t.Errorf("chunk lengths are %v, expected %v", chunks, want)
+	}
+	digest := sha1.Sum(file)
+	if fi.ContentSHA1 != hex.EncodeToString(digest[:]) {
+		t.Error("wrong SHA1:", fi.ContentSHA1)
+	}
+	if fi.ContentLength != int64(len(file)) {
+		t.Error("mismatched fi.ContentLength", fi.ContentLength)
+	}
+
+	rc, fi2, err := c.DownloadFileByID(ctx, fi.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc.Close()
+	if fi2.ContentSHA1 != fi.ContentSHA1 {
+		t.Error("wrong SHA1 on download:", fi2.ContentSHA1)
+	}
+}
+
+func TestUploadStream(t *testing.T) {
+	ctx := context.Background()
+	c := getClient(t, ctx)
+	b := getBucket(t, ctx, c)
+	defer deleteBucket(t, b)
+	defer deleteAll(t, c, b)
+
+	const MB = 1000 * 1000
+	for _, size := range []int{1234, 5 * MB, 10 * MB, 11 * MB} {
+		file := make([]byte, size)
+		rand.Read(file)
+		r := io.MultiReader(bytes.NewReader(file)) // hide the length
+		fi, err := b.UploadStream(ctx, r, b2.UploadOptions{Name: "test-stream"},
+			b2.LargeUploadOptions{PartSize: 5 * MB})
+		if err != nil {
+			t.Fatal(size, err)
+		}
+		if fi.ContentLength != int64(size) {
+			t.Errorf("%d: mismatched fi.ContentLength %d", size, fi.ContentLength)
+		}
+		if multipart := fi.ContentSHA1 == "none"; multipart != (size > 5*MB) {
+			t.Errorf("%d: unexpected SHA1 %q", size, fi.ContentSHA1)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package b2
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+)
+
+// A ManifestEntry is a line of the manifest written by Manifest.
+type ManifestEntry struct {
+	Name            string            `json:"name"`
+	Size            int64             `json:"size"`
+	SHA1            string            `json:"sha1"`
+	ContentType     string            `json:"contentType"`
+	UploadTimestamp int64             `json:"uploadTimestamp"` // milliseconds since the epoch
+	Metadata        map[string]string `json:"metadata"`
+}
+
+// Manifest writes to w a manifest of the current version of every file under
+// prefix, one JSON ManifestEntry per line, in name order, for example to
+// compare the contents of a bucket with another store. Fields are always in
+// the same order, and metadata keys are sorted, so that manifests of the same
+// files are identical. SHA1 is "none" for large files without a known SHA1.
+//
+// Entries are written as the listing goes, without holding it in memory.
+func (b *Bucket) Manifest(ctx context.Context, prefix string, w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	l := b.ListFiles(ctx, ListOptions{Prefix: prefix})
+	l.SetPageCount(maxCount)
+	for l.Next() {
+		fi := l.FileInfo()
+		err := enc.Encode(&ManifestEntry{
+			Name:            fi.Name,
+			Size:            fi.ContentLength,
+			SHA1:            fi.ContentSHA1,
+			ContentType:     fi.ContentType,
+			UploadTimestamp: fi.UploadTimestampMillis,
+			Metadata:        fi.CustomMetadata,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return l.Err()
+}
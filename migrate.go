@@ -0,0 +1,156 @@
+package b2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// A MigrateAction is what MigrateBucket did with a file.
+type MigrateAction string
+
+const (
+	MigrateCopy MigrateAction = "copy" // The file was copied to the destination.
+	MigrateSkip MigrateAction = "skip" // The file was already in the destination.
+)
+
+// MigrateOptions are the options of (*Client).MigrateBucket.
+type MigrateOptions struct {
+	// Concurrency is the number of files copied at the same time.
+	// If 0, 4 is used.
+	Concurrency int
+
+	// Prefix, if set, only migrates the files whose name starts with it.
+	Prefix string
+
+	// DeleteSource deletes the copied version of each source file once its
+	// copy is verified to have the same size and SHA1, including files
+	// skipped because they were already copied. Older versions are kept.
+	// Files whose SHA1 isn't known, like large files uploaded without
+	// large_file_sha1, can't be verified, and are never deleted.
+	DeleteSource bool
+
+	// Progress, if set, is called after each file is processed, possibly
+	// from multiple goroutines at once.
+	Progress func(name string, action MigrateAction, err error)
+}
+
+// MigrateBucket copies the current version of every file of the bucket
+// srcBucketID to the bucket dstBucketID, keeping their names, content types
+// and metadata, with server-side copies that don't download the contents.
+//
+// Files already in the destination with the same size and SHA1 are skipped,
+// so an interrupted migration can be resumed by calling it again. Files whose
+// SHA1 isn't known are always copied again. Files over the 5GB limit of
+// CopyFile are skipped, with an error, without calling B2.
+//
+// MigrateBucket does not stop when a file fails: it returns the first error
+// once all the files are processed.
+func (c *Client) MigrateBucket(ctx context.Context, srcBucketID, dstBucketID string, o MigrateOptions) error {
+	if srcBucketID == dstBucketID {
+		return errors.New("MigrateBucket needs different buckets")
+	}
+	src, dst := c.BucketByID(srcBucketID), c.BucketByID(dstBucketID)
+	existing, err := dst.listTree(ctx, o.Prefix)
+	if err != nil {
+		return err
+	}
+
+	var (
+		mu       sync.Mutex
+		firstErr error
+	)
+	files := make(chan *FileInfo)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency(o.Concurrency); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for fi := range files {
+				action, err := c.migrateFile(ctx, src, fi, dstBucketID, existing[fi.Name], o.DeleteSource)
+				if err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = fmt.Errorf("migrating %s: %w", fi.Name, err)
+					}
+					mu.Unlock()
+				}
+				if o.Progress != nil {
+					o.Progress(fi.Name, action, err)
+				}
+			}
+		}()
+	}
+
+	l := src.ListFiles(ctx, ListOptions{Prefix: o.Prefix})
+	l.SetPageCount(maxCount)
+list:
+	for l.Next() {
+		select {
+		case files <- l.FileInfo():
+		case <-ctx.Done():
+			break list
+		}
+	}
+	close(files)
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	if err := l.Err(); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// migrateFile copies fi from src to the bucket dstBucketID, unless dst, the
+// file of the same name there, is already a verified copy.
+func (c *Client) migrateFile(ctx context.Context, src *Bucket, fi *FileInfo, dstBucketID string, dst *FileInfo, deleteSource bool) (MigrateAction, error) {
+	if fi.ContentLength > maxSingleFileSize {
+		return MigrateSkip, fmt.Errorf("%d bytes, over the 5GB limit of CopyFile", fi.ContentLength)
+	}
+	action := MigrateSkip
+	if !sameContents(fi, dst) {
+		action = MigrateCopy
+		var err error
+		dst, err = c.CopyFile(ctx, fi.ID, fi.Name, CopyOptions{DestinationBucketID: dstBucketID})
+		if err != nil {
+			return action, err
+		}
+		if dst.ContentLength != fi.ContentLength {
+			return action, fmt.Errorf("copy has size %d, want %d", dst.ContentLength, fi.ContentLength)
+		}
+		if !sameContents(fi, dst) {
+			sum, known := knownSHA1(fi)
+			if dstSum, dstKnown := knownSHA1(dst); known && dstKnown {
+				return action, fmt.Errorf("copy has SHA1 %s, want %s", dstSum, sum)
+			}
+			c.debugf("migrate %s: SHA1 unknown, keeping the source", fi.Name)
+			return action, nil
+		}
+	}
+	if deleteSource {
+		return action, c.DeleteFile(ctx, fi.ID, fi.Name)
+	}
+	return action, nil
+}
+
+// sameContents reports whether b is a copy of a, by size and SHA1. Files
+// whose SHA1 isn't known are never the same.
+func sameContents(a, b *FileInfo) bool {
+	if b == nil || a.ContentLength != b.ContentLength {
+		return false
+	}
+	sumA, okA := knownSHA1(a)
+	sumB, okB := knownSHA1(b)
+	return okA && okB && sumA == sumB
+}
+
+// knownSHA1 returns the SHA1 of fi, if known: large files without
+// large_file_sha1 have none.
+func knownSHA1(fi *FileInfo) (string, bool) {
+	sum := strings.TrimPrefix(fi.ContentSHA1, "unverified:")
+	return sum, sum != "" && sum != "none"
+}
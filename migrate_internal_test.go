@@ -0,0 +1,70 @@
+package b2
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSameContents(t *testing.T) {
+	sum := strings.Repeat("a", 40)
+	for _, tt := range []struct {
+		a, b *FileInfo
+		want bool
+	}{
+		{&FileInfo{ContentLength: 1, ContentSHA1: sum}, &FileInfo{ContentLength: 1, ContentSHA1: sum}, true},
+		{&FileInfo{ContentLength: 1, ContentSHA1: sum}, &FileInfo{ContentLength: 1, ContentSHA1: "unverified:" + sum}, true},
+		{&FileInfo{ContentLength: 1, ContentSHA1: sum}, nil, false},
+		{&FileInfo{ContentLength: 1, ContentSHA1: sum}, &FileInfo{ContentLength: 2, ContentSHA1: sum}, false},
+		{&FileInfo{ContentLength: 1, ContentSHA1: "none"}, &FileInfo{ContentLength: 1, ContentSHA1: "none"}, false},
+		{&FileInfo{ContentLength: 1}, &FileInfo{ContentLength: 1}, false},
+	} {
+		if got := sameContents(tt.a, tt.b); got != tt.want {
+			t.Errorf("sameContents(%+v, %+v) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestMigrateFileUnverified(t *testing.T) {
+	ctx := context.Background()
+	tr := &apiTransport{
+		bodies: map[string]string{
+			"b2_copy_file":           `{"fileId": "copy", "fileName": "name", "action": "copy", "contentLength": 10, "contentSha1": "none"}`,
+			"b2_delete_file_version": `{}`,
+		},
+		params: make(map[string]map[string]interface{}),
+	}
+	c := &Client{hc: &http.Client{Transport: tr}}
+	c.loginInfo.Store(&LoginInfo{ApiURL: "https://api.example.com"})
+	src := c.BucketByID("src")
+
+	large := &FileInfo{ID: "large", Name: "name", ContentLength: 10, ContentSHA1: "none"}
+	dst := &FileInfo{ID: "other", Name: "name", ContentLength: 10, ContentSHA1: "none"}
+	action, err := c.migrateFile(ctx, src, large, "dst", dst, true)
+	if err != nil || action != MigrateCopy {
+		t.Errorf("got %v, %v, want a copy", action, err)
+	}
+	if _, ok := tr.params["b2_delete_file_version"]; ok {
+		t.Error("unverified source deleted")
+	}
+
+	sum := strings.Repeat("a", 40)
+	fi := &FileInfo{ID: "v2", Name: "name", ContentLength: 10, ContentSHA1: sum}
+	action, err = c.migrateFile(ctx, src, fi, "dst", &FileInfo{ContentLength: 10, ContentSHA1: sum}, true)
+	if err != nil || action != MigrateSkip {
+		t.Errorf("got %v, %v, want a skip", action, err)
+	}
+	if got := tr.params["b2_delete_file_version"]["fileId"]; got != "v2" {
+		t.Errorf("deleted %v, want only the copied version v2", got)
+	}
+
+	delete(tr.params, "b2_copy_file")
+	huge := &FileInfo{ID: "huge", Name: "huge", ContentLength: maxSingleFileSize + 1, ContentSHA1: sum}
+	if action, err := c.migrateFile(ctx, src, huge, "dst", nil, true); err == nil || action != MigrateSkip {
+		t.Errorf("file over 5GB: got %v, %v", action, err)
+	}
+	if _, ok := tr.params["b2_copy_file"]; ok {
+		t.Error("file over 5GB copied")
+	}
+}
@@ -0,0 +1,174 @@
+package b2
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ErrDownloadChanged is returned by ResumableDownload when the remote file
+// changed since the download started, so it can't be resumed. Remove the
+// state file to start over.
+var ErrDownloadChanged = errors.New("b2: remote file changed since the download started")
+
+// resumeStateInterval is the number of bytes downloaded between saves of the
+// state of a ResumableDownload.
+var resumeStateInterval int64 = 64 << 20
+
+// resumeState is the content of the state file of a ResumableDownload.
+type resumeState struct {
+	FileID      string `json:"fileId"`
+	ContentSHA1 string `json:"contentSha1"`
+	Size        int64  `json:"size"`
+	Offset      int64  `json:"offset"`
+	// SHA1 is the marshaled state of the SHA1 of the first Offset bytes.
+	SHA1 []byte `json:"sha1"`
+}
+
+// ResumableDownload downloads the file described by o to destPath, recording
+// its progress in statePath, so that calling it again after an interruption,
+// even from another process, continues where it left off with a Range
+// request, instead of starting over.
+//
+// The state file records the file ID, the expected SHA1, and the number of
+// bytes safely written to destPath, along with the state of the SHA1 of these
+// bytes, so that the whole file is verified without reading it back. It is
+// saved every 64MB, after syncing destPath, and removed once the download
+// is complete and verified. Without a state file, the download starts over.
+//
+// Before resuming, the ID of the remote file is compared with the recorded
+// one, and ErrDownloadChanged is returned if a new version was uploaded since.
+// destPath is written in place, and is only complete once ResumableDownload
+// returns without error.
+//
+// The stored bytes are downloaded, as with o.RawBody. o.Range, o.MaxBytes and
+// o.Decompress are not supported.
+func (c *Client) ResumableDownload(ctx context.Context, o DownloadOptions, destPath, statePath string) (*FileInfo, error) {
+	if o.Range != (Range{}) || o.MaxBytes > 0 || o.Decompress {
+		return nil, errors.New("ResumableDownload does not support Range, MaxBytes nor Decompress")
+	}
+	o.RawBody = true
+
+	var st resumeState
+	h := sha1.New()
+	switch data, err := os.ReadFile(statePath); {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(data, &st); err != nil {
+			return nil, fmt.Errorf("invalid state file %s: %v", statePath, err)
+		}
+		if err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(st.SHA1); err != nil {
+			return nil, fmt.Errorf("invalid state file %s: %v", statePath, err)
+		}
+		if st.Offset <= 0 || st.Offset >= st.Size {
+			return nil, fmt.Errorf("invalid state file %s: offset %d of %d", statePath, st.Offset, st.Size)
+		}
+		o.Range = Range{Begin: st.Offset, End: st.Size - 1}
+	}
+
+	res, fi, err := c.DownloadFileResponse(ctx, o)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if st.Offset > 0 {
+		if fi.ID != st.FileID {
+			return nil, ErrDownloadChanged
+		}
+		fi.ContentLength = st.Size
+	} else {
+		st = resumeState{FileID: fi.ID, ContentSHA1: fi.ContentSHA1, Size: fi.ContentLength}
+		h.Reset()
+	}
+
+	f, err := os.OpenFile(destPath, os.O_WRONLY|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	// Bytes written after the last save of the state are discarded.
+	if err := f.Truncate(st.Offset); err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(st.Offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	w := io.MultiWriter(f, h)
+	for st.Offset < st.Size {
+		n, err := io.CopyN(w, res.Body, min64(resumeStateInterval, st.Size-st.Offset))
+		st.Offset += n
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if st.Offset < st.Size {
+			if err := saveResumeState(f, h.(encoding.BinaryMarshaler), &st, statePath); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := checkSHA1(h, st.ContentSHA1); err != nil {
+		return nil, err
+	}
+	if err := f.Sync(); err != nil {
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(statePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return fi, nil
+}
+
+// saveResumeState syncs f, whose SHA1 so far is h, and atomically replaces
+// the state file at path with st.
+func saveResumeState(f *os.File, h encoding.BinaryMarshaler, st *resumeState, path string) error {
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	var err error
+	if st.SHA1, err = h.MarshalBinary(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".b2-state-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // fails harmlessly after the rename
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
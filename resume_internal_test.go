@@ -0,0 +1,103 @@
+package b2
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// rangeTransport serves the contents of a file, honoring Range headers, and
+// fails the body after failAfter bytes if positive.
+type rangeTransport struct {
+	id        string
+	data      []byte
+	failAfter int
+	ranges    []string
+}
+
+func (t *rangeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	data := t.data
+	rh := req.Header.Get("Range")
+	t.ranges = append(t.ranges, rh)
+	status := http.StatusOK
+	if rh != "" {
+		var begin, end int
+		if _, err := fmt.Sscanf(rh, "bytes=%d-%d", &begin, &end); err != nil {
+			return nil, err
+		}
+		data = data[begin : end+1]
+		status = http.StatusPartialContent
+	}
+	sum := sha1.Sum(t.data)
+	h := http.Header{}
+	h.Set("X-Bz-File-Id", t.id)
+	h.Set("X-Bz-Content-Sha1", hex.EncodeToString(sum[:]))
+	h.Set("X-Bz-Upload-Timestamp", "0")
+	h.Set("Content-Length", strconv.Itoa(len(data)))
+	var body io.Reader = bytes.NewReader(data)
+	if t.failAfter > 0 {
+		body = io.MultiReader(io.LimitReader(body, int64(t.failAfter)), &errReader{errors.New("connection reset")})
+	}
+	return &http.Response{StatusCode: status, Header: h, Body: io.NopCloser(body), Request: req}, nil
+}
+
+type errReader struct{ err error }
+
+func (r *errReader) Read([]byte) (int, error) { return 0, r.err }
+
+func TestResumableDownload(t *testing.T) {
+	defer func(n int64) { resumeStateInterval = n }(resumeStateInterval)
+	resumeStateInterval = 10
+
+	ctx := context.Background()
+	data := bytes.Repeat([]byte("0123456789abcdef"), 7)
+	tr := &rangeTransport{id: "id1", data: data, failAfter: 35}
+	c := &Client{hc: &http.Client{Transport: tr}}
+	c.loginInfo.Store(&LoginInfo{DownloadURL: "https://download.example.com"})
+	dir := t.TempDir()
+	dest, state := filepath.Join(dir, "file"), filepath.Join(dir, "file.state")
+	o := DownloadOptions{Bucket: "bucket", FileName: "file"}
+
+	if _, err := c.ResumableDownload(ctx, o, dest, state); err == nil {
+		t.Fatal("interrupted download succeeded")
+	}
+	if _, err := os.Stat(state); err != nil {
+		t.Fatal(err)
+	}
+
+	tr.failAfter = 0
+	fi, err := c.ResumableDownload(ctx, o, dest, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("bytes=30-%d", len(data)-1); tr.ranges[1] != want {
+		t.Errorf("resumed with Range %q, want %q", tr.ranges[1], want)
+	}
+	if fi.ContentLength != int64(len(data)) {
+		t.Errorf("ContentLength %d, want %d", fi.ContentLength, len(data))
+	}
+	if got, err := os.ReadFile(dest); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("wrong contents %q: %v", got, err)
+	}
+	if _, err := os.Stat(state); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("state file not removed: %v", err)
+	}
+
+	tr.failAfter = 35
+	if _, err := c.ResumableDownload(ctx, o, dest, state); err == nil {
+		t.Fatal("interrupted download succeeded")
+	}
+	tr.id, tr.failAfter = "id2", 0
+	if _, err := c.ResumableDownload(ctx, o, dest, state); err != ErrDownloadChanged {
+		t.Errorf("got %v, want ErrDownloadChanged", err)
+	}
+}
@@ -0,0 +1,131 @@
+package b2
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// A RetryPolicy controls the delay before retrying a failed operation, like
+// an upload or an API call rejected with status 429 or 503.
+//
+// Delays grow exponentially with each attempt, with full jitter: the delay is
+// picked at random between zero and the exponential backoff, so that clients
+// failing at the same time don't retry at the same time. If B2 asks for a
+// delay with the Retry-After header, that delay is used instead, up to
+// MaxBackoff.
+type RetryPolicy struct {
+	// MinBackoff is the backoff of the first retry, doubled for each
+	// following one, up to MaxBackoff. If zero, 500ms and 30s are used.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// Rand, if set, is the source of jitter, for example seeded to make
+	// delays deterministic in tests. Otherwise the math/rand global source
+	// is used. It is safe to share a RetryPolicy between Clients.
+	Rand *rand.Rand
+
+	// PerAttemptTimeout, if positive, bounds each attempt of an API call
+	// or upload, so that a hung attempt is abandoned and retried instead of
+	// consuming the whole deadline of the context, which still bounds the
+	// total. An attempt that times out is retried like one rejected by B2.
+	PerAttemptTimeout time.Duration
+
+	randMu sync.Mutex // guards Rand, which is not safe for concurrent use
+}
+
+const (
+	defaultMinBackoff = 500 * time.Millisecond
+	defaultMaxBackoff = 30 * time.Second
+)
+
+// defaultRetryPolicy is used by Clients without a RetryPolicy.
+var defaultRetryPolicy = &RetryPolicy{}
+
+// Backoff returns the delay before the retry following the given failed
+// attempt, starting from 0.
+func (p *RetryPolicy) Backoff(attempt int) time.Duration {
+	minBackoff, maxBackoff := p.MinBackoff, p.maxBackoff()
+	if minBackoff <= 0 {
+		minBackoff = defaultMinBackoff
+	}
+	backoff := minBackoff
+	for i := 0; i < attempt && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+
+	if p.Rand == nil {
+		return time.Duration(rand.Int63n(int64(backoff) + 1))
+	}
+	p.randMu.Lock()
+	defer p.randMu.Unlock()
+	return time.Duration(p.Rand.Int63n(int64(backoff) + 1))
+}
+
+func (p *RetryPolicy) maxBackoff() time.Duration {
+	if p.MaxBackoff <= 0 {
+		return defaultMaxBackoff
+	}
+	return p.MaxBackoff
+}
+
+func (c *Client) retryPolicy() *RetryPolicy {
+	if c.RetryPolicy != nil {
+		return c.RetryPolicy
+	}
+	return defaultRetryPolicy
+}
+
+// attemptContext returns the context of a single attempt, which must be
+// canceled once the attempt is over.
+func (p *RetryPolicy) attemptContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if p.PerAttemptTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, p.PerAttemptTimeout)
+}
+
+// attemptTimedOut reports whether err is the expiration of an attempt
+// context, while ctx leaves time for another attempt.
+func attemptTimedOut(ctx context.Context, err error) bool {
+	return errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil
+}
+
+// retryDelay returns the delay before retrying an API call that failed with
+// err: the Retry-After delay requested by B2, if any, capped at MaxBackoff,
+// or the backoff.
+func (c *Client) retryDelay(err error, attempt int) time.Duration {
+	p := c.retryPolicy()
+	if e, ok := UnwrapError(err); ok && e.RetryAfter > 0 {
+		if max := p.maxBackoff(); e.RetryAfter > max {
+			return max
+		}
+		return e.RetryAfter
+	}
+	return p.Backoff(attempt)
+}
+
+// parseRetryAfter returns the delay of the Retry-After header, in seconds or
+// as an HTTP date, or zero if it's missing or invalid.
+func parseRetryAfter(h http.Header) time.Duration {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
@@ -0,0 +1,143 @@
+package b2
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// hangingTransport hangs the first requests until they are canceled, and
+// answers the following ones.
+type hangingTransport struct {
+	mu    sync.Mutex
+	hangs int
+	body  string
+}
+
+func (t *hangingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	hang := t.hangs > 0
+	t.hangs--
+	t.mu.Unlock()
+	if hang {
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(t.body)),
+		Request:    req,
+	}, nil
+}
+
+func TestPerAttemptTimeout(t *testing.T) {
+	c := &Client{
+		hc: &http.Client{Transport: &hangingTransport{hangs: 2, body: `{"bucketId": "id"}`}},
+		RetryPolicy: &RetryPolicy{
+			MinBackoff:        time.Millisecond,
+			PerAttemptTimeout: 50 * time.Millisecond,
+		},
+	}
+	c.loginInfo.Store(&LoginInfo{ApiURL: "https://api.example.com"})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := c.doRequest(ctx, "b2_get_bucket", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	// The attempt context must outlive doRequest, until the body is closed.
+	var bucket struct{ BucketID string }
+	if err := json.NewDecoder(res.Body).Decode(&bucket); err != nil || bucket.BucketID != "id" {
+		t.Errorf("bad response %+v: %v", bucket, err)
+	}
+
+	// The overall context still bounds the total.
+	c.hc.Transport = &hangingTransport{hangs: 100}
+	short, cancel := context.WithTimeout(context.Background(), 120*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := c.doRequest(short, "b2_get_bucket", nil); err == nil {
+		t.Error("hanging request succeeded")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("overall deadline ignored, took %v", d)
+	}
+}
+
+// throttledTransport answers the first requests with the given statuses, and
+// the following ones with body.
+type throttledTransport struct {
+	mu       sync.Mutex
+	statuses []int
+	requests int
+	body     string
+}
+
+func (t *throttledTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.requests++
+	res := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+		Body:       io.NopCloser(strings.NewReader(t.body)),
+		Request:    req,
+	}
+	if len(t.statuses) > 0 {
+		res.StatusCode, t.statuses = t.statuses[0], t.statuses[1:]
+		res.Header.Set("Retry-After", "1")
+		res.Body = io.NopCloser(strings.NewReader(`{"code": "too_many_requests", "message": "slow down"}`))
+	}
+	return res, nil
+}
+
+func TestAuthorizeRetry(t *testing.T) {
+	tr := &throttledTransport{
+		statuses: []int{http.StatusTooManyRequests, http.StatusServiceUnavailable},
+		body:     `{"accountId": "account", "apiUrl": "https://api.example.com", "authorizationToken": "token"}`,
+	}
+	// Retry-After asks for 1s, capped at MaxBackoff.
+	policy := &RetryPolicy{MinBackoff: time.Millisecond, MaxBackoff: 20 * time.Millisecond}
+	c := &Client{
+		hc:          &http.Client{Transport: tr},
+		RetryPolicy: policy,
+	}
+	start := time.Now()
+	if err := c.authorize(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if tr.requests != 3 {
+		t.Errorf("%d requests, expected 3", tr.requests)
+	}
+	if d := time.Since(start); d < 40*time.Millisecond || d > time.Second {
+		t.Errorf("Retry-After was not capped at MaxBackoff, took %v", d)
+	}
+	if li := c.loginInfo.Load().(*LoginInfo); li.AuthorizationToken != "token" {
+		t.Errorf("wrong LoginInfo %+v", li)
+	}
+
+	tr.statuses = []int{http.StatusUnauthorized}
+	if err := c.authorize(context.Background()); err == nil {
+		t.Error("unauthorized login succeeded")
+	}
+	if tr.requests != 4 {
+		t.Errorf("unauthorized login was retried")
+	}
+
+	tr.statuses = []int{http.StatusServiceUnavailable}
+	start = time.Now()
+	c, err := NewClientWithPolicy(context.Background(), "account", "key", &http.Client{Transport: tr}, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.RetryPolicy != policy || tr.requests != 6 || time.Since(start) > time.Second {
+		t.Errorf("initial login not retried with the policy: %d requests in %v", tr.requests, time.Since(start))
+	}
+}
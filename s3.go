@@ -0,0 +1,193 @@
+package b2
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DownloadS3 downloads the file key from the named bucket through the
+// S3-compatible API, at LoginInfo.S3ApiURL, for interoperability with S3
+// tooling or when the native download path is restricted.
+//
+// The request is signed with AWS Signature Version 4, using the application
+// key ID and application key of the Client as the access key pair, and the
+// region from the S3 endpoint host name, like "us-west-004" for
+// s3.us-west-004.backblazeb2.com. The bucket is addressed in the path.
+//
+// The returned FileInfo is built from the S3 response headers: ID is the
+// version ID, UploadTimestamp comes from Last-Modified, CustomMetadata from
+// the x-amz-meta-* headers, and ContentSHA1 is not set.
+//
+// If there is no such file, ErrFileNotFound is returned. Other S3 errors are
+// returned as an *Error, with the S3 error code.
+func (c *Client) DownloadS3(ctx context.Context, bucket, key string) (io.ReadCloser, *FileInfo, error) {
+	li := c.auth().loginInfo.Load().(*LoginInfo)
+	if li.S3ApiURL == "" {
+		return nil, nil, errors.New("no S3 API URL in the account authorization")
+	}
+	endpoint, err := url.Parse(li.S3ApiURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	region, err := s3Region(endpoint.Host)
+	if err != nil {
+		return nil, nil, err
+	}
+	path := "/" + s3Escape(bucket) + "/" + s3Escape(key)
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint.Scheme+"://"+endpoint.Host+path, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	a := c.auth()
+	a.loginMu.Lock()
+	keyID, secret := a.accountID, a.applicationKey
+	a.loginMu.Unlock()
+	signV4(req, path, keyID, secret, region, time.Now())
+
+	res, err := c.s3Client().Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		drainAndClose(res.Body)
+		return nil, nil, ErrFileNotFound
+	default:
+		return nil, nil, parseS3Error(res)
+	}
+	fi := &FileInfo{
+		ID:             res.Header.Get("X-Amz-Version-Id"),
+		Name:           key,
+		ContentType:    res.Header.Get("Content-Type"),
+		ContentLength:  res.ContentLength,
+		CustomMetadata: make(map[string]string),
+		Action:         FileUpload,
+	}
+	if t, err := http.ParseTime(res.Header.Get("Last-Modified")); err == nil {
+		fi.UploadTimestamp = t
+		fi.UploadTimestampMillis = t.UnixMilli()
+	}
+	for name := range res.Header {
+		if strings.HasPrefix(name, "X-Amz-Meta-") {
+			fi.CustomMetadata[strings.ToLower(name[len("X-Amz-Meta-"):])] = res.Header.Get(name)
+		}
+	}
+	return res.Body, fi, nil
+}
+
+// s3Client returns the HTTP client of c without the B2 transport, which adds
+// B2 headers to requests and parses errors as B2 JSON errors.
+func (c *Client) s3Client() *http.Client {
+	hc := *c.hc
+	if t, ok := hc.Transport.(*transport); ok {
+		hc.Transport = t.t
+	}
+	return &hc
+}
+
+// parseS3Error returns the S3 XML error in the body of res, like
+// <Error><Code>AccessDenied</Code><Message>...</Message></Error>, as an *Error.
+func parseS3Error(res *http.Response) error {
+	defer drainAndClose(res.Body)
+	var e struct {
+		Code    string
+		Message string
+	}
+	if err := xml.NewDecoder(io.LimitReader(res.Body, 1024*1024)).Decode(&e); err != nil || e.Code == "" {
+		return &Error{Code: "unknown", Message: "S3 error " + res.Status, Status: res.StatusCode}
+	}
+	return &Error{
+		Code:       e.Code,
+		Message:    e.Message,
+		Status:     res.StatusCode,
+		RetryAfter: parseRetryAfter(res.Header),
+	}
+}
+
+// s3Region returns the region of a B2 S3 endpoint host, like
+// s3.us-west-004.backblazeb2.com.
+func s3Region(host string) (string, error) {
+	parts := strings.Split(host, ".")
+	if len(parts) < 3 || parts[0] != "s3" {
+		return "", fmt.Errorf("unexpected S3 endpoint %q", host)
+	}
+	return parts[1], nil
+}
+
+// s3Escape URI-encodes s as required by Signature Version 4, and accepted in
+// B2 download URLs, leaving only the unreserved characters and "/" as is.
+func s3Escape(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			sb.WriteByte(c)
+		} else {
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
+
+// emptySHA256 is the hex SHA256 of an empty payload.
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// signV4 signs req, a GET request without query nor body for the escaped
+// path, with AWS Signature Version 4. The Host header and every header already
+// set on req are signed.
+func signV4(req *http.Request, path, keyID, secret, region string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptySHA256)
+
+	names := []string{"host"}
+	for name := range req.Header {
+		names = append(names, strings.ToLower(name))
+	}
+	sort.Strings(names)
+	var headers strings.Builder
+	for _, name := range names {
+		v := req.URL.Host
+		if name != "host" {
+			v = strings.TrimSpace(req.Header.Get(name))
+		}
+		headers.WriteString(name + ":" + v + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		"", // query
+		headers.String(),
+		signedHeaders,
+		emptySHA256,
+	}, "\n")
+	scope := day + "/" + region + "/s3/aws4_request"
+	digest := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(digest[:])
+
+	mac := func(key []byte, data string) []byte {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(data))
+		return h.Sum(nil)
+	}
+	signingKey := mac(mac(mac(mac([]byte("AWS4"+secret), day), region), "s3"), "aws4_request")
+	signature := hex.EncodeToString(mac(signingKey, stringToSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+keyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
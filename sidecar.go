@@ -0,0 +1,159 @@
+package b2
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// SidecarSuffix is appended to the name of a file to obtain the name of the
+// sidecar file holding its overflow metadata. See UploadWithSidecar.
+const SidecarSuffix = ".meta.json"
+
+// maxFileInfoKeys is the maximum number of custom metadata keys B2 stores
+// with a file.
+const maxFileInfoKeys = 10
+
+// UploadWithSidecar is like Upload, but accepts any number of metadata keys.
+//
+// Up to 10 keys are stored as regular B2 metadata: the reserved ones first,
+// like src_last_modified_millis and the b2-* keys, which B2 and other tools
+// act on, then the others in sorted order. The rest are stored as a JSON
+// object in a separate file named name+SidecarSuffix, which is only uploaded
+// if needed, after the file itself. The returned FileInfo has all the
+// metadata merged in CustomMetadata. Use DownloadOptions.SidecarMetadata to
+// merge it back on download.
+//
+// If the sidecar upload fails, the version of the file just uploaded is
+// deleted, so that it doesn't stand without part of its metadata, and the
+// error is returned. Uploading a file with few enough keys to not need a
+// sidecar hides the sidecar of its previous version, if any, so that its
+// keys are not merged into the new one.
+//
+// Each sidecar costs an extra upload transaction and its own storage, and
+// reading it back costs an extra download transaction. Looking for a stale
+// sidecar costs a list transaction. Deleting or hiding the file does not
+// touch its sidecar.
+func (b *Bucket) UploadWithSidecar(ctx context.Context, r io.Reader, name, mimeType string, metadata map[string]string) (*FileInfo, error) {
+	if len(metadata) <= maxFileInfoKeys {
+		fi, err := b.Upload(ctx, r, name, mimeType, metadata)
+		if err != nil {
+			return nil, err
+		}
+		if err := b.hideSidecar(ctx, name); err != nil {
+			return nil, err
+		}
+		return fi, nil
+	}
+
+	keys := make([]string, 0, len(metadata))
+	for k := range metadata {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if ri, rj := reservedKey(keys[i]), reservedKey(keys[j]); ri != rj {
+			return ri
+		}
+		return keys[i] < keys[j]
+	})
+	header := make(map[string]string, maxFileInfoKeys)
+	for _, k := range keys[:maxFileInfoKeys] {
+		header[k] = metadata[k]
+	}
+	sidecar := make(map[string]string, len(keys)-maxFileInfoKeys)
+	for _, k := range keys[maxFileInfoKeys:] {
+		sidecar[k] = metadata[k]
+	}
+	body, err := json.Marshal(sidecar)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := b.Upload(ctx, r, name, mimeType, header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := b.Upload(ctx, bytes.NewReader(body), name+SidecarSuffix, "application/json", nil); err != nil {
+		if derr := b.c.DeleteFile(ctx, fi.ID, fi.Name); derr != nil {
+			return nil, fmt.Errorf("uploading the sidecar: %w, and deleting the file failed: %v", err, derr)
+		}
+		return nil, fmt.Errorf("uploading the sidecar: %w", err)
+	}
+	for k, v := range sidecar {
+		fi.CustomMetadata[k] = v
+	}
+	return fi, nil
+}
+
+// reservedKey reports whether the metadata key k has a meaning for B2 or
+// for this package, and should be kept out of sidecars.
+func reservedKey(k string) bool {
+	k = strings.ToLower(k)
+	if strings.HasPrefix(k, "b2-") || k == "large_file_sha1" {
+		return true
+	}
+	for _, r := range reservedInfo {
+		if k == r.key {
+			return true
+		}
+	}
+	return false
+}
+
+// hideSidecar hides the sidecar of the file name, if there is one.
+func (b *Bucket) hideSidecar(ctx context.Context, name string) error {
+	_, err := b.GetFileInfoByName(ctx, name+SidecarSuffix)
+	if err == ErrFileNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, _, err = b.HideFileIfVisible(ctx, name+SidecarSuffix)
+	return err
+}
+
+// mergeSidecar downloads the sidecar of the file name in bucket, if any, and
+// merges its metadata into fi.
+func (c *Client) mergeSidecar(ctx context.Context, bucket, name string, fi *FileInfo) error {
+	if len(bucket) == 0 {
+		return errors.New("empty bucket name, required when using SidecarMetadata")
+	}
+	rc, _, err := c.DownloadFile(ctx, DownloadOptions{
+		Bucket:   bucket,
+		FileName: name + SidecarSuffix,
+	})
+	if e, ok := UnwrapError(err); ok && e.Status == http.StatusNotFound {
+		return nil
+	}
+	if err != nil {
+		if rc != nil {
+			rc.Close()
+		}
+		return err
+	}
+	defer drainAndClose(rc)
+	var sidecar map[string]string
+	if err := json.NewDecoder(rc).Decode(&sidecar); err != nil {
+		return err
+	}
+	for k, v := range sidecar {
+		fi.CustomMetadata[k] = v
+	}
+	return nil
+}
+
+// sidecarName returns the unescaped name of the downloaded file.
+func sidecarName(o DownloadOptions, fi *FileInfo) (string, error) {
+	if len(o.FileName) > 0 {
+		return o.FileName, nil
+	}
+	return url.QueryUnescape(fi.Name)
+}
@@ -0,0 +1,43 @@
+package b2
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// throttledReader limits the rate at which r is read to rate bytes per
+// second, on average since the first Read. Waits end early if ctx is done.
+type throttledReader struct {
+	ctx   context.Context
+	r     io.Reader
+	rate  int64
+	start time.Time
+	n     int64 // bytes read since start
+}
+
+func throttle(ctx context.Context, r io.Reader, bytesPerSec int64) io.Reader {
+	if bytesPerSec <= 0 {
+		return r
+	}
+	return &throttledReader{ctx: ctx, r: r, rate: bytesPerSec}
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if t.start.IsZero() {
+		t.start = time.Now()
+	}
+	// Read at most 100ms worth of bytes at once, to avoid bursts.
+	if burst := t.rate/10 + 1; int64(len(p)) > burst {
+		p = p[:burst]
+	}
+	n, err := t.r.Read(p)
+	t.n += int64(n)
+	due := t.start.Add(time.Duration(float64(t.n) / float64(t.rate) * float64(time.Second)))
+	if d := time.Until(due); d > 0 {
+		if err := sleep(t.ctx, d); err != nil {
+			return n, err
+		}
+	}
+	return n, err
+}
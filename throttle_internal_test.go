@@ -0,0 +1,34 @@
+package b2
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestThrottle(t *testing.T) {
+	ctx := context.Background()
+	data := make([]byte, 100*1000)
+
+	start := time.Now()
+	r := throttle(ctx, bytes.NewReader(data), 400*1000)
+	if n, err := io.Copy(io.Discard, r); err != nil || n != int64(len(data)) {
+		t.Fatal(n, err)
+	}
+	if d := time.Since(start); d < 200*time.Millisecond {
+		t.Errorf("read 100KB at 400KB/s in %v", d)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	r = throttle(ctx, bytes.NewReader(data), 1000)
+	if _, err := io.Copy(io.Discard, r); err != context.DeadlineExceeded {
+		t.Errorf("expected DeadlineExceeded, got %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("canceled read took %v", d)
+	}
+}
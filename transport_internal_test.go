@@ -0,0 +1,123 @@
+package b2
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeB2 is a RoundTripper answering like the B2 API, download and upload
+// hosts, recording the hosts it was asked for.
+type fakeB2 struct {
+	mu    sync.Mutex
+	hosts map[string]bool
+}
+
+func (f *fakeB2) RoundTrip(req *http.Request) (*http.Response, error) {
+	f.mu.Lock()
+	f.hosts[req.URL.Host] = true
+	f.mu.Unlock()
+
+	res := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Request: req}
+	var body string
+	switch {
+	case strings.HasSuffix(req.URL.Path, "/b2_authorize_account"):
+		body = `{"accountId": "account", "apiUrl": "https://api.example.com",
+			"downloadUrl": "https://download.example.com", "authorizationToken": "token"}`
+	case strings.HasSuffix(req.URL.Path, "/b2_get_upload_url"):
+		body = `{"uploadUrl": "https://upload.example.com/upload", "authorizationToken": "token"}`
+	case req.URL.Host == "upload.example.com":
+		body = `{"fileId": "id", "fileName": "name", "action": "upload"}`
+	case req.URL.Host == "download.example.com":
+		res.Header.Set("X-Bz-Upload-Timestamp", "0")
+		res.Header.Set("Content-Length", "4")
+		body = "data"
+	default:
+		res.StatusCode = http.StatusNotFound
+		body = `{"code": "not_found", "message": "unexpected request", "status": 404}`
+	}
+	res.Body = io.NopCloser(strings.NewReader(body))
+	return res, nil
+}
+
+func TestTransportReuse(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeB2{hosts: make(map[string]bool)}
+	c, err := NewClient(ctx, "account", "key", &http.Client{Transport: fake})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := c.BucketByID("bucket")
+	if _, err := b.Upload(ctx, strings.NewReader("data"), "name", "", nil); err != nil {
+		t.Fatal(err)
+	}
+	rc, _, err := c.DownloadFileByID(ctx, "id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc.Close()
+
+	for _, host := range []string{"api.backblaze.com", "api.example.com", "upload.example.com", "download.example.com"} {
+		if !fake.hosts[host] {
+			t.Errorf("request to %s did not go through the Client transport", host)
+		}
+	}
+}
+
+// headerRecorder records the headers of the requests to a host.
+type headerRecorder struct {
+	http.RoundTripper
+	host    string
+	headers []http.Header
+}
+
+func (r *headerRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host == r.host {
+		r.headers = append(r.headers, req.Header.Clone())
+	}
+	return r.RoundTripper.RoundTrip(req)
+}
+
+func TestWithSession(t *testing.T) {
+	ctx := context.Background()
+	api := &headerRecorder{RoundTripper: &fakeB2{hosts: make(map[string]bool)}, host: "api.example.com"}
+	rec := &headerRecorder{RoundTripper: api, host: "upload.example.com"}
+	c, err := NewClient(ctx, "account", "key", &http.Client{Transport: rec})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := c.WithSession("job-1")
+	s.SessionMetadataKey = "job-id"
+	s.TestMode = TestModeFailSomeUploads
+	if s.auth() != c || s.WithSession("job-2").auth() != c {
+		t.Error("session does not share the authorization of its Client")
+	}
+
+	if _, err := s.BucketByID("bucket").Upload(ctx, strings.NewReader("data"), "name", "", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.BucketByID("bucket").Upload(ctx, strings.NewReader("data"), "name", "", nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.headers) != 2 {
+		t.Fatalf("%d uploads, want 2", len(rec.headers))
+	}
+	if got := rec.headers[0].Get("X-Bz-Info-job-id"); got != "job-1" {
+		t.Errorf("session upload tagged %q, want job-1", got)
+	}
+	if got := rec.headers[1].Get("X-Bz-Info-job-id"); got != "" {
+		t.Errorf("upload outside the session tagged %q", got)
+	}
+	if got := rec.headers[0].Get("X-Bz-Test-Mode"); got != TestModeFailSomeUploads {
+		t.Errorf("session upload in test mode %q", got)
+	}
+	if got := rec.headers[1].Get("X-Bz-Test-Mode"); got != "" {
+		t.Errorf("upload outside the session in test mode %q", got)
+	}
+	if len(api.headers) != 1 {
+		t.Errorf("%d b2_get_upload_url calls, want the upload URL shared", len(api.headers))
+	}
+}
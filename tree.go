@@ -0,0 +1,518 @@
+package b2
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// A TreeAction is what UploadTree, Sync or DownloadTree did with a file.
+type TreeAction string
+
+const (
+	TreeUpload   TreeAction = "upload"   // The file was new or changed, and was uploaded.
+	TreeSkip     TreeAction = "skip"     // The file was already up to date.
+	TreeDelete   TreeAction = "delete"   // The file was deleted by Sync.
+	TreeDownload TreeAction = "download" // The file was new or changed, and was downloaded.
+)
+
+// UploadTreeOptions are the options of (*Bucket).UploadTree.
+type UploadTreeOptions struct {
+	// Concurrency is the number of files processed at the same time.
+	// If 0, 4 is used.
+	Concurrency int
+
+	// Progress, if set, is called after each file is processed, possibly
+	// from multiple goroutines at once. name is the remote file name.
+	Progress func(name string, action TreeAction, err error)
+
+	// ContentTypeMap maps lowercase file extensions, like ".wasm", to the
+	// Content-Type to upload them with. It takes precedence over the built-in
+	// table of common web types, which takes precedence over
+	// mime.TypeByExtension. Files with unknown extensions are uploaded
+	// with "b2/x-auto".
+	ContentTypeMap map[string]string
+
+	// PathNormalize controls how the relative local paths become file names.
+	// The zero value only converts the separators of the local system to
+	// forward slashes, keeping the case.
+	PathNormalize PathNormalize
+
+	// FS, if set, is read instead of the local file system, with localDir
+	// a slash-separated path in it, like "." for its root. Its files must
+	// implement io.Seeker, like those of os.DirFS and fstest.MapFS.
+	FS fs.FS
+}
+
+// PathNormalize controls how UploadTree and Sync turn the path of a local
+// file, relative to the local directory, into a file name, so that the same
+// tree gives the same names on every system. The rules apply in order:
+//
+//  1. The separators of the local system are converted to "/", like with
+//     filepath.ToSlash, as well as "\" on any system if Backslashes is set.
+//  2. If Lowercase is set, the path is lowercased, since file names are
+//     case-sensitive but some local file systems are not.
+//
+// Being relative to the local directory, like "site\index.html" for
+// C:\www\site\index.html under C:\www, the path never has a drive letter.
+// If two local files end up with the same name, the second one fails.
+type PathNormalize struct {
+	Backslashes bool
+	Lowercase   bool
+}
+
+// Key returns the file name, relative to the remote prefix, for the local
+// path p, see PathNormalize.
+func (n PathNormalize) Key(p string) string {
+	p = filepath.ToSlash(p)
+	if n.Backslashes {
+		p = strings.ReplaceAll(p, "\\", "/")
+	}
+	if n.Lowercase {
+		p = strings.ToLower(p)
+	}
+	return p
+}
+
+// webContentTypes are the Content-Types of common web files, some of which are
+// missing from the system tables used by mime.TypeByExtension.
+var webContentTypes = map[string]string{
+	".avif":        "image/avif",
+	".css":         "text/css; charset=utf-8",
+	".gif":         "image/gif",
+	".htm":         "text/html; charset=utf-8",
+	".html":        "text/html; charset=utf-8",
+	".ico":         "image/vnd.microsoft.icon",
+	".jpeg":        "image/jpeg",
+	".jpg":         "image/jpeg",
+	".js":          "text/javascript; charset=utf-8",
+	".json":        "application/json",
+	".map":         "application/json",
+	".md":          "text/markdown; charset=utf-8",
+	".mjs":         "text/javascript; charset=utf-8",
+	".mp3":         "audio/mpeg",
+	".mp4":         "video/mp4",
+	".otf":         "font/otf",
+	".pdf":         "application/pdf",
+	".png":         "image/png",
+	".svg":         "image/svg+xml",
+	".ttf":         "font/ttf",
+	".txt":         "text/plain; charset=utf-8",
+	".wasm":        "application/wasm",
+	".webm":        "video/webm",
+	".webmanifest": "application/manifest+json",
+	".webp":        "image/webp",
+	".woff":        "font/woff",
+	".woff2":       "font/woff2",
+	".xml":         "text/xml; charset=utf-8",
+}
+
+// contentType returns the Content-Type to upload name with, or "" to let B2
+// detect it.
+func (o *UploadTreeOptions) contentType(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if ext == "" {
+		return ""
+	}
+	if t, ok := o.ContentTypeMap[ext]; ok {
+		return t
+	}
+	if t, ok := webContentTypes[ext]; ok {
+		return t
+	}
+	return mime.TypeByExtension(ext)
+}
+
+// UploadTreeSummary counts the files processed by UploadTree.
+type UploadTreeSummary struct {
+	Uploaded, Skipped, Failed int
+}
+
+// UploadTree uploads every regular file under the local directory localDir,
+// or the directory localDir of UploadTreeOptions.FS, naming it remotePrefix
+// followed by its slash-separated path relative to localDir, see
+// UploadTreeOptions.PathNormalize. The modification time of
+// each file is stored as src_last_modified_millis.
+//
+// Files whose latest version in the bucket has the same SHA1 are skipped. To
+// find them, the files under remotePrefix are listed first, and each local
+// file is read once to compute its SHA1, and once more if it's uploaded.
+//
+// UploadTree does not stop when a file fails to upload: it returns the first
+// error once all the files are processed.
+func (b *Bucket) UploadTree(ctx context.Context, localDir, remotePrefix string, o UploadTreeOptions) (UploadTreeSummary, error) {
+	var (
+		mu       sync.Mutex
+		sum      UploadTreeSummary
+		firstErr error
+	)
+	err := b.syncTree(ctx, localDir, remotePrefix, o, false, func(name string, action TreeAction, err error) {
+		mu.Lock()
+		switch {
+		case err != nil:
+			sum.Failed++
+			if firstErr == nil {
+				firstErr = err
+			}
+		case action == TreeSkip:
+			sum.Skipped++
+		default:
+			sum.Uploaded++
+		}
+		mu.Unlock()
+	})
+	if err != nil {
+		return sum, err
+	}
+	return sum, firstErr
+}
+
+// SyncOptions are the options of (*Bucket).Sync.
+type SyncOptions struct {
+	UploadTreeOptions
+
+	// Delete, if true, deletes all the versions of the files under the
+	// remote prefix that don't exist in the local directory.
+	Delete bool
+}
+
+// A SyncAction is an action taken by Sync on a file.
+type SyncAction struct {
+	Name   string // the remote file name
+	Action TreeAction
+	Err    error
+}
+
+// Sync is like UploadTree, but also deletes the remote files that don't exist
+// locally anymore if o.Delete is true, like rsync --delete. Deletions only
+// happen if the whole local directory was read successfully.
+//
+// Sync returns every action taken, in no particular order, and the first
+// error encountered.
+func (b *Bucket) Sync(ctx context.Context, localDir, remotePrefix string, o SyncOptions) ([]SyncAction, error) {
+	var (
+		mu       sync.Mutex
+		actions  []SyncAction
+		firstErr error
+	)
+	err := b.syncTree(ctx, localDir, remotePrefix, o.UploadTreeOptions, o.Delete, func(name string, action TreeAction, err error) {
+		mu.Lock()
+		actions = append(actions, SyncAction{Name: name, Action: action, Err: err})
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		mu.Unlock()
+	})
+	if err != nil {
+		return actions, err
+	}
+	return actions, firstErr
+}
+
+// syncTree implements UploadTree and Sync. report is called for each file,
+// possibly concurrently, before o.Progress.
+func (b *Bucket) syncTree(ctx context.Context, localDir, remotePrefix string, o UploadTreeOptions, deleteExtraneous bool, report func(name string, action TreeAction, err error)) error {
+	fsys, root := o.FS, path.Clean(localDir)
+	if fsys == nil {
+		fsys, root = os.DirFS(localDir), "."
+	}
+	remote, err := b.listTree(ctx, remotePrefix)
+	if err != nil {
+		return err
+	}
+
+	type job struct{ path, name string } // no path means delete
+	jobs := make(chan job)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency(o.Concurrency); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				var action TreeAction
+				var err error
+				if j.path == "" {
+					action, err = TreeDelete, b.deleteAllVersions(ctx, j.name)
+				} else {
+					action, err = b.uploadTreeFile(ctx, fsys, j.path, j.name, o.contentType(j.name), remote[j.name])
+				}
+				report(j.name, action, err)
+				if o.Progress != nil {
+					o.Progress(j.name, action, err)
+				}
+			}
+		}()
+	}
+	send := func(j job) error {
+		select {
+		case jobs <- j:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	local := make(map[string]bool)
+	err = fs.WalkDir(fsys, root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		rel := path
+		if root != "." {
+			rel = strings.TrimPrefix(path, root+"/")
+		}
+		name := remotePrefix + o.PathNormalize.Key(rel)
+		if local[name] {
+			err := fmt.Errorf("%s: file name %s already used by another local file", path, name)
+			report(name, TreeUpload, err)
+			if o.Progress != nil {
+				o.Progress(name, TreeUpload, err)
+			}
+			return nil
+		}
+		local[name] = true
+		return send(job{path: path, name: name})
+	})
+	if err == nil && deleteExtraneous {
+		for name := range remote {
+			if local[name] {
+				continue
+			}
+			if err = send(job{name: name}); err != nil {
+				break
+			}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return err
+}
+
+// concurrency returns n, or the default concurrency if n is not positive.
+func concurrency(n int) int {
+	if n <= 0 {
+		return 4
+	}
+	return n
+}
+
+// listTree returns the latest version of each file under prefix, by name.
+func (b *Bucket) listTree(ctx context.Context, prefix string) (map[string]*FileInfo, error) {
+	files := make(map[string]*FileInfo)
+	l := b.ListFiles(ctx, ListOptions{Prefix: prefix})
+	l.SetPageCount(maxCount)
+	for l.Next() {
+		fi := l.FileInfo()
+		files[fi.Name] = fi
+	}
+	return files, l.Err()
+}
+
+// uploadTreeFile uploads the file path of fsys as name, unless remote has
+// the same SHA1.
+func (b *Bucket) uploadTreeFile(ctx context.Context, fsys fs.FS, path, name, contentType string, remote *FileInfo) (TreeAction, error) {
+	file, err := fsys.Open(path)
+	if err != nil {
+		return TreeUpload, err
+	}
+	defer file.Close()
+	f, ok := file.(io.ReadSeeker)
+	if !ok {
+		return TreeUpload, fmt.Errorf("%s: file does not implement io.Seeker", path)
+	}
+	st, err := file.Stat()
+	if err != nil {
+		return TreeUpload, err
+	}
+
+	h := sha1.New()
+	length, err := io.Copy(h, f)
+	if err != nil {
+		return TreeUpload, err
+	}
+	sha1Sum := hex.EncodeToString(h.Sum(nil))
+	if remote != nil && remote.ContentSHA1 == sha1Sum {
+		return TreeSkip, nil
+	}
+
+	_, err = b.uploadSeeker(ctx, f, UploadOptions{
+		Name:         name,
+		ContentType:  contentType,
+		LastModified: st.ModTime(),
+	}, sha1Sum, length)
+	return TreeUpload, err
+}
+
+// DownloadTreeOptions are the options of (*Bucket).DownloadTree.
+type DownloadTreeOptions struct {
+	// Concurrency is the number of files processed at the same time.
+	// If 0, 4 is used.
+	Concurrency int
+
+	// Progress, if set, is called after each file is processed, possibly
+	// from multiple goroutines at once. name is the remote file name.
+	Progress func(name string, action TreeAction, err error)
+}
+
+// DownloadTreeSummary counts the files processed by DownloadTree.
+type DownloadTreeSummary struct {
+	Downloaded, Skipped, Failed int
+}
+
+// DownloadTree is the inverse of UploadTree: it downloads every file under
+// remotePrefix to the local directory localDir, at its slash-separated path
+// relative to remotePrefix, creating directories as needed. If the file has
+// a src_last_modified_millis, it's used as the local modification time.
+//
+// Local files with the same SHA1 as the remote ones are skipped. Downloads
+// are written to a temporary file, verified against the SHA1 reported by B2
+// (for large files, only if it has a large_file_sha1), and renamed in place.
+// Files whose name would escape localDir, like "prefix/../../x", fail.
+//
+// DownloadTree does not stop when a file fails to download: it returns the
+// first error once all the files are processed.
+func (b *Bucket) DownloadTree(ctx context.Context, remotePrefix, localDir string, o DownloadTreeOptions) (DownloadTreeSummary, error) {
+	var (
+		mu       sync.Mutex
+		sum      DownloadTreeSummary
+		firstErr error
+	)
+	jobs := make(chan *FileInfo)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency(o.Concurrency); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for fi := range jobs {
+				action, err := b.downloadTreeFile(ctx, fi, remotePrefix, localDir)
+				mu.Lock()
+				switch {
+				case err != nil:
+					sum.Failed++
+					if firstErr == nil {
+						firstErr = err
+					}
+				case action == TreeSkip:
+					sum.Skipped++
+				default:
+					sum.Downloaded++
+				}
+				mu.Unlock()
+				if o.Progress != nil {
+					o.Progress(fi.Name, action, err)
+				}
+			}
+		}()
+	}
+
+	var err error
+	l := b.ListFiles(ctx, ListOptions{Prefix: remotePrefix})
+	l.SetPageCount(maxCount)
+list:
+	for l.Next() {
+		select {
+		case jobs <- l.FileInfo():
+		case <-ctx.Done():
+			err = ctx.Err()
+			break list
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	if err == nil {
+		err = l.Err()
+	}
+	if err != nil {
+		return sum, err
+	}
+	return sum, firstErr
+}
+
+// localPath returns the path in localDir of the file name under prefix, or
+// an error if it would escape localDir.
+func localPath(localDir, prefix, name string) (string, error) {
+	rel := strings.TrimPrefix(name, prefix)
+	for _, elem := range strings.Split(rel, "/") {
+		if elem == "" || elem == "." || elem == ".." || strings.Contains(elem, `\`) {
+			return "", fmt.Errorf("unsafe file name %q for a local path", name)
+		}
+	}
+	path := filepath.Join(localDir, filepath.FromSlash(rel))
+	if r, err := filepath.Rel(localDir, path); err != nil || filepath.IsAbs(r) || strings.HasPrefix(r, "..") {
+		return "", fmt.Errorf("unsafe file name %q for a local path", name)
+	}
+	return path, nil
+}
+
+// ErrSHA1Mismatch is returned when the contents of a downloaded file don't
+// match the SHA1 reported by B2.
+var ErrSHA1Mismatch = errors.New("b2: downloaded file SHA1 mismatch")
+
+// checkSHA1 compares the sum of h with the SHA1 reported by B2, if known.
+func checkSHA1(h hash.Hash, sha1Sum string) error {
+	sha1Sum = strings.TrimPrefix(sha1Sum, "unverified:")
+	if sha1Sum == "" || sha1Sum == "none" {
+		return nil
+	}
+	if hex.EncodeToString(h.Sum(nil)) != sha1Sum {
+		return ErrSHA1Mismatch
+	}
+	return nil
+}
+
+// fileSHA1 returns the hex SHA1 of the local file path.
+func fileSHA1(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha1.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// downloadTreeFile downloads fi to its local path, unless a file with the
+// same SHA1 is already there.
+func (b *Bucket) downloadTreeFile(ctx context.Context, fi *FileInfo, prefix, localDir string) (TreeAction, error) {
+	path, err := localPath(localDir, prefix, fi.Name)
+	if err != nil {
+		return TreeDownload, err
+	}
+	if sha1Sum, err := fileSHA1(path); err == nil && sha1Sum == fi.ContentSHA1 {
+		return TreeSkip, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return TreeDownload, err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".b2-download-*")
+	if err != nil {
+		return TreeDownload, err
+	}
+	defer os.Remove(f.Name()) // fails harmlessly after the rename
+	_, err = b.c.DownloadVerified(ctx, DownloadOptions{FileInfo: fi}, f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return TreeDownload, err
+	}
+	if t, ok := fi.LastModified(); ok {
+		os.Chtimes(f.Name(), t, t)
+	}
+	return TreeDownload, os.Rename(f.Name(), path)
+}
@@ -0,0 +1,57 @@
+package b2
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestUploadTreeFS(t *testing.T) {
+	ctx := context.Background()
+	tr := &largeUploadTransport{}
+	var names []string
+	c, err := NewClient(ctx, "account", "key", &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if strings.HasSuffix(req.URL.Path, "/b2_list_file_names") {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{},
+				Body:       io.NopCloser(strings.NewReader(`{"files": [], "nextFileName": null}`)),
+				Request:    req,
+			}, nil
+		}
+		if req.URL.Host == "upload.example.com" {
+			name, _ := url.PathUnescape(req.Header.Get("X-Bz-File-Name"))
+			tr.mu.Lock()
+			names = append(names, name)
+			tr.mu.Unlock()
+		}
+		return tr.RoundTrip(req)
+	})})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fsys := fstest.MapFS{
+		"www/Site/Index.HTML":   {Data: []byte("index"), ModTime: time.Unix(1, 0)},
+		"www/Site\\css\\A.css":  {Data: []byte("css")},
+		"www/Site/index.html":   {Data: []byte("duplicate once lowercased")},
+		"other/not-uploaded.md": {Data: []byte("outside localDir")},
+	}
+	sum, err := c.BucketByID("bucket").UploadTree(ctx, "www", "web/", UploadTreeOptions{
+		FS:            fsys,
+		PathNormalize: PathNormalize{Backslashes: true, Lowercase: true},
+	})
+	if err == nil || sum.Uploaded != 2 || sum.Failed != 1 {
+		t.Errorf("got %+v, %v, want the duplicate name to fail", sum, err)
+	}
+	sort.Strings(names)
+	if want := []string{"web/site/css/a.css", "web/site/index.html"}; strings.Join(names, " ") != strings.Join(want, " ") {
+		t.Errorf("uploaded %q, want %q", names, want)
+	}
+}
@@ -0,0 +1,223 @@
+package b2_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kardianos/b2"
+)
+
+// writeTree creates the given files, by slash-separated path, under a new
+// temporary directory.
+func writeTree(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// deleteAll deletes every file version in the bucket.
+func deleteAll(t *testing.T, c *b2.Client, b *b2.BucketInfo) {
+	ctx := context.Background()
+	l := b.ListFileVersions(ctx, b2.ListOptions{})
+	for l.Next() {
+		fi := l.FileInfo()
+		if err := c.DeleteFile(ctx, fi.ID, fi.Name); err != nil {
+			t.Error(err)
+		}
+	}
+	if err := l.Err(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUploadTree(t *testing.T) {
+	ctx := context.Background()
+	c := getClient(t, ctx)
+	b := getBucket(t, ctx, c)
+	defer deleteBucket(t, b)
+	defer deleteAll(t, c, b)
+
+	dir := writeTree(t, map[string]string{
+		"index.html":   "<html></html>",
+		"css/site.css": "body {}",
+	})
+	sum, err := b.UploadTree(ctx, dir, "site/", b2.UploadTreeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != (b2.UploadTreeSummary{Uploaded: 2}) {
+		t.Errorf("first upload: %+v", sum)
+	}
+	fi, err := b.GetFileInfoByName(ctx, "site/css/site.css")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.CustomMetadata["src_last_modified_millis"] == "" {
+		t.Error("missing src_last_modified_millis")
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "index.html"), []byte("<html>new</html>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sum, err = b.UploadTree(ctx, dir, "site/", b2.UploadTreeOptions{Concurrency: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != (b2.UploadTreeSummary{Uploaded: 1, Skipped: 1}) {
+		t.Errorf("second upload: %+v", sum)
+	}
+}
+
+func TestPathNormalize(t *testing.T) {
+	for _, tt := range []struct {
+		n    b2.PathNormalize
+		path string
+		want string
+	}{
+		{b2.PathNormalize{}, "Site/Index.HTML", "Site/Index.HTML"},
+		{b2.PathNormalize{Backslashes: true}, `site\css\main.css`, "site/css/main.css"},
+		{b2.PathNormalize{Lowercase: true}, "Site/Index.HTML", "site/index.html"},
+		{b2.PathNormalize{Backslashes: true, Lowercase: true}, `Site\A.txt`, "site/a.txt"},
+	} {
+		if got := tt.n.Key(tt.path); got != tt.want {
+			t.Errorf("%+v.Key(%q) = %q, want %q", tt.n, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestUploadTreeContentType(t *testing.T) {
+	ctx := context.Background()
+	c := getClient(t, ctx)
+	b := getBucket(t, ctx, c)
+	defer deleteBucket(t, b)
+	defer deleteAll(t, c, b)
+
+	dir := writeTree(t, map[string]string{
+		"app.wasm":    "\x00asm",
+		"font.WOFF2":  "wOF2",
+		"data.custom": "custom",
+		"README":      "no extension",
+	})
+	_, err := b.UploadTree(ctx, dir, "", b2.UploadTreeOptions{
+		ContentTypeMap: map[string]string{".custom": "application/x-custom"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"app.wasm":    "application/wasm",
+		"font.WOFF2":  "font/woff2",
+		"data.custom": "application/x-custom",
+		"README":      "application/octet-stream",
+	} {
+		fi, err := b.GetFileInfoByName(ctx, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.ContentType != want {
+			t.Errorf("%s: Content-Type is %q, expected %q", name, fi.ContentType, want)
+		}
+	}
+}
+
+func TestSync(t *testing.T) {
+	ctx := context.Background()
+	c := getClient(t, ctx)
+	b := getBucket(t, ctx, c)
+	defer deleteBucket(t, b)
+	defer deleteAll(t, c, b)
+
+	dir := writeTree(t, map[string]string{
+		"a.txt": "a",
+		"b.txt": "b",
+	})
+	if _, err := b.Sync(ctx, dir, "", b2.SyncOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "b.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	actions, err := b.Sync(ctx, dir, "", b2.SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actions) != 1 || actions[0].Action != b2.TreeSkip {
+		t.Errorf("sync without Delete: %+v", actions)
+	}
+	if _, err := b.GetFileInfoByName(ctx, "b.txt"); err != nil {
+		t.Error("b.txt deleted without Delete:", err)
+	}
+
+	actions, err = b.Sync(ctx, dir, "", b2.SyncOptions{Delete: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actions) != 2 {
+		t.Errorf("sync with Delete: %+v", actions)
+	}
+	if _, err := b.GetFileInfoByName(ctx, "b.txt"); err != b2.ErrFileNotFound {
+		t.Error("b.txt not deleted:", err)
+	}
+}
+
+func TestDownloadTree(t *testing.T) {
+	ctx := context.Background()
+	c := getClient(t, ctx)
+	b := getBucket(t, ctx, c)
+	defer deleteBucket(t, b)
+	defer deleteAll(t, c, b)
+
+	files := map[string]string{
+		"index.html":   "<html></html>",
+		"css/site.css": "body {}",
+	}
+	if _, err := b.UploadTree(ctx, writeTree(t, files), "site/", b2.UploadTreeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	sum, err := b.DownloadTree(ctx, "site/", dir, b2.DownloadTreeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != (b2.DownloadTreeSummary{Downloaded: 2}) {
+		t.Errorf("first download: %+v", sum)
+	}
+	for name, content := range files {
+		got, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != content {
+			t.Errorf("%s: got %q, expected %q", name, got, content)
+		}
+	}
+
+	sum, err = b.DownloadTree(ctx, "site/", dir, b2.DownloadTreeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != (b2.DownloadTreeSummary{Skipped: 2}) {
+		t.Errorf("second download: %+v", sum)
+	}
+
+	if _, err := b.Upload(ctx, bytes.NewReader(nil), "site/../../evil", "", nil); err != nil {
+		t.Fatal(err)
+	}
+	sum, err = b.DownloadTree(ctx, "site/", dir, b2.DownloadTreeOptions{})
+	if err == nil || sum.Failed != 1 {
+		t.Errorf("path traversal not rejected: %+v %v", sum, err)
+	}
+}
@@ -3,12 +3,20 @@ package b2
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
 	"crypto/sha1"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 )
 
 // Upload uploads a file to a B2 bucket. If mimeType is "", "b2/x-auto" will be used.
@@ -23,65 +31,559 @@ import (
 // (like *os.File and *bytes.Reader), the file will be read twice, once to compute
 // the SHA1 and once to upload.
 //
+// Files larger than Client.LargeFileThreshold, 100MB by default, are uploaded
+// as large files instead, in parts of the LoginInfo.RecommendedPartSize: with
+// UploadLarge if r is a bytes.Buffer or an io.Seeker, or otherwise with
+// UploadStream, once more than the threshold was buffered. Either way, the
+// returned FileInfo is the same as for a single upload, with the SHA1 of the
+// whole file. That SHA1 is also stored in the large_file_sha1 metadata key,
+// if there is room for it, except for large files uploaded from a stream,
+// whose SHA1 is only known at the end: B2 reports it as "none".
+//
+// A bytes.Buffer is consumed only if the upload succeeds; on failure, its
+// contents are left untouched.
+//
 // If a file by this name already exist, a new version will be created.
 func (b *Bucket) Upload(ctx context.Context, r io.Reader, name, mimeType string, metadata map[string]string) (*FileInfo, error) {
+	return b.UploadFile(ctx, r, UploadOptions{
+		Name:        name,
+		ContentType: mimeType,
+		Metadata:    metadata,
+	})
+}
+
+// UploadOptions are the options of (*Bucket).UploadFile.
+type UploadOptions struct {
+	// Name of the file, required.
+	Name string
+
+	// ContentType is the MIME type of the file. If "", "b2/x-auto" will be used.
+	ContentType string
+
+	// Metadata is stored as custom file info. B2 allows at most 10 keys,
+	// including the ones set by the fields below.
+	//
+	// The reserved keys set by the fields below can also be set in Metadata,
+	// but not both ways at once. Metadata keys spelled like the plain HTTP
+	// headers, like "Cache-Control", are rejected: B2 would store them as
+	// unrelated custom keys.
+	Metadata map[string]string
+
+	// LastModified is stored as the src_last_modified_millis file info key.
+	LastModified time.Time
+
+	// The following fields are stored as the reserved b2-* file info keys,
+	// and B2 sends them back as the corresponding HTTP headers on download.
+	ContentDisposition string    // b2-content-disposition
+	ContentLanguage    string    // b2-content-language
+	Expires            time.Time // b2-expires
+	CacheControl       string    // b2-cache-control, like "max-age=3600"
+	ContentEncoding    string    // b2-content-encoding, like "gzip"
+
+	// ContentMD5, if true, makes UploadFile and UploadLarge compute the MD5
+	// of the file in the same pass as the SHA1, and store it hex encoded in
+	// the content-md5 file info key, for systems that expect an MD5, like
+	// the ETags of S3 inventories.
+	ContentMD5 bool
+	contentMD5 string
+
+	// VerifyAfterUpload, if true, makes UploadFile and UploadLarge check
+	// that the SHA1 reported by B2 for the uploaded file is the one computed
+	// before uploading it. On mismatch, the uploaded version is deleted, and
+	// an error matching ErrUploadSHA1Mismatch is returned. B2 has no SHA1 of
+	// its own for large files, so their parts are checked instead, before
+	// finishing the file, which is canceled on mismatch.
+	VerifyAfterUpload bool
+
+	// InvalidKeys sets what happens to Metadata keys B2 doesn't accept.
+	InvalidKeys InvalidKeyPolicy
+
+	// MaxBytesPerSec, if positive, limits the upload bandwidth, for
+	// example to keep background uploads from saturating the network.
+	MaxBytesPerSec int64
+
+	// ExtraHeaders are added to the b2_upload_file request, like
+	// "X-Bz-Test-Mode". The headers set by the package, and X-Bz-Info-*,
+	// which must be set with Metadata, are rejected. They are not sent with
+	// the parts of large files.
+	ExtraHeaders http.Header
+}
+
+// An InvalidKeyPolicy decides what to do with file info keys that B2 would
+// reject. Keys must be at most 50 bytes long, and made only of ASCII letters,
+// digits, "-" and "_".
+type InvalidKeyPolicy int
+
+const (
+	// RejectInvalidKeys fails the upload with an error naming the key.
+	RejectInvalidKeys InvalidKeyPolicy = iota
+	// SanitizeInvalidKeys replaces every invalid character with "_", and
+	// truncates long keys. Keys that end up identical are still rejected.
+	SanitizeInvalidKeys
+)
+
+// maxInfoKeyLength is the maximum length of a file info key, in bytes.
+const maxInfoKeyLength = 50
+
+func validInfoKeyChar(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_'
+}
+
+// key returns the key to store for the metadata key k, according to p.
+func (p InvalidKeyPolicy) key(k string) (string, error) {
+	if len(k) == 0 {
+		return "", errors.New("empty metadata key")
+	}
+	valid := len(k) <= maxInfoKeyLength
+	for i := 0; i < len(k) && valid; i++ {
+		valid = validInfoKeyChar(k[i])
+	}
+	if valid {
+		return k, nil
+	}
+	if p != SanitizeInvalidKeys {
+		return "", fmt.Errorf("invalid metadata key %q: keys must be at most %d letters, digits, - or _", k, maxInfoKeyLength)
+	}
+	var sb strings.Builder
+	for _, r := range k {
+		if r < utf8.RuneSelf && validInfoKeyChar(byte(r)) {
+			sb.WriteRune(r)
+		} else {
+			sb.WriteByte('_')
+		}
+		if sb.Len() == maxInfoKeyLength {
+			break
+		}
+	}
+	return sb.String(), nil
+}
+
+// uploadHeaders are the request headers set by uploadWithSHA1.
+var uploadHeaders = map[string]bool{
+	"Authorization":     true,
+	"Content-Length":    true,
+	"Content-Type":      true,
+	"X-Bz-Content-Sha1": true,
+	"X-Bz-File-Name":    true,
+}
+
+// checkExtraHeaders returns an error if ExtraHeaders would overwrite a header
+// set by the package.
+func (o *UploadOptions) checkExtraHeaders() error {
+	for k := range o.ExtraHeaders {
+		ck := http.CanonicalHeaderKey(k)
+		if uploadHeaders[ck] || strings.HasPrefix(ck, "X-Bz-Info-") {
+			return fmt.Errorf("extra header %q is reserved", k)
+		}
+	}
+	return nil
+}
+
+// maxFileNameLength is the maximum length of a file name, in bytes.
+const maxFileNameLength = 1024
+
+// ValidateFileName checks name against the B2 rules for file names, so that
+// invalid names can be rejected without a request. Names must be valid UTF-8,
+// at most 1024 bytes long, must not contain control characters, DEL or
+// backslashes, and must not start or end with "/" nor contain "//".
+func ValidateFileName(name string) error {
+	invalid := func(reason string) error {
+		return fmt.Errorf("invalid file name %q: %s", name, reason)
+	}
+	switch {
+	case len(name) == 0:
+		return invalid("empty")
+	case len(name) > maxFileNameLength:
+		return invalid(fmt.Sprintf("%d bytes long, the maximum is %d", len(name), maxFileNameLength))
+	case !utf8.ValidString(name):
+		return invalid("not valid UTF-8")
+	case strings.HasPrefix(name, "/"):
+		return invalid("starts with /")
+	case strings.HasSuffix(name, "/"):
+		return invalid("ends with /")
+	case strings.Contains(name, "//"):
+		return invalid("contains //")
+	}
+	for _, r := range name {
+		switch {
+		case r < 32:
+			return invalid(fmt.Sprintf("contains control character %#x", r))
+		case r == 127:
+			return invalid("contains DEL")
+		case r == '\\':
+			return invalid("contains a backslash")
+		}
+	}
+	return nil
+}
+
+// maxInfoHeaderBytes is the B2 limit on the total size of the X-Bz-Info-*
+// and X-Bz-File-Name headers of an upload.
+const maxInfoHeaderBytes = 7000
+
+// ErrMetadataTooLarge is returned, as a *MetadataTooLargeError, by uploads
+// whose file info and name would not fit in the headers of the request.
+var ErrMetadataTooLarge = errors.New("b2: file info too large")
+
+// MetadataTooLargeError reports the size of the headers of a rejected upload.
+type MetadataTooLargeError struct {
+	// Size is the total size, in bytes, of the names and percent-encoded
+	// values of the X-Bz-Info-* and X-Bz-File-Name headers.
+	Size int
+}
+
+func (e *MetadataTooLargeError) Error() string {
+	return fmt.Sprintf("b2: file info too large (%d bytes of headers, the maximum is %d)", e.Size, maxInfoHeaderBytes)
+}
+
+// Is makes MetadataTooLargeError match ErrMetadataTooLarge.
+func (e *MetadataTooLargeError) Is(target error) bool {
+	return target == ErrMetadataTooLarge
+}
+
+// encodeInfoValue percent-encodes a file info value for an X-Bz-Info-* header.
+func encodeInfoValue(v string) string {
+	return strings.ReplaceAll(url.QueryEscape(v), "+", "%20")
+}
+
+// infoHeaders returns the X-Bz-File-Name and X-Bz-Info-* headers of an
+// upload, or a *MetadataTooLargeError if they exceed the B2 limit.
+func infoHeaders(name string, info map[string]string) (http.Header, error) {
+	h := make(http.Header, len(info)+1)
+	h.Set("X-Bz-File-Name", url.QueryEscape(name))
+	for k, v := range info {
+		h.Set("X-Bz-Info-"+k, encodeInfoValue(v))
+	}
+	size := 0
+	for k, v := range h {
+		size += len(k) + len(v[0])
+	}
+	if size > maxInfoHeaderBytes {
+		return nil, &MetadataTooLargeError{Size: size}
+	}
+	return h, nil
+}
+
+// reservedInfo lists the file info keys backed by UploadOptions fields.
+var reservedInfo = []struct {
+	key, header, field string
+}{
+	{"src_last_modified_millis", "", "LastModified"},
+	{"b2-content-disposition", "content-disposition", "ContentDisposition"},
+	{"b2-content-language", "content-language", "ContentLanguage"},
+	{"b2-expires", "expires", "Expires"},
+	{"b2-cache-control", "cache-control", "CacheControl"},
+	{"b2-content-encoding", "content-encoding", "ContentEncoding"},
+	{"content-md5", "", "ContentMD5"},
+}
+
+// fileInfo returns the file info to store with the file, or an error if a
+// reserved key is set both in Metadata and by its field.
+func (o *UploadOptions) fileInfo() (map[string]string, error) {
+	fields := make(map[string]string, len(reservedInfo))
+	set := func(k, v string) {
+		if len(v) > 0 {
+			fields[k] = v
+		}
+	}
+	if !o.LastModified.IsZero() {
+		set("src_last_modified_millis", strconv.FormatInt(o.LastModified.UnixMilli(), 10))
+	}
+	set("b2-content-disposition", o.ContentDisposition)
+	set("b2-content-language", o.ContentLanguage)
+	if !o.Expires.IsZero() {
+		set("b2-expires", o.Expires.UTC().Format(http.TimeFormat))
+	}
+	set("b2-cache-control", o.CacheControl)
+	set("b2-content-encoding", o.ContentEncoding)
+	if o.ContentMD5 {
+		// Set even before the MD5 is computed, to detect conflicts.
+		fields["content-md5"] = o.contentMD5
+	}
+
+	info := make(map[string]string, len(o.Metadata)+len(fields))
+	for k, v := range o.Metadata {
+		sk, err := o.InvalidKeys.key(k)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := info[sk]; ok {
+			return nil, fmt.Errorf("metadata key %q collides with another key once sanitized", k)
+		}
+		lk := strings.ToLower(k)
+		for _, r := range reservedInfo {
+			switch lk {
+			case r.header:
+				return nil, fmt.Errorf("metadata key %q is not a reserved key, use UploadOptions.%s", k, r.field)
+			case r.key:
+				if _, ok := fields[r.key]; ok {
+					return nil, fmt.Errorf("metadata key %q conflicts with UploadOptions.%s", k, r.field)
+				}
+			}
+		}
+		info[sk] = v
+	}
+	for k, v := range fields {
+		info[k] = v
+	}
+	return info, nil
+}
+
+// fileInfo is like o.fileInfo, adding the session ID of the Client, see
+// Client.SessionMetadataKey.
+func (b *Bucket) fileInfo(o *UploadOptions) (map[string]string, error) {
+	info, err := o.fileInfo()
+	if err != nil {
+		return nil, err
+	}
+	return b.c.sessionInfo(info), nil
+}
+
+// UploadFile is like Upload, but takes all the file attributes from o.
+func (b *Bucket) UploadFile(ctx context.Context, r io.Reader, o UploadOptions) (*FileInfo, error) {
+	if err := ValidateFileName(o.Name); err != nil {
+		return nil, err
+	}
+	info, err := b.fileInfo(&o)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := infoHeaders(o.Name, info); err != nil {
+		return nil, err
+	}
+	if err := o.checkExtraHeaders(); err != nil {
+		return nil, err
+	}
+	name := o.Name
 	var body io.ReadSeeker
+	var buf *bytes.Buffer
 	switch r := r.(type) {
 	case *bytes.Buffer:
-		defer r.Reset() // we are expected to consume it
+		buf = r
 		body = bytes.NewReader(r.Bytes())
 	case io.ReadSeeker:
 		body = r
 	default:
-		debugf("upload %s: buffering", name)
-		b, err := io.ReadAll(r)
+		b.c.debugf("upload %s: buffering", name)
+		threshold := b.c.largeFileThreshold()
+		data, err := io.ReadAll(io.LimitReader(r, threshold+1))
 		if err != nil {
 			return nil, err
 		}
-		body = bytes.NewReader(b)
+		if int64(len(data)) > threshold {
+			if !o.ContentMD5 {
+				return b.uploadPromoted(ctx, data, r, o)
+			}
+			rest, err := io.ReadAll(r)
+			if err != nil {
+				return nil, err
+			}
+			data = append(data, rest...)
+		}
+		body = bytes.NewReader(data)
+	}
+	if o.ContentType, err = b.c.detectContentType(name, o.ContentType, body); err != nil {
+		return nil, err
 	}
 
-	h := sha1.New()
-	length, err := io.Copy(h, body)
+	size, err := body.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if size > b.c.largeFileThreshold() {
+		partSize, err := b.largeFilePartSize(ctx)
+		if err != nil {
+			return nil, err
+		}
+		fi, err := b.UploadLarge(ctx, body, o, LargeUploadOptions{
+			PartSize:      partSize,
+			LargeFileSHA1: len(info) < maxFileInfoKeys,
+		})
+		if err == nil && buf != nil {
+			buf.Reset()
+		}
+		return fi, err
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	h, m := sha1.New(), md5.New()
+	var w io.Writer = h
+	if o.ContentMD5 {
+		w = io.MultiWriter(h, m)
+	}
+	length, err := io.Copy(w, body)
 	if err != nil {
 		return nil, err
 	}
 	sha1Sum := hex.EncodeToString(h.Sum(nil))
+	if o.ContentMD5 {
+		o.contentMD5 = hex.EncodeToString(m.Sum(nil))
+	}
+	fi, err := b.uploadSeeker(ctx, body, o, sha1Sum, length)
+	if err == nil && buf != nil {
+		// We are expected to consume it, but only once it's safely
+		// uploaded, so that the caller can try again on failure.
+		buf.Reset()
+	}
+	return fi, err
+}
+
+// maxSingleFileSize is the largest file b2_upload_file accepts. Unlike the
+// part sizes, b2_authorize_account doesn't report it in the LoginInfo.
+const maxSingleFileSize = 5 * 1000 * 1000 * 1000
+
+// defaultLargeFileThreshold is the Client.LargeFileThreshold set by NewClient.
+const defaultLargeFileThreshold = 100 * 1000 * 1000
+
+// largeFileThreshold returns the size above which UploadFile uploads a large
+// file. See Client.LargeFileThreshold.
+func (c *Client) largeFileThreshold() int64 {
+	if c.LargeFileThreshold <= 0 || c.LargeFileThreshold > maxSingleFileSize {
+		return maxSingleFileSize
+	}
+	return c.LargeFileThreshold
+}
+
+// largeFilePartSize returns the part size of the large files uploaded by
+// UploadFile: the recommended one, lowered if needed for files just over
+// the threshold to have more than one part.
+func (b *Bucket) largeFilePartSize(ctx context.Context) (int64, error) {
+	li, err := b.c.LoginInfo(ctx, false)
+	if err != nil {
+		return 0, err
+	}
+	partSize := li.RecommendedPartSize
+	if t := b.c.largeFileThreshold(); t < partSize {
+		partSize = t
+		if partSize < li.AbsoluteMinimumPartSize {
+			partSize = li.AbsoluteMinimumPartSize
+		}
+	}
+	return partSize, nil
+}
+
+// uploadPromoted uploads the rest of r, whose head was read by UploadFile
+// and is over the large file threshold, with UploadStream. The SHA1 of the
+// whole file is computed on the way, for the returned FileInfo to have it
+// like the other uploads, even though B2 doesn't know it.
+func (b *Bucket) uploadPromoted(ctx context.Context, head []byte, r io.Reader, o UploadOptions) (*FileInfo, error) {
+	var err error
+	if o.ContentType, err = b.c.detectContentType(o.Name, o.ContentType, bytes.NewReader(head)); err != nil {
+		return nil, err
+	}
+	partSize, err := b.largeFilePartSize(ctx)
+	if err != nil {
+		return nil, err
+	}
+	h := sha1.New()
+	h.Write(head)
+	fi, err := b.UploadStream(ctx, io.MultiReader(bytes.NewReader(head), io.TeeReader(r, h)), o, LargeUploadOptions{PartSize: partSize})
+	if err != nil {
+		return nil, err
+	}
+	if fi.ContentSHA1 == "none" {
+		fi.ContentSHA1 = hex.EncodeToString(h.Sum(nil))
+	}
+	return fi, nil
+}
 
+// uploadSeeker uploads body, whose SHA1 and length are known, retrying on
+// failure like Upload.
+func (b *Bucket) uploadSeeker(ctx context.Context, body io.ReadSeeker, o UploadOptions, sha1Sum string, length int64) (*FileInfo, error) {
 	var fi *FileInfo
-	for i := 0; i < 5; i++ {
+	err := b.c.retryUpload(ctx, func(ctx context.Context) (err error) {
 		if _, err = body.Seek(0, io.SeekStart); err != nil {
-			return nil, err
+			return err
 		}
+		fi, err = b.uploadWithSHA1(ctx, body, o, sha1Sum, length, nil)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return b.verifyUpload(ctx, &o, fi, sha1Sum)
+}
+
+// ErrUploadSHA1Mismatch is returned when the SHA1 reported by B2 for a file
+// uploaded with UploadOptions.VerifyAfterUpload doesn't match the contents
+// that were sent.
+var ErrUploadSHA1Mismatch = errors.New("b2: uploaded file SHA1 mismatch")
+
+// verifyUpload checks, if o.VerifyAfterUpload is set, that fi, just uploaded,
+// has the SHA1 sha1Sum, and deletes it otherwise.
+func (b *Bucket) verifyUpload(ctx context.Context, o *UploadOptions, fi *FileInfo, sha1Sum string) (*FileInfo, error) {
+	if !o.VerifyAfterUpload || fi.ContentSHA1 == sha1Sum {
+		return fi, nil
+	}
+	err := fmt.Errorf("%w: %s has SHA1 %s, want %s", ErrUploadSHA1Mismatch, fi.Name, fi.ContentSHA1, sha1Sum)
+	if derr := b.c.DeleteFile(ctx, fi.ID, fi.Name); derr != nil {
+		return nil, fmt.Errorf("%w, and deleting it failed: %v", err, derr)
+	}
+	return nil, err
+}
 
-		fi, err = b.UploadWithSHA1(ctx, body, name, mimeType, sha1Sum, length, metadata)
+// retryUpload calls upload until it succeeds, up to 5 times, logging in
+// again when the upload URL authorization is expired, and otherwise waiting
+// between attempts according to the RetryPolicy. Each attempt gets its own
+// context, bounded by the PerAttemptTimeout of the RetryPolicy.
+func (c *Client) retryUpload(ctx context.Context, upload func(ctx context.Context) error) error {
+	var err error
+	for i := 0; i < 5; i++ {
+		actx, cancel := c.retryPolicy().attemptContext(ctx)
+		err = upload(actx)
+		cancel()
 		if err == nil {
-			break
+			return nil
 		}
 		if err, ok := UnwrapError(err); ok && err.Status == http.StatusUnauthorized {
 			// We are forced to pass nil to login, risking a double login (which is
 			// wasteful, but not harmful) because the API does not give us access to
 			// the failed response (without hacks).
-			if err := b.c.login(ctx, nil); err != nil {
-				return nil, err
+			if err := c.login(ctx, nil); err != nil {
+				return err
 			}
 			i--
+			continue
+		}
+		if i < 4 {
+			if err := sleep(ctx, c.retryPolicy().Backoff(i)); err != nil {
+				return err
+			}
 		}
 	}
-	return fi, err
+	return err
 }
 
 type uploadURL struct {
 	UploadURL, AuthorizationToken string
 }
 
+// uploadURLPool holds the upload URLs of a bucket not in use.
+type uploadURLPool struct {
+	mu   sync.Mutex
+	urls []*uploadURL
+}
+
+// uploadURLs returns the pool of upload URLs of b, shared by all the Buckets
+// with the same ID of its Client and of the sessions of that Client.
+func (b *Bucket) uploadURLs() *uploadURLPool {
+	p, _ := b.c.auth().uploadURLs.LoadOrStore(b.ID, &uploadURLPool{})
+	return p.(*uploadURLPool)
+}
+
+// getUploadURL takes an upload URL from the pool, or gets a new one if the
+// pool is empty. The lock is not held during b2_get_upload_url, so that a
+// burst of uploads fetches the URLs they need concurrently.
 func (b *Bucket) getUploadURL(ctx context.Context) (u *uploadURL, err error) {
-	b.uploadURLsMu.Lock()
-	if len(b.uploadURLs) > 0 {
-		u = b.uploadURLs[len(b.uploadURLs)-1]
-		b.uploadURLs = b.uploadURLs[:len(b.uploadURLs)-1]
+	p := b.uploadURLs()
+	p.mu.Lock()
+	if len(p.urls) > 0 {
+		u = p.urls[len(p.urls)-1]
+		p.urls = p.urls[:len(p.urls)-1]
 	}
-	b.uploadURLsMu.Unlock()
+	p.mu.Unlock()
 	if u != nil {
 		return
 	}
@@ -98,9 +600,10 @@ func (b *Bucket) getUploadURL(ctx context.Context) (u *uploadURL, err error) {
 }
 
 func (b *Bucket) putUploadURL(u *uploadURL) {
-	b.uploadURLsMu.Lock()
-	defer b.uploadURLsMu.Unlock()
-	b.uploadURLs = append(b.uploadURLs, u)
+	p := b.uploadURLs()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.urls = append(p.urls, u)
 }
 
 // UploadWithSHA1 is like Upload, but allows the caller to specify previously
@@ -113,33 +616,144 @@ func (b *Bucket) putUploadURL(u *uploadURL) {
 //
 // sha1Sum should be the hex encoding of the SHA1 sum of what will be read from r.
 //
+// If the percent-encoded metadata and file name add up to more than the
+// 7000 bytes of headers B2 accepts, a *MetadataTooLargeError is returned
+// before anything is sent.
+//
 // This is an advanced interface, most clients should use Upload, and consider
 // passing it a bytes.Buffer or io.ReadSeeker to avoid buffering.
 func (b *Bucket) UploadWithSHA1(ctx context.Context, r io.Reader, name, mimeType, sha1Sum string, length int64, metadata map[string]string) (*FileInfo, error) {
+	return b.uploadWithSHA1(ctx, r, UploadOptions{
+		Name:        name,
+		ContentType: mimeType,
+		Metadata:    metadata,
+	}, sha1Sum, length, nil)
+}
+
+// detectContentType returns contentType, or if it's "", the type picked by
+// DetectContentType from the start of r, which it rewinds.
+func (c *Client) detectContentType(name, contentType string, r io.ReadSeeker) (string, error) {
+	if contentType != "" || c.DetectContentType == nil {
+		return contentType, nil
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return c.DetectContentType(name, head[:n]), nil
+}
+
+// sniffLen is the number of bytes passed to Client.DetectContentType.
+const sniffLen = 512
+
+// UploadDetail describes how an upload was performed, for diagnostics.
+type UploadDetail struct {
+	// UploadHost is the host of the upload URL the file was sent to, or
+	// "" if the upload failed before getting one.
+	UploadHost string
+}
+
+// UploadWithSHA1Detailed is like UploadWithSHA1, but also returns the
+// UploadDetail of the upload, even when it fails, for example to report the
+// upload host to Backblaze support.
+func (b *Bucket) UploadWithSHA1Detailed(ctx context.Context, r io.Reader, name, mimeType, sha1Sum string, length int64, metadata map[string]string) (*FileInfo, *UploadDetail, error) {
+	d := &UploadDetail{}
+	fi, err := b.uploadWithSHA1(ctx, r, UploadOptions{
+		Name:        name,
+		ContentType: mimeType,
+		Metadata:    metadata,
+	}, sha1Sum, length, d)
+	return fi, d, err
+}
+
+// UploadWithSHA1Retry is like UploadWithSHA1, but retries on failure like
+// Upload. To be able to send the file again, it first reads all of r into a
+// memory buffer of length bytes, so it's only suitable for small files: larger
+// ones should be uploaded from an io.ReadSeeker, with Upload or UploadLarge.
+// Unlike Upload, the SHA1 of the file is not computed.
+func (b *Bucket) UploadWithSHA1Retry(ctx context.Context, r io.Reader, name, mimeType, sha1Sum string, length int64, metadata map[string]string) (*FileInfo, error) {
+	o := UploadOptions{
+		Name:        name,
+		ContentType: mimeType,
+		Metadata:    metadata,
+	}
+	if err := ValidateFileName(name); err != nil {
+		return nil, err
+	}
+	info, err := b.fileInfo(&o)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := infoHeaders(name, info); err != nil {
+		return nil, err
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("invalid length %d", length)
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return b.uploadSeeker(ctx, bytes.NewReader(buf), o, sha1Sum, length)
+}
+
+// uploadWithSHA1 uploads r once, filling d, if not nil.
+func (b *Bucket) uploadWithSHA1(ctx context.Context, r io.Reader, o UploadOptions, sha1Sum string, length int64, d *UploadDetail) (*FileInfo, error) {
+	name, mimeType := o.Name, o.ContentType
+	if err := ValidateFileName(name); err != nil {
+		return nil, err
+	}
+	if mimeType == "" {
+		mimeType = "b2/x-auto"
+	}
+	info, err := b.fileInfo(&o)
+	if err != nil {
+		return nil, err
+	}
+	headers, err := infoHeaders(name, info)
+	if err != nil {
+		return nil, err
+	}
+	if err := o.checkExtraHeaders(); err != nil {
+		return nil, err
+	}
 	uurl, err := b.getUploadURL(ctx)
 	if err != nil {
 		return nil, err
 	}
 
+	r = throttle(ctx, r, o.MaxBytesPerSec)
 	req, err := http.NewRequestWithContext(ctx, "POST", uurl.UploadURL, io.NopCloser(r))
 	if err != nil {
 		return nil, err
 	}
 	req.ContentLength = length
+	if d != nil {
+		d.UploadHost = req.URL.Host
+	}
+	for k, v := range o.ExtraHeaders {
+		req.Header[http.CanonicalHeaderKey(k)] = v
+	}
 	req.Header.Set("Authorization", uurl.AuthorizationToken)
-	req.Header.Set("X-Bz-File-Name", url.QueryEscape(name))
 	req.Header.Set("Content-Type", mimeType)
 	req.Header.Set("X-Bz-Content-Sha1", sha1Sum)
-	for k, v := range metadata {
-		req.Header.Set("X-Bz-Info-"+k, v)
+	for k, v := range headers {
+		req.Header[k] = v
 	}
 
 	res, err := b.c.hc.Do(req)
 	if err != nil {
-		debugf("upload %s: %s", name, err)
+		b.c.debugf("upload %s: %s", name, err)
 		return nil, err
 	}
-	debugf("upload %s (%d %s)", name, length, sha1Sum)
+	b.c.debugf("upload %s (%d %s)", name, length, sha1Sum)
 	defer drainAndClose(res.Body)
 
 	fi := fileInfoObj{}
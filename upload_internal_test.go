@@ -0,0 +1,306 @@
+package b2
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowTransport answers every request after a delay, like a distant server.
+type slowTransport struct {
+	delay time.Duration
+	body  string
+}
+
+func (t *slowTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	time.Sleep(t.delay)
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(t.body)),
+		Request:    req,
+	}, nil
+}
+
+// BenchmarkUploadURLStartup measures how long a burst of 16 uploads takes to
+// get upload URLs from an empty pool, with a 10ms round-trip. The URLs are
+// fetched concurrently, so it takes about 10ms, not 160ms.
+func BenchmarkUploadURLStartup(b *testing.B) {
+	c := &Client{hc: &http.Client{Transport: &slowTransport{
+		delay: 10 * time.Millisecond,
+		body:  `{"uploadUrl": "https://upload.example.com", "authorizationToken": "token"}`,
+	}}}
+	c.loginInfo.Store(&LoginInfo{ApiURL: "https://api.example.com"})
+	ctx := context.Background()
+
+	for i := 0; i < b.N; i++ {
+		bucket := c.BucketByID("bucket")
+		var wg sync.WaitGroup
+		for j := 0; j < 16; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := bucket.getUploadURL(ctx); err != nil {
+					b.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+	}
+}
+
+func TestDetectContentType(t *testing.T) {
+	ctx := context.Background()
+	rec := &headerRecorder{RoundTripper: &fakeB2{hosts: make(map[string]bool)}, host: "upload.example.com"}
+	c, err := NewClient(ctx, "account", "key", &http.Client{Transport: rec})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var heads []string
+	c.DetectContentType = func(name string, head []byte) string {
+		heads = append(heads, string(head))
+		if strings.HasPrefix(string(head), "MAGIC") {
+			return "application/x-magic"
+		}
+		return ""
+	}
+	b := c.BucketByID("bucket")
+	long := "MAGIC" + strings.Repeat("x", 1000)
+	for _, body := range []io.Reader{strings.NewReader(long), io.LimitReader(strings.NewReader("plain"), 5)} {
+		if _, err := b.Upload(ctx, body, "name", "", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := b.Upload(ctx, strings.NewReader(long), "name", "text/plain", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(heads) != 2 || heads[0] != long[:512] || heads[1] != "plain" {
+		t.Errorf("detector called with %q", heads)
+	}
+	var got []string
+	for _, h := range rec.headers {
+		got = append(got, h.Get("Content-Type"))
+	}
+	if want := []string{"application/x-magic", "b2/x-auto", "text/plain"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("uploaded with content types %q, want %q", got, want)
+	}
+}
+
+// largeUploadTransport answers like B2 for single and large file uploads,
+// with tiny part sizes, recording the API calls.
+type largeUploadTransport struct {
+	mu    sync.Mutex
+	calls []string
+	info  map[string]string // of the started large file
+	size  int64             // of the uploaded parts
+
+	// corrupt makes the SHA1 of the uploaded files and parts wrong.
+	corrupt  bool
+	deleted  []string // IDs
+	canceled []string // IDs of large files
+}
+
+// sha1 returns the SHA1 to report for a file uploaded with sum.
+func (t *largeUploadTransport) sha1(sum string) string {
+	if t.corrupt {
+		return strings.Repeat("0", 40)
+	}
+	return sum
+}
+
+func (t *largeUploadTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	name := req.URL.Host + req.URL.Path
+	t.calls = append(t.calls, name)
+	res := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Request: req}
+	var body string
+	switch {
+	case strings.HasSuffix(name, "/b2_authorize_account"):
+		body = `{"accountId": "account", "apiUrl": "https://api.example.com", "authorizationToken": "token",
+			"recommendedPartSize": 20, "absoluteMinimumPartSize": 5}`
+	case strings.HasSuffix(name, "/b2_get_upload_url"):
+		body = `{"uploadUrl": "https://upload.example.com/upload", "authorizationToken": "token"}`
+	case strings.HasSuffix(name, "/b2_get_upload_part_url"):
+		body = `{"uploadUrl": "https://upload.example.com/part", "authorizationToken": "token"}`
+	case name == "upload.example.com/upload":
+		body = fmt.Sprintf(`{"fileId": "id", "fileName": "name", "action": "upload",
+			"contentLength": %d, "contentSha1": %q}`, req.ContentLength, t.sha1(req.Header.Get("X-Bz-Content-Sha1")))
+	case name == "upload.example.com/part":
+		t.size += req.ContentLength
+		body = fmt.Sprintf(`{"fileId": "large", "partNumber": %s, "contentLength": %d, "contentSha1": %q}`,
+			req.Header.Get("X-Bz-Part-Number"), req.ContentLength, t.sha1(req.Header.Get("X-Bz-Content-Sha1")))
+	case strings.HasSuffix(name, "/b2_start_large_file"):
+		var params struct {
+			FileInfo map[string]string `json:"fileInfo"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&params); err != nil {
+			return nil, err
+		}
+		t.info, t.size = params.FileInfo, 0
+		body = `{"fileId": "large", "fileName": "name", "action": "start"}`
+	case strings.HasSuffix(name, "/b2_finish_large_file"):
+		info, _ := json.Marshal(t.info)
+		body = fmt.Sprintf(`{"fileId": "large", "fileName": "name", "action": "upload",
+			"contentLength": %d, "contentSha1": "none", "fileInfo": %s}`, t.size, info)
+	case strings.HasSuffix(name, "/b2_delete_file_version"):
+		var params struct {
+			FileID string `json:"fileId"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&params); err != nil {
+			return nil, err
+		}
+		t.deleted = append(t.deleted, params.FileID)
+		body = `{}`
+	case strings.HasSuffix(name, "/b2_cancel_large_file"):
+		var params struct {
+			FileID string `json:"fileId"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&params); err != nil {
+			return nil, err
+		}
+		t.canceled = append(t.canceled, params.FileID)
+		body = `{"fileId": "large", "fileName": "name"}`
+	default:
+		res.StatusCode = http.StatusNotFound
+		body = `{"code": "not_found", "message": "unexpected request", "status": 404}`
+	}
+	res.Body = io.NopCloser(strings.NewReader(body))
+	return res, nil
+}
+
+func TestLargeFileThreshold(t *testing.T) {
+	ctx := context.Background()
+	tr := &largeUploadTransport{}
+	c, err := NewClient(ctx, "account", "key", &http.Client{Transport: tr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.LargeFileThreshold = 30
+	b := c.BucketByID("bucket")
+
+	for _, tt := range []struct {
+		desc  string
+		r     io.Reader
+		size  int
+		large bool
+	}{
+		{"small seekable", strings.NewReader(strings.Repeat("a", 30)), 30, false},
+		{"small stream", io.LimitReader(strings.NewReader(strings.Repeat("a", 30)), 30), 30, false},
+		{"large seekable", strings.NewReader(strings.Repeat("a", 50)), 50, true},
+		{"large stream", io.LimitReader(strings.NewReader(strings.Repeat("a", 50)), 50), 50, true},
+	} {
+		tr.calls = nil
+		fi, err := b.Upload(ctx, tt.r, "name", "", nil)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.desc, err)
+		}
+		large := strings.Contains(strings.Join(tr.calls, " "), "b2_start_large_file")
+		if large != tt.large {
+			t.Errorf("%s: large file is %v, want %v", tt.desc, large, tt.large)
+		}
+		sum := sha1.Sum([]byte(strings.Repeat("a", tt.size)))
+		if fi.ContentLength != int64(tt.size) || fi.ContentSHA1 != hex.EncodeToString(sum[:]) || fi.Action != FileUpload {
+			t.Errorf("%s: got %+v", tt.desc, fi)
+		}
+	}
+}
+
+func TestLargeFileThresholdLimit(t *testing.T) {
+	for threshold, want := range map[int64]int64{
+		0:                      maxSingleFileSize,
+		-1:                     maxSingleFileSize,
+		7 * 1000 * 1000 * 1000: maxSingleFileSize,
+		maxSingleFileSize:      maxSingleFileSize,
+		30:                     30,
+	} {
+		c := &Client{LargeFileThreshold: threshold}
+		if got := c.largeFileThreshold(); got != want {
+			t.Errorf("LargeFileThreshold %d: got threshold %d, want %d", threshold, got, want)
+		}
+	}
+
+	// Files just over the threshold still get more than one part of at
+	// least the minimum size of the account.
+	tr := &largeUploadTransport{}
+	c, err := NewClient(context.Background(), "account", "key", &http.Client{Transport: tr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for threshold, want := range map[int64]int64{0: 20, 30: 20, 10: 10, 1: 5} {
+		c.LargeFileThreshold = threshold
+		if got, err := c.BucketByID("bucket").largeFilePartSize(context.Background()); err != nil || got != want {
+			t.Errorf("LargeFileThreshold %d: got part size %d, %v, want %d", threshold, got, err, want)
+		}
+	}
+}
+
+func TestVerifyAfterUpload(t *testing.T) {
+	ctx := context.Background()
+	tr := &largeUploadTransport{corrupt: true}
+	c, err := NewClient(ctx, "account", "key", &http.Client{Transport: tr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.LargeFileThreshold = 30
+	b := c.BucketByID("bucket")
+
+	for _, tt := range []struct {
+		size   int
+		stream bool
+		id     string // of the bad upload
+	}{{10, false, "id"}, {50, false, "large"}, {50, true, "large"}} {
+		tr.deleted, tr.canceled = nil, nil
+		data := strings.Repeat("a", tt.size)
+		if _, err := b.UploadFile(ctx, strings.NewReader(data), UploadOptions{Name: "name"}); err != nil {
+			t.Errorf("%d bytes without verification: %v", tt.size, err)
+		}
+		var r io.Reader = strings.NewReader(data)
+		if tt.stream {
+			r = io.LimitReader(r, int64(tt.size))
+		}
+		_, err := b.UploadFile(ctx, r, UploadOptions{Name: "name", VerifyAfterUpload: true})
+		if !errors.Is(err, ErrUploadSHA1Mismatch) {
+			t.Errorf("%d bytes, stream %v: got %v, want ErrUploadSHA1Mismatch", tt.size, tt.stream, err)
+		}
+		if got := append(tr.deleted, tr.canceled...); len(got) != 1 || got[0] != tt.id {
+			t.Errorf("%d bytes, stream %v: deleted %q and canceled %q, want %s", tt.size, tt.stream, tr.deleted, tr.canceled, tt.id)
+		}
+	}
+
+	tr.corrupt = false
+	tr.deleted, tr.canceled = nil, nil
+	for _, r := range []io.Reader{strings.NewReader(strings.Repeat("a", 50)), io.LimitReader(strings.NewReader(strings.Repeat("a", 50)), 50)} {
+		if _, err := b.UploadFile(ctx, r, UploadOptions{Name: "name", VerifyAfterUpload: true}); err != nil {
+			t.Error(err)
+		}
+	}
+	if len(tr.deleted) != 0 || len(tr.canceled) != 0 {
+		t.Errorf("deleted %q and canceled %q after good uploads", tr.deleted, tr.canceled)
+	}
+}
+
+func TestReservedKey(t *testing.T) {
+	for k, want := range map[string]bool{
+		"src_last_modified_millis": true,
+		"b2-cache-control":         true,
+		"B2-Expires":               true,
+		"large_file_sha1":          true,
+		"content-md5":              true,
+		"author":                   false,
+		"b2x":                      false,
+	} {
+		if got := reservedKey(k); got != want {
+			t.Errorf("reservedKey(%q) = %v, want %v", k, got, want)
+		}
+	}
+}
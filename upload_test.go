@@ -2,11 +2,21 @@ package b2_test
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/md5"
 	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
 	"io"
+	"net/http"
 	"os"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/kardianos/b2"
 )
 
 func TestUploadError(t *testing.T) {
@@ -107,3 +117,311 @@ func TestUploadReader(t *testing.T) {
 		t.Error("Reader is not empty")
 	}
 }
+
+func TestUploadOptions(t *testing.T) {
+	ctx := context.Background()
+	c := getClient(t, ctx)
+	b := getBucket(t, ctx, c)
+	defer deleteBucket(t, b)
+
+	fi, err := b.UploadFile(ctx, bytes.NewReader([]byte("data")), b2.UploadOptions{
+		Name:               "foo-file",
+		ContentType:        "text/plain",
+		Metadata:           map[string]string{"foo": "bar", "json": `{"a": "é+b%"}`},
+		CacheControl:       "max-age=3600",
+		ContentDisposition: "attachment",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteFile(ctx, fi.ID, fi.Name)
+
+	fi, err = c.GetFileInfoByID(ctx, fi.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := fi.CustomMetadata["b2-cache-control"]; v != "max-age=3600" {
+		t.Error("mismatched b2-cache-control:", v)
+	}
+	if v := fi.CustomMetadata["b2-content-disposition"]; v != "attachment" {
+		t.Error("mismatched b2-content-disposition:", v)
+	}
+	if v := fi.CustomMetadata["foo"]; v != "bar" {
+		t.Error("mismatched foo:", v)
+	}
+	if v := fi.CustomMetadata["json"]; v != `{"a": "é+b%"}` {
+		t.Error("mismatched json:", v)
+	}
+}
+
+func TestUploadMetadataTooLarge(t *testing.T) {
+	ctx := context.Background()
+	b := (&b2.Client{}).BucketByID("unused") // fails before any API call
+
+	// About 4000 bytes of JSON, which percent-encode to over 9000.
+	blob := strings.Repeat(`{"":1}`, 4000/6)
+	_, err := b.UploadFile(ctx, bytes.NewReader(nil), b2.UploadOptions{
+		Name:     "foo-file",
+		Metadata: map[string]string{"blob": blob},
+	})
+	var e *b2.MetadataTooLargeError
+	if !errors.Is(err, b2.ErrMetadataTooLarge) || !errors.As(err, &e) {
+		t.Fatalf("expected ErrMetadataTooLarge, got %v", err)
+	}
+	if e.Size <= 7000 {
+		t.Errorf("wrong size %d", e.Size)
+	}
+	_, err = b.UploadWithSHA1(ctx, bytes.NewReader(nil), "foo-file", "", "", 0, map[string]string{"blob": blob})
+	if !errors.Is(err, b2.ErrMetadataTooLarge) {
+		t.Errorf("UploadWithSHA1: expected ErrMetadataTooLarge, got %v", err)
+	}
+}
+
+func TestUploadReservedMetadata(t *testing.T) {
+	ctx := context.Background()
+	b := (&b2.Client{}).BucketByID("unused") // fails before any API call
+
+	for _, tt := range []struct {
+		o   b2.UploadOptions
+		err string
+	}{
+		{b2.UploadOptions{
+			Metadata: map[string]string{"Content-Disposition": "attachment"},
+		}, "use UploadOptions.ContentDisposition"},
+		{b2.UploadOptions{
+			Metadata:     map[string]string{"b2-cache-control": "no-cache"},
+			CacheControl: "max-age=3600",
+		}, "conflicts with UploadOptions.CacheControl"},
+		{b2.UploadOptions{
+			Metadata:     map[string]string{"src_last_modified_millis": "0"},
+			LastModified: time.Now(),
+		}, "conflicts with UploadOptions.LastModified"},
+		{b2.UploadOptions{
+			Metadata:   map[string]string{"content-md5": "0"},
+			ContentMD5: true,
+		}, "conflicts with UploadOptions.ContentMD5"},
+		{b2.UploadOptions{
+			Metadata: map[string]string{"my tag": "x"},
+		}, `invalid metadata key "my tag"`},
+		{b2.UploadOptions{
+			Metadata: map[string]string{strings.Repeat("k", 51): "x"},
+		}, "invalid metadata key"},
+		{b2.UploadOptions{
+			Metadata:    map[string]string{"my tag": "x", "my_tag": "y"},
+			InvalidKeys: b2.SanitizeInvalidKeys,
+		}, "collides with another key"},
+		{b2.UploadOptions{
+			ExtraHeaders: http.Header{"content-type": {"text/plain"}},
+		}, `extra header "content-type" is reserved`},
+		{b2.UploadOptions{
+			ExtraHeaders: http.Header{"X-Bz-Info-Foo": {"bar"}},
+		}, `extra header "X-Bz-Info-Foo" is reserved`},
+	} {
+		tt.o.Name = "foo-file"
+		_, err := b.UploadFile(ctx, bytes.NewReader(nil), tt.o)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%v %v: expected error %q, got %v", tt.o.Metadata, tt.o.ExtraHeaders, tt.err, err)
+		}
+	}
+}
+
+func TestUploadSanitizeKeys(t *testing.T) {
+	ctx := context.Background()
+	c := getClient(t, ctx)
+	b := getBucket(t, ctx, c)
+	defer deleteBucket(t, b)
+
+	fi, err := b.UploadFile(ctx, strings.NewReader("data"), b2.UploadOptions{
+		Name:        "foo-file",
+		Metadata:    map[string]string{"user tag/ü": "x", strings.Repeat("k", 60): "y"},
+		InvalidKeys: b2.SanitizeInvalidKeys,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteFile(ctx, fi.ID, fi.Name)
+	if v := fi.CustomMetadata["user_tag__"]; v != "x" {
+		t.Errorf("sanitized key missing: %v", fi.CustomMetadata)
+	}
+	if v := fi.CustomMetadata[strings.Repeat("k", 50)]; v != "y" {
+		t.Errorf("truncated key missing: %v", fi.CustomMetadata)
+	}
+}
+
+func TestUploadContentMD5(t *testing.T) {
+	ctx := context.Background()
+	c := getClient(t, ctx)
+	b := getBucket(t, ctx, c)
+	defer deleteBucket(t, b)
+
+	content := []byte("some data")
+	digest := md5.Sum(content)
+	fi, err := b.UploadFile(ctx, bytes.NewReader(content), b2.UploadOptions{
+		Name:       "foo-file",
+		ContentMD5: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteFile(ctx, fi.ID, fi.Name)
+	if v := fi.CustomMetadata["content-md5"]; v != hex.EncodeToString(digest[:]) {
+		t.Error("wrong content-md5:", v)
+	}
+}
+
+func TestValidateFileName(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		valid bool
+	}{
+		{"a", true},
+		{"dir/sub/file.txt", true},
+		{"héllo wörld", true},
+		{strings.Repeat("x", 1024), true},
+		{strings.Repeat("é", 512), true},
+		{"", false},
+		{strings.Repeat("x", 1025), false},
+		{strings.Repeat("é", 513), false},
+		{"/leading", false},
+		{"trailing/", false},
+		{"double//slash", false},
+		{"nul\x00byte", false},
+		{"tab\tchar", false},
+		{"del\x7fchar", false},
+		{"back\\slash", false},
+		{"bad\xffutf8", false},
+	} {
+		if err := b2.ValidateFileName(tt.name); (err == nil) != tt.valid {
+			t.Errorf("ValidateFileName(%q) = %v", tt.name, err)
+		}
+	}
+}
+
+func TestUploadBufferKeptOnFailure(t *testing.T) {
+	ctx := context.Background()
+	c := getClient(t, ctx)
+	b := getBucket(t, ctx, c)
+	defer deleteBucket(t, b)
+
+	buf := bytes.NewBufferString("retry me")
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := b.Upload(canceled, buf, "test-buffer", "", nil); err == nil {
+		t.Fatal("upload with a canceled context succeeded")
+	}
+	if buf.String() != "retry me" {
+		t.Fatalf("buffer consumed by a failed upload: %q", buf.String())
+	}
+
+	fi, err := b.Upload(ctx, buf, "test-buffer", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteFile(ctx, fi.ID, fi.Name)
+	if fi.ContentLength != int64(len("retry me")) {
+		t.Error("wrong length on retry:", fi.ContentLength)
+	}
+	if buf.Len() != 0 {
+		t.Error("buffer not consumed by a successful upload")
+	}
+}
+
+func TestUploadTestMode(t *testing.T) {
+	ctx := context.Background()
+	c := getClient(t, ctx)
+	b := getBucket(t, ctx, c)
+	defer deleteBucket(t, b)
+	defer deleteAll(t, c, b)
+
+	// Some of these uploads fail on the first attempt, and must be retried.
+	c.TestMode = b2.TestModeFailSomeUploads
+	defer func() { c.TestMode = "" }()
+	for i := 0; i < 10; i++ {
+		if _, err := b.Upload(ctx, strings.NewReader("data"), "test-mode", "", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestUploadWithSHA1Retry(t *testing.T) {
+	ctx := context.Background()
+	c := getClient(t, ctx)
+	b := getBucket(t, ctx, c)
+	defer deleteBucket(t, b)
+
+	content := make([]byte, 123456)
+	rand.Read(content)
+	digest := sha1.Sum(content)
+	sha1Sum := hex.EncodeToString(digest[:])
+
+	r := io.NopCloser(bytes.NewReader(content)) // shadow Seek method
+	fi, err := b.UploadWithSHA1Retry(ctx, r, "foo-file", "", sha1Sum, int64(len(content)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteFile(ctx, fi.ID, fi.Name)
+	if fi.ContentSHA1 != sha1Sum {
+		t.Error("mismatched SHA1:", fi.ContentSHA1)
+	}
+
+	short := io.NopCloser(bytes.NewReader(content[:1000]))
+	if _, err := b.UploadWithSHA1Retry(ctx, short, "foo-file", "", sha1Sum, int64(len(content)), nil); err == nil {
+		t.Error("short stream was uploaded")
+	}
+}
+
+func TestUploadWithSHA1Detailed(t *testing.T) {
+	ctx := context.Background()
+	c := getClient(t, ctx)
+	b := getBucket(t, ctx, c)
+	defer deleteBucket(t, b)
+
+	content := []byte("data")
+	digest := sha1.Sum(content)
+	fi, d, err := b.UploadWithSHA1Detailed(ctx, bytes.NewReader(content), "foo-file", "",
+		hex.EncodeToString(digest[:]), int64(len(content)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.DeleteFile(ctx, fi.ID, fi.Name)
+	if !strings.HasSuffix(d.UploadHost, ".backblazeb2.com") {
+		t.Error("unexpected upload host:", d.UploadHost)
+	}
+}
+
+func TestEncodeContent(t *testing.T) {
+	content := []byte(strings.Repeat("compressible ", 1000))
+	digest := sha1.Sum(content)
+
+	var buf bytes.Buffer
+	hashes, err := b2.EncodeContent(&buf, bytes.NewReader(content), "gzip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := sha1.Sum(buf.Bytes())
+	if hashes.ContentSHA1 != hex.EncodeToString(digest[:]) || hashes.ContentLength != int64(len(content)) {
+		t.Errorf("wrong content hash %+v", hashes)
+	}
+	if hashes.StoredSHA1 != hex.EncodeToString(stored[:]) || hashes.StoredLength != int64(buf.Len()) {
+		t.Errorf("wrong stored hash %+v", hashes)
+	}
+	zr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded, err := io.ReadAll(zr); err != nil || !bytes.Equal(decoded, content) {
+		t.Error("gzip output does not decode to the contents:", err)
+	}
+
+	again, err := b2.EncodeContent(nil, bytes.NewReader(content), "gzip")
+	if err != nil || again != hashes {
+		t.Errorf("gzip output is not stable: %+v, %v", again, err)
+	}
+	plain, err := b2.EncodeContent(nil, bytes.NewReader(content), "")
+	if err != nil || plain.StoredSHA1 != plain.ContentSHA1 || plain.ContentSHA1 != hashes.ContentSHA1 {
+		t.Errorf("wrong identity hashes %+v, %v", plain, err)
+	}
+	if _, err := b2.EncodeContent(nil, bytes.NewReader(content), "br"); err == nil {
+		t.Error("unsupported encoding accepted")
+	}
+}
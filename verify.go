@@ -0,0 +1,172 @@
+package b2
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// ErrNoSHA1 is reported by Verify for large files uploaded without the
+// large_file_sha1 file info key, whose contents can't be checked.
+var ErrNoSHA1 = errors.New("b2: file has no SHA1 to verify")
+
+// Verify downloads the current version of every file in the Bucket, and checks
+// its contents against the SHA1 stored by B2, to detect corruption. Up to n
+// files are downloaded at the same time, or 4 if n is 0.
+//
+// fn is called once per file, possibly from multiple goroutines at once. ok
+// reports whether the contents match. If the file could not be checked, ok is
+// false and err says why: ErrNoSHA1 for large files without a known SHA1, or
+// the download error.
+//
+// Verify returns an error only if the listing fails or ctx is done. It costs
+// a download of the whole bucket, except for skipped large files.
+func (b *Bucket) Verify(ctx context.Context, n int, fn func(fi *FileInfo, ok bool, err error)) error {
+	files := make(chan *FileInfo)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency(n); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for fi := range files {
+				ok, err := b.c.verifyFile(ctx, fi)
+				fn(fi, ok, err)
+			}
+		}()
+	}
+
+	l := b.ListFiles(ctx, ListOptions{})
+	l.SetPageCount(maxCount)
+	var err error
+list:
+	for l.Next() {
+		select {
+		case files <- l.FileInfo():
+		case <-ctx.Done():
+			err = ctx.Err()
+			break list
+		}
+	}
+	close(files)
+	wg.Wait()
+	if err != nil {
+		return err
+	}
+	if err := l.Err(); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// verifyFile reports whether the contents of fi match its SHA1.
+func (c *Client) verifyFile(ctx context.Context, fi *FileInfo) (bool, error) {
+	sha1Sum := strings.TrimPrefix(fi.ContentSHA1, "unverified:")
+	if sha1Sum == "" || sha1Sum == "none" {
+		return false, ErrNoSHA1
+	}
+	rc, _, err := c.DownloadFile(ctx, DownloadOptions{FileID: fi.ID, AcceptEncoding: "identity"})
+	if err != nil {
+		return false, err
+	}
+	defer rc.Close()
+	h := sha1.New()
+	if _, err := io.Copy(h, rc); err != nil {
+		return false, err
+	}
+	return hex.EncodeToString(h.Sum(nil)) == sha1Sum, nil
+}
+
+// PartSHA1MismatchError is returned by DownloadVerifiedParts when the contents of
+// a part of a large file don't match its SHA1. It matches ErrSHA1Mismatch
+// with errors.Is.
+type PartSHA1MismatchError struct {
+	PartNumber int
+}
+
+func (e *PartSHA1MismatchError) Error() string {
+	return fmt.Sprintf("b2: part %d SHA1 mismatch", e.PartNumber)
+}
+
+// Is makes PartSHA1MismatchError match ErrSHA1Mismatch.
+func (e *PartSHA1MismatchError) Is(target error) bool {
+	return target == ErrSHA1Mismatch
+}
+
+// DownloadVerified downloads the file version set by o.FileID or o.FileInfo
+// to w, checking the SHA1 of its contents at the end, and returning
+// ErrSHA1Mismatch if it doesn't match. Large files uploaded without
+// large_file_sha1 have no known SHA1, and are not checked: see
+// DownloadVerifiedParts.
+//
+// The stored bytes are downloaded, as with o.RawBody; o.Range, o.MaxBytes and
+// o.Decompress are not supported. Bytes written to w before an error must be
+// discarded.
+func (c *Client) DownloadVerified(ctx context.Context, o DownloadOptions, w io.Writer) (*FileInfo, error) {
+	return c.DownloadVerifiedParts(ctx, o, nil, w)
+}
+
+// DownloadVerifiedParts is like DownloadVerified, but if parts is not empty,
+// the large file is downloaded one part-aligned range at a time, each checked
+// against the SHA1 of its part, and the first corrupt part stops the download
+// with a PartSHA1MismatchError, instead of hashing the whole file before
+// noticing. parts must describe the whole file, in order.
+//
+// B2 doesn't keep the part SHA1s of a large file once it's finished, and
+// ListParts then fails: they must be recorded at upload, like the SHA1s
+// passed to FinishLargeFile, or listed before finishing.
+func (c *Client) DownloadVerifiedParts(ctx context.Context, o DownloadOptions, parts []PartInfo, w io.Writer) (*FileInfo, error) {
+	if o.Range != (Range{}) || o.MaxBytes > 0 || o.Decompress {
+		return nil, errors.New("DownloadVerified does not support Range, MaxBytes nor Decompress")
+	}
+	fi := o.FileInfo
+	if len(o.FileID) > 0 && (fi == nil || fi.ID != o.FileID) {
+		var err error
+		if fi, err = c.GetFileInfoByID(ctx, o.FileID); err != nil {
+			return nil, err
+		}
+	}
+	if fi == nil {
+		return nil, errors.New("DownloadVerified needs a FileID or FileInfo")
+	}
+	o.FileID, o.FileInfo, o.RawBody = fi.ID, nil, true
+
+	if len(parts) == 0 {
+		h := sha1.New()
+		if _, err := c.DownloadTo(ctx, o, io.MultiWriter(w, h)); err != nil {
+			return nil, err
+		}
+		if err := checkSHA1(h, fi.ContentSHA1); err != nil {
+			return nil, err
+		}
+		return fi, nil
+	}
+
+	var total int64
+	for i, p := range parts {
+		if p.PartNumber != i+1 {
+			return nil, fmt.Errorf("part %d listed in position %d", p.PartNumber, i+1)
+		}
+		total += p.ContentLength
+	}
+	if total != fi.ContentLength {
+		return nil, fmt.Errorf("parts add up to %d bytes, but the file has %d", total, fi.ContentLength)
+	}
+	var offset int64
+	for _, p := range parts {
+		o.Range = Range{Begin: offset, End: offset + p.ContentLength - 1}
+		offset += p.ContentLength
+		h := sha1.New()
+		if _, err := c.DownloadTo(ctx, o, io.MultiWriter(w, h)); err != nil {
+			return nil, err
+		}
+		if hex.EncodeToString(h.Sum(nil)) != p.ContentSHA1 {
+			return nil, &PartSHA1MismatchError{PartNumber: p.PartNumber}
+		}
+	}
+	return fi, nil
+}
@@ -0,0 +1,68 @@
+package b2
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDownloadVerified(t *testing.T) {
+	ctx := context.Background()
+	data := bytes.Repeat([]byte("0123456789abcdef"), 4)
+	var parts []PartInfo
+	for i := 0; i < 4; i++ {
+		sum := sha1.Sum(data[i*16 : (i+1)*16])
+		parts = append(parts, PartInfo{PartNumber: i + 1, ContentLength: 16, ContentSHA1: hex.EncodeToString(sum[:])})
+	}
+	tr := &rangeTransport{id: "id", data: data}
+	c := &Client{hc: &http.Client{Transport: tr}}
+	c.loginInfo.Store(&LoginInfo{ApiURL: "https://api.example.com", DownloadURL: "https://download.example.com"})
+	fi := &FileInfo{ID: "id", ContentLength: int64(len(data)), ContentSHA1: "none", Action: FileUpload}
+
+	var buf bytes.Buffer
+	if _, err := c.DownloadVerifiedParts(ctx, DownloadOptions{FileInfo: fi}, parts, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("got %q", buf.Bytes())
+	}
+	if len(tr.ranges) != 4 || tr.ranges[3] != "bytes=48-63" {
+		t.Errorf("downloaded ranges %q", tr.ranges)
+	}
+
+	parts[2].ContentSHA1 = strings.Repeat("0", 40)
+	tr.ranges = nil
+	_, err := c.DownloadVerifiedParts(ctx, DownloadOptions{FileInfo: fi}, parts, io.Discard)
+	var pe *PartSHA1MismatchError
+	if !errors.As(err, &pe) || pe.PartNumber != 3 || !errors.Is(err, ErrSHA1Mismatch) {
+		t.Errorf("got %v, want a mismatch of part 3", err)
+	}
+	if len(tr.ranges) != 3 {
+		t.Errorf("did not stop at the corrupt part: %q", tr.ranges)
+	}
+	if _, err := c.DownloadVerifiedParts(ctx, DownloadOptions{FileInfo: fi}, parts[:3], io.Discard); err == nil {
+		t.Error("parts not covering the whole file accepted")
+	}
+
+	// Without parts, the whole file is checked, in a single request.
+	tr.ranges = nil
+	sum := sha1.Sum(data)
+	fi.ContentSHA1 = hex.EncodeToString(sum[:])
+	buf.Reset()
+	if _, err := c.DownloadVerified(ctx, DownloadOptions{FileInfo: fi}, &buf); err != nil || !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("whole file: %v", err)
+	}
+	if len(tr.ranges) != 1 {
+		t.Errorf("%d requests, want a single download", len(tr.ranges))
+	}
+	fi.ContentSHA1 = strings.Repeat("0", 40)
+	if _, err := c.DownloadVerified(ctx, DownloadOptions{FileInfo: fi}, io.Discard); err != ErrSHA1Mismatch {
+		t.Errorf("got %v, want ErrSHA1Mismatch", err)
+	}
+}